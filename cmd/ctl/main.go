@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lanthora/uranus/internal/apply"
+	"github.com/lanthora/uranus/internal/common"
+	"github.com/lanthora/uranus/internal/ctl"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/spf13/viper"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] <command> [arguments]\n\nOptions:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintln(flag.CommandLine.Output())
+	ctl.Usage(flag.CommandLine.Output())
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+// openLocalDB 根据 uranus-web 的配置文件打开同一个数据库
+func openLocalDB(path string) (db *sql.DB, err error) {
+	config := viper.New()
+	config.SetConfigFile(path)
+	if err = config.ReadInConfig(); err != nil {
+		return
+	}
+	// 数据库不存在时说明配置文件不正确,不应该创建新的空数据库
+	if _, err = os.Stat(strings.TrimPrefix(config.GetString("db"), "file:")); err != nil {
+		return
+	}
+	// 与 uranus-web 使用同一个锁文件,串行执行策略变更
+	if err = apply.Lock(common.GetLockFileFromConfig(config)); err != nil {
+		return
+	}
+	db, err = sql.Open("sqlite3", common.GetDataSourceNameFromConfig(config))
+	if err != nil {
+		return
+	}
+	err = db.Ping()
+	return
+}
+
+func main() {
+	// 配置文件是可选的,也可以通过 URANUS_ 开头的环境变量或者命令行参数指定,命令行参数优先
+	config := viper.New()
+	config.SetConfigName("ctl")
+	config.SetConfigType("yaml")
+	config.AddConfigPath("$HOME/.config/hackernel")
+	config.AddConfigPath("/etc/hackernel")
+	config.SetEnvPrefix("uranus")
+	config.AutomaticEnv()
+	config.SetDefault("server", "http://127.0.0.1")
+	config.SetDefault("output", ctl.FormatTable)
+	if err := config.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			fatal(err)
+		}
+	}
+
+	options := map[string]string{
+		"server":      "uranus-web address",
+		"token":       "API token",
+		"username":    "login username",
+		"password":    "login password",
+		"code":        "TOTP code or recovery code",
+		"ca":          "CA certificate file",
+		"fingerprint": "sha256 fingerprint of the server certificate",
+		"output":      "output format, table or json",
+	}
+	flags := map[string]*string{}
+	for name, description := range options {
+		flags[name] = flag.String(name, "", description)
+	}
+	local := flag.Bool("local", false, "read and write the uranus-web database directly instead of calling the web API")
+	push := flag.Bool("push", false, "push local mode changes to hackernel, otherwise they take effect when uranus-web restarts")
+	webConfig := flag.String("web-config", "/etc/hackernel/web.yaml", "uranus-web config file used to find the database in local mode")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	for name, value := range flags {
+		if *value != "" {
+			config.Set(name, *value)
+		}
+	}
+
+	tlsConfig, err := common.NewTLSConfig(config.GetString("ca"), config.GetString("fingerprint"))
+	if err != nil {
+		fatal(err)
+	}
+
+	ctlConfig := ctl.Config{
+		Server:    config.GetString("server"),
+		Token:     config.GetString("token"),
+		Username:  config.GetString("username"),
+		Password:  config.GetString("password"),
+		Code:      config.GetString("code"),
+		Format:    config.GetString("output"),
+		TLSConfig: tlsConfig,
+	}
+
+	if *local {
+		db, err := openLocalDB(*webConfig)
+		if err != nil {
+			fatal(err)
+		}
+		defer db.Close()
+		ctlConfig.DB = db
+		ctlConfig.Push = *push
+	}
+
+	err = ctl.Run(ctlConfig, flag.Args(), os.Stdout)
+	if errors.Is(err, ctl.ErrorUsage) || errors.Is(err, ctl.ErrorUnknownCommand) {
+		fmt.Fprintln(os.Stderr, err)
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"path/filepath"
 	"syscall"
 
+	"github.com/lanthora/uranus/internal/common"
+	"github.com/lanthora/uranus/internal/i18n"
 	"github.com/lanthora/uranus/internal/notify"
 	"github.com/lanthora/uranus/pkg/logger"
 	"github.com/sirupsen/logrus"
@@ -28,6 +30,10 @@ func main() {
 		logrus.Fatal(err)
 	}
 
+	if err := common.InitLoggerFromConfig(config); err != nil {
+		logrus.Fatal(err)
+	}
+
 	cacheFilePath := fmt.Sprintf("%s/.cache/hackernel/notify.yaml", os.Getenv("HOME"))
 	cache := viper.New()
 	cache.SetConfigName("notify")
@@ -47,11 +53,18 @@ func main() {
 	server := config.GetString("server")
 	username := config.GetString("username")
 	password := config.GetString("password")
+	token := config.GetString("token")
+	language := i18n.Match(config.GetString("language"))
 	processEventOffset := cache.GetInt64("process-event-offset")
 	fileEventOffset := cache.GetInt64("file-event-offset")
 	netEventOffset := cache.GetInt64("net-event-offset")
 
-	notifier := notify.NewWorker(server, username, password, processEventOffset, fileEventOffset, netEventOffset)
+	tlsConfig, err := common.NewTLSConfig(config.GetString("ca"), config.GetString("fingerprint"))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	notifier := notify.NewWorker(server, username, password, token, language, tlsConfig, processEventOffset, fileEventOffset, netEventOffset)
 	notifier.Start()
 
 	sig := <-sigchan
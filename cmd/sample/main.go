@@ -6,9 +6,11 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/lanthora/uranus/internal/common"
 	"github.com/lanthora/uranus/internal/sample"
 	"github.com/lanthora/uranus/pkg/logger"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 )
 
 func main() {
@@ -17,6 +19,21 @@ func main() {
 	sigchan := make(chan os.Signal, 1)
 	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
 
+	// 示例程序的配置文件是可选的,仅用于调整日志
+	config := viper.New()
+	config.SetConfigName("sample")
+	config.SetConfigType("yaml")
+	config.AddConfigPath("/etc/hackernel")
+	if err := config.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			logrus.Fatal(err)
+		}
+	}
+
+	if err := common.InitLoggerFromConfig(config); err != nil {
+		logrus.Fatal(err)
+	}
+
 	sampleWorker := sample.NewWorker()
 	sampleWorker.Start()
 
@@ -9,6 +9,7 @@ import (
 	"syscall"
 
 	"github.com/lanthora/uranus/internal/common"
+	"github.com/lanthora/uranus/internal/i18n"
 	"github.com/lanthora/uranus/internal/telegram"
 	"github.com/lanthora/uranus/internal/worker"
 	"github.com/lanthora/uranus/pkg/logger"
@@ -36,6 +37,10 @@ func main() {
 		logrus.Fatal(err)
 	}
 
+	if err := common.InitLoggerFromConfig(config); err != nil {
+		logrus.Fatal(err)
+	}
+
 	token := config.GetString("token")
 	if len(token) == 0 {
 		logrus.Fatal(ErrorInvalidToken)
@@ -45,6 +50,8 @@ func main() {
 		logrus.Fatal(ErrorInvalidOwner)
 	}
 
+	language := i18n.Match(config.GetString("language"))
+
 	dataSourceName := common.GetDataSourceNameFromConfig(config)
 	db, err := sql.Open("sqlite3", dataSourceName)
 	if err != nil {
@@ -52,7 +59,7 @@ func main() {
 	}
 	defer db.Close()
 
-	telegramWorker := telegram.NewWorker(token, ownerID)
+	telegramWorker := telegram.NewWorker(token, ownerID, language)
 	processWorker := worker.NewProcessWorker(db)
 
 	if err := telegram.SetStandaloneMode(db); err != nil {
@@ -7,8 +7,10 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/lanthora/uranus/internal/apply"
 	"github.com/lanthora/uranus/internal/common"
 	"github.com/lanthora/uranus/internal/web"
+	"github.com/lanthora/uranus/internal/web/user"
 	"github.com/lanthora/uranus/internal/worker"
 	"github.com/lanthora/uranus/pkg/logger"
 	_ "github.com/mattn/go-sqlite3"
@@ -30,6 +32,13 @@ func main() {
 		logrus.Fatal(err)
 	}
 
+	if err := common.InitLoggerFromConfig(config); err != nil {
+		logrus.Fatal(err)
+	}
+
+	config.SetDefault("tls.self-signed-dir", "/var/lib/hackernel")
+	config.SetDefault("drift.interval", "10m")
+
 	listen := config.GetString("listen")
 	dataSourceName := common.GetDataSourceNameFromConfig(config)
 	db, err := sql.Open("sqlite3", dataSourceName)
@@ -38,10 +47,38 @@ func main() {
 	}
 	defer db.Close()
 
+	if lockFile := common.GetLockFileFromConfig(config); lockFile != "" {
+		if err := apply.Lock(lockFile); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
 	processWorker := worker.NewProcessWorker(db)
 	fileWorker := worker.NewFileWorker(db)
 	netWorker := worker.NewNetWorker(db)
-	webWorker := web.NewWorker(listen, db)
+	driftWorker := worker.NewDriftWorker(db, worker.DriftConfig{
+		Interval: config.GetDuration("drift.interval"),
+		Heal:     config.GetBool("drift.heal"),
+	}, processWorker, fileWorker, netWorker)
+	webWorker := web.NewWorker(web.Config{
+		Listen: listen,
+		Session: user.SessionConfig{
+			IdleTimeout:     config.GetDuration("session.idle-timeout"),
+			AbsoluteTimeout: config.GetDuration("session.absolute-timeout"),
+			Capacity:        config.GetInt("session.capacity"),
+		},
+		Login: user.LoginConfig{
+			MaxFailures: config.GetInt("login.max-failures"),
+			Lockout:     config.GetDuration("login.lockout"),
+		},
+		TLS: web.TLSConfig{
+			Enable:        config.GetBool("tls.enable"),
+			Cert:          config.GetString("tls.cert"),
+			Key:           config.GetString("tls.key"),
+			SelfSignedDir: config.GetString("tls.self-signed-dir"),
+			Redirect:      config.GetString("tls.redirect"),
+		},
+	}, db)
 
 	if err := processWorker.Init(); err != nil {
 		logrus.Fatal(err)
@@ -55,6 +92,10 @@ func main() {
 		logrus.Fatal(err)
 	}
 
+	if err := driftWorker.Init(); err != nil {
+		logrus.Fatal(err)
+	}
+
 	if err := webWorker.Init(); err != nil {
 		logrus.Fatal(err)
 	}
@@ -71,6 +112,10 @@ func main() {
 		logrus.Fatal(err)
 	}
 
+	if err := driftWorker.Start(); err != nil {
+		logrus.Fatal(err)
+	}
+
 	if err := webWorker.Start(); err != nil {
 		logrus.Fatal(err)
 	}
@@ -81,6 +126,7 @@ func main() {
 	logrus.Info(sig)
 
 	webWorker.Stop()
+	driftWorker.Stop()
 	processWorker.Stop()
 	fileWorker.Stop()
 	netWorker.Stop()
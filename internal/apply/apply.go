@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package apply
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrorPushFailed = errors.New("push to hackernel failed")
+)
+
+// Change 描述一次需要同时修改数据库和 hackernel 的策略变更.
+// 与 hackernel 通信期间不持有事务: Write 在第一个事务中检查请求,需要 id 时只占用自增 id,不留下记录,
+// Push 在事务外下发, hackernel 确认后 Commit 在第二个事务中写入最终结果.
+// Push 返回错误时必须保证 hackernel 中的策略没有被修改, hackernel 执行失败的错误需要包含 ErrorPushFailed.
+// Revert 在 Commit 失败时撤销 Push 对 hackernel 的修改.
+// Offline 为 true 时不执行 Push 和 Revert,只修改数据库,由 uranus-web 下次启动时按数据库下发
+type Change struct {
+	Write   func(tx *sql.Tx) error
+	Push    func() error
+	Commit  func(tx *sql.Tx) error
+	Revert  func()
+	Offline bool
+}
+
+var (
+	// mutex 保证策略变更串行执行,数据库与 hackernel 中策略的修改顺序一致
+	mutex sync.Mutex
+	// lockFile 在进程间串行执行策略变更,例如 uranus-web 运行时使用 uranus-ctl --local --push
+	lockFile *os.File
+)
+
+// Lock 设置进程间共享的锁文件,使用同一个数据库的进程需要设置相同的文件
+func Lock(path string) (err error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if lockFile != nil {
+		lockFile.Close()
+	}
+	lockFile = file
+	return
+}
+
+// lock 依次获取进程内和进程间的锁,返回释放锁的函数
+func lock() (unlock func()) {
+	mutex.Lock()
+	if lockFile == nil {
+		return mutex.Unlock
+	}
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		logrus.Errorf("lock %s failed: %v", lockFile.Name(), err)
+		return mutex.Unlock
+	}
+	return func() {
+		syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+		mutex.Unlock()
+	}
+}
+
+// transaction 在一个事务中执行 fn, fn 为空时不开启事务
+func transaction(db *sql.DB, fn func(tx *sql.Tx) error) (err error) {
+	if fn == nil {
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	if err = fn(tx); err != nil {
+		return
+	}
+	return tx.Commit()
+}
+
+// Run 依次执行 Write, Push 和 Commit,每个事务只在访问数据库时短暂持有写锁.
+// 进程在 Push 之后 Commit 之前退出时数据库中没有这次变更, uranus-web 下次启动时清空 hackernel 并按数据库下发
+func Run(db *sql.DB, change Change) (err error) {
+	defer lock()()
+
+	if change.Offline {
+		change.Push, change.Revert = nil, nil
+	}
+	if err = transaction(db, change.Write); err != nil {
+		return
+	}
+	if change.Push != nil {
+		if err = change.Push(); err != nil {
+			return
+		}
+	}
+	if err = transaction(db, change.Commit); err != nil {
+		logrus.Errorf("commit policy change failed, revert hackernel: %v", err)
+		if change.Revert != nil {
+			change.Revert()
+		}
+	}
+	return
+}
+
+// Pushed 把 hackernel 接口返回的执行结果转换成错误
+func Pushed(ok bool) error {
+	if !ok {
+		return ErrorPushFailed
+	}
+	return nil
+}
+
+// PushError 给 hackernel 接口返回的错误加上 ErrorPushFailed
+func PushError(err error) error {
+	if err == nil || errors.Is(err, ErrorPushFailed) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrorPushFailed, err)
+}
+
+// Exclusive 在没有其他策略变更时执行 fn, 用于重放或核对 hackernel 中的策略
+func Exclusive(fn func() error) error {
+	defer lock()()
+	return fn()
+}
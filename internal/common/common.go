@@ -2,10 +2,13 @@
 package common
 
 import (
+	"database/sql"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/lanthora/uranus/pkg/logger"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
@@ -28,3 +31,64 @@ func GetDataSourceNameFromConfig(config *viper.Viper) (dataSourceName string) {
 	dataSourceName = "file:" + dbFile + dbOptions
 	return
 }
+
+// GetLockFileFromConfig 返回与数据库对应的锁文件,内存数据库不和其他进程共享,不需要锁文件
+func GetLockFileFromConfig(config *viper.Viper) (lockFile string) {
+	dbFile := strings.TrimPrefix(config.GetString("db"), "file:")
+	if dbFile == ":memory:" {
+		return
+	}
+	lockFile = dbFile + ".lock"
+	return
+}
+
+// InitLoggerFromConfig 读取配置文件中的 log 配置块并初始化日志
+func InitLoggerFromConfig(config *viper.Viper) (err error) {
+	loggerConfig := logger.Config{
+		Level:      config.GetString("log.level"),
+		Format:     config.GetString("log.format"),
+		Output:     config.GetString("log.output"),
+		MaxSize:    config.GetInt64("log.max-size") << 20,
+		MaxBackups: config.GetInt("log.max-backups"),
+		Fields:     config.GetStringMapString("log.fields"),
+	}
+	err = logger.Init(loggerConfig)
+	return
+}
+
+// AddColumnIfNotExists 为已有的表补充新版本增加的列,用于数据库升级
+func AddColumnIfNotExists(db *sql.DB, table, column, definition string) (err error) {
+	rows, err := db.Query(fmt.Sprintf("pragma table_info(%s)", table))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return
+	}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		name := ""
+		for i := range values {
+			values[i] = new(interface{})
+			if columns[i] == "name" {
+				values[i] = &name
+			}
+		}
+		if err = rows.Scan(values...); err != nil {
+			return
+		}
+		if name == column {
+			return
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	rows.Close()
+
+	_, err = db.Exec(fmt.Sprintf("alter table %s add column %s %s", table, column, definition))
+	return
+}
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package common
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+)
+
+var (
+	ErrorInvalidCA           = errors.New("no valid certificate found in ca file")
+	ErrorFingerprintMismatch = errors.New("server certificate fingerprint mismatch")
+)
+
+// NewTLSConfig 根据 CA 文件或证书指纹生成校验服务端证书的配置.
+// 指定指纹时只信任指纹匹配的证书,适用于 uranus-web 的自签名证书,
+// 同时指定 CA 时证书还需要通过 CA 的校验.
+func NewTLSConfig(ca, fingerprint string) (config *tls.Config, err error) {
+	config = &tls.Config{}
+
+	if ca != "" {
+		data, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, ErrorInvalidCA
+		}
+		config.RootCAs = pool
+	}
+
+	if fingerprint != "" {
+		// 跳过默认校验后在 VerifyConnection 中校验指纹,同时配置了 CA 时仍然校验证书链和主机名
+		expected := strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+		roots := config.RootCAs
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return ErrorFingerprintMismatch
+			}
+			leaf := state.PeerCertificates[0]
+			sum := sha256.Sum256(leaf.Raw)
+			if hex.EncodeToString(sum[:]) != expected {
+				return ErrorFingerprintMismatch
+			}
+			if roots == nil {
+				return nil
+			}
+			intermediates := x509.NewCertPool()
+			for _, cert := range state.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err := leaf.Verify(x509.VerifyOptions{
+				Roots:         roots,
+				Intermediates: intermediates,
+				DNSName:       state.ServerName,
+			})
+			return err
+		}
+	}
+	return
+}
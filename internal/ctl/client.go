@@ -0,0 +1,125 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package ctl
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+	"time"
+
+	"github.com/lanthora/uranus/internal/web/render"
+	"github.com/lanthora/uranus/internal/web/user"
+)
+
+var (
+	ErrorNoCredential = errors.New("token or username and password is required")
+)
+
+// StatusError 是服务端返回的非成功状态
+type StatusError struct {
+	Status  int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s (status: %d)", e.Message, e.Status)
+}
+
+// Client 调用 uranus-web 的接口,使用 API Token 或者用户名密码登录后的会话认证
+type Client struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+func NewClient(server, token string, tlsConfig *tls.Config) (c *Client, err error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return
+	}
+	c = &Client{
+		server: strings.TrimSuffix(server, "/"),
+		token:  token,
+		client: &http.Client{
+			Jar:       jar,
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}
+	return
+}
+
+// Login 使用用户名密码登录,启用了动态口令的用户需要提供 code
+func (c *Client) Login(username, password, code string) (err error) {
+	request := map[string]string{
+		"username": username,
+		"password": password,
+		"code":     code,
+	}
+	err = c.Call("/auth/login", request, nil)
+	return
+}
+
+func (c *Client) Logout() (err error) {
+	if c.token != "" {
+		return
+	}
+	err = c.Call("/auth/logout", struct{}{}, nil)
+	return
+}
+
+// Call 以 JSON 格式发送请求,并把响应中的 data 解析到 data 中, data 为 nil 时忽略响应数据
+func (c *Client) Call(path string, request, data interface{}) (err error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return
+	}
+
+	httpRequest, err := http.NewRequest(http.MethodPost, c.server+path, bytes.NewBuffer(body))
+	if err != nil {
+		return
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		httpRequest.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	for _, cookie := range c.client.Jar.Cookies(httpRequest.URL) {
+		if cookie.Name == user.CSRFCookie {
+			httpRequest.Header.Set(user.CSRFHeader, cookie.Value)
+		}
+	}
+
+	resp, err := c.client.Do(httpRequest)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+
+	response := struct {
+		Status  int             `json:"status"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}{}
+	if err = json.Unmarshal(content, &response); err != nil {
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(content))
+	}
+	if response.Status != render.StatusSuccess {
+		return &StatusError{Status: response.Status, Message: response.Message}
+	}
+	if data == nil || len(response.Data) == 0 {
+		return
+	}
+	err = json.Unmarshal(response.Data, data)
+	return
+}
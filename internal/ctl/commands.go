@@ -0,0 +1,396 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package ctl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lanthora/uranus/pkg/file"
+	"github.com/lanthora/uranus/pkg/net"
+	"github.com/lanthora/uranus/pkg/process"
+)
+
+var (
+	ErrorInvalidJudge = errors.New("judge must be disable, audit or defense")
+	ErrorInvalidPerm  = errors.New("perm must be a non-negative integer or comma-separated names")
+	ErrorNoUpdate     = errors.New("nothing to update")
+)
+
+var judges = map[string]int{
+	"disable": process.StatusJudgeDisable,
+	"audit":   process.StatusJudgeAudit,
+	"defense": process.StatusJudgeDefense,
+}
+
+var (
+	processEventColumns = []string{"id", "status", "judge", "count", "workdir", "binary", "argv"}
+	filePolicyColumns   = []string{"id", "status", "perm", "permNames", "fsid", "ino", "rule", "path"}
+	fileEventColumns    = []string{"id", "status", "perm", "permNames", "policy", "timestamp", "path", "pid", "binary"}
+	netPolicyColumns    = []string{"id", "rule"}
+	netEventColumns     = []string{"id", "status", "policy", "protocol", "family", "saddr", "sport", "daddr", "dport", "timestamp"}
+	userColumns         = []string{"userID", "username", "aliasName", "role", "totpEnabled", "language"}
+)
+
+type page struct {
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// netEventPage 的 Family 为 0 时不按协议族过滤
+type netEventPage struct {
+	page
+	Family int `json:"family"`
+}
+
+type idRequest struct {
+	ID int64 `json:"id"`
+}
+
+func parsePage(name string, args []string) (request page, err error) {
+	flags := newFlagSet(name)
+	flags.IntVar(&request.Limit, "limit", 20, "")
+	flags.IntVar(&request.Offset, "offset", 0, "")
+	_, err = parseArgs(flags, args, 0)
+	return
+}
+
+func parseIDArg(name string, args []string) (request idRequest, err error) {
+	rest, err := parseArgs(newFlagSet(name), args, 1)
+	if err != nil {
+		return
+	}
+	request.ID, err = parseID(rest[0])
+	return
+}
+
+func (w *Ctl) list(path string, args []string, columns []string) (err error) {
+	request, err := parsePage(path, args)
+	if err != nil {
+		return
+	}
+	data := []interface{}{}
+	if err = w.client.Call(path, request, &data); err != nil {
+		return
+	}
+	err = w.printer.Print(data, columns...)
+	return
+}
+
+func (w *Ctl) moduleCall(action string, args []string, data interface{}) (err error) {
+	rest, err := parseArgs(newFlagSet(action), args, 1)
+	if err != nil {
+		return
+	}
+	module, err := parseModule(rest[0])
+	if err != nil {
+		return
+	}
+	err = w.client.Call("/"+module+"/"+action, struct{}{}, data)
+	return
+}
+
+func (w *Ctl) showModuleStatus(args []string) (err error) {
+	data := map[string]interface{}{}
+	if err = w.moduleCall("showModuleStatus", args, &data); err != nil {
+		return
+	}
+	err = w.printer.Print(data, "status", "policyCount", "unreadEventCount")
+	return
+}
+
+func (w *Ctl) enableModule(args []string) error {
+	return w.moduleCall("enableModule", args, nil)
+}
+
+func (w *Ctl) disableModule(args []string) error {
+	return w.moduleCall("disableModule", args, nil)
+}
+
+func (w *Ctl) showJudge(args []string) (err error) {
+	if _, err = parseArgs(newFlagSet("judge"), args, 0); err != nil {
+		return
+	}
+	data := struct {
+		Judge int `json:"judge"`
+	}{}
+	if err = w.client.Call("/process/showWorkMode", struct{}{}, &data); err != nil {
+		return
+	}
+	for name, judge := range judges {
+		if judge == data.Judge {
+			return w.printer.Print(map[string]interface{}{"judge": name})
+		}
+	}
+	err = w.printer.Print(data)
+	return
+}
+
+func (w *Ctl) updateJudge(args []string) (err error) {
+	rest, err := parseArgs(newFlagSet("judge"), args, 1)
+	if err != nil {
+		return
+	}
+	judge, ok := judges[rest[0]]
+	if !ok {
+		return ErrorInvalidJudge
+	}
+	request := map[string]int{"judge": judge}
+	err = w.client.Call("/process/updateWorkMode", request, nil)
+	return
+}
+
+func (w *Ctl) listProcessEvents(args []string) error {
+	return w.list("/process/listEvents", args, processEventColumns)
+}
+
+func (w *Ctl) updateProcessEventStatus(args []string, status int) (err error) {
+	request, err := parseIDArg("process", args)
+	if err != nil {
+		return
+	}
+	err = w.client.Call("/process/updateEventStatus", map[string]int64{"id": request.ID, "status": int64(status)}, nil)
+	return
+}
+
+func (w *Ctl) trustProcess(args []string) error {
+	return w.updateProcessEventStatus(args, process.StatusTrusted)
+}
+
+func (w *Ctl) untrustProcess(args []string) error {
+	return w.updateProcessEventStatus(args, process.StatusUntrusted)
+}
+
+func (w *Ctl) listFilePolicies(args []string) error {
+	return w.list("/file/listPolicies", args, filePolicyColumns)
+}
+
+func (w *Ctl) addFilePolicy(args []string) (err error) {
+	rest, err := parseArgs(newFlagSet("file"), args, 2)
+	if err != nil {
+		return
+	}
+	// 权限可以是整数,也可以是 write,unlink 形式的名称列表
+	perm, err := strconv.Atoi(rest[1])
+	if err != nil {
+		perm, err = file.ParsePerm(strings.Split(rest[1], ","))
+	}
+	if err != nil || perm < 0 || perm&^file.PermAll != 0 {
+		return ErrorInvalidPerm
+	}
+	request := map[string]interface{}{"path": rest[0], "perm": perm}
+	err = w.client.Call("/file/addPolicy", request, nil)
+	return
+}
+
+func (w *Ctl) deleteFilePolicy(args []string) (err error) {
+	request, err := parseIDArg("file", args)
+	if err != nil {
+		return
+	}
+	err = w.client.Call("/file/deletePolicy", request, nil)
+	return
+}
+
+func (w *Ctl) listFileEvents(args []string) error {
+	return w.list("/file/listEvents", args, fileEventColumns)
+}
+
+// listNetPolicies 表格格式使用规则文本输出网络策略
+func (w *Ctl) listNetPolicies(args []string) (err error) {
+	request, err := parsePage("net", args)
+	if err != nil {
+		return
+	}
+	policies := []net.Policy{}
+	if err = w.client.Call("/net/listPolicies", request, &policies); err != nil {
+		return
+	}
+	if w.printer.format == FormatJSON {
+		return w.printer.Print(policies)
+	}
+	rows := []map[string]interface{}{}
+	for _, policy := range policies {
+		rows = append(rows, map[string]interface{}{"id": policy.ID, "rule": policy.Rule()})
+	}
+	err = w.printer.Print(rows, netPolicyColumns...)
+	return
+}
+
+// readNetPolicy 解析文本形式的网络策略,也可以从参数或者标准输入读取 JSON 格式的网络策略
+func readNetPolicy(args []string) (policy net.Policy, err error) {
+	if len(args) == 0 {
+		err = fmt.Errorf("%w: missing policy", ErrorUsage)
+		return
+	}
+	if args[0] != "-" && !strings.HasPrefix(args[0], "{") {
+		policy, err = net.ParseRule(strings.Join(args, " "))
+		return
+	}
+
+	content := []byte(args[0])
+	if args[0] == "-" {
+		if content, err = io.ReadAll(os.Stdin); err != nil {
+			return
+		}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&policy)
+	return
+}
+
+func (w *Ctl) addNetPolicy(args []string) (err error) {
+	flags := newFlagSet("net")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	policy, err := readNetPolicy(flags.Args())
+	if err != nil {
+		return
+	}
+	err = w.client.Call("/net/addPolicy", policy, nil)
+	return
+}
+
+// updateNetPolicy 替换指定 id 的网络策略, JSON 格式的策略中的 id 会被忽略
+func (w *Ctl) updateNetPolicy(args []string) (err error) {
+	flags := newFlagSet("net")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	rest := flags.Args()
+	if len(rest) < 2 {
+		return fmt.Errorf("%w: expected policy id and policy", ErrorUsage)
+	}
+	id, err := parseID(rest[0])
+	if err != nil {
+		return
+	}
+	policy, err := readNetPolicy(rest[1:])
+	if err != nil {
+		return
+	}
+	policy.ID = id
+	err = w.client.Call("/net/updatePolicy", policy, nil)
+	return
+}
+
+func (w *Ctl) deleteNetPolicy(args []string) (err error) {
+	request, err := parseIDArg("net", args)
+	if err != nil {
+		return
+	}
+	err = w.client.Call("/net/deletePolicy", request, nil)
+	return
+}
+
+func (w *Ctl) listNetEvents(args []string) (err error) {
+	request := netEventPage{}
+	flags := newFlagSet("net events")
+	flags.IntVar(&request.Limit, "limit", 20, "")
+	flags.IntVar(&request.Offset, "offset", 0, "")
+	flags.IntVar(&request.Family, "family", 0, "")
+	if _, err = parseArgs(flags, args, 0); err != nil {
+		return
+	}
+	if request.Family != 0 && request.Family != net.FamilyIPv4 && request.Family != net.FamilyIPv6 {
+		return ErrorInvalidFamily
+	}
+	data := []interface{}{}
+	if err = w.client.Call("/net/listEvents", request, &data); err != nil {
+		return
+	}
+	err = w.printer.Print(data, netEventColumns...)
+	return
+}
+
+func (w *Ctl) listUsers(args []string) (err error) {
+	if _, err = parseArgs(newFlagSet("user"), args, 0); err != nil {
+		return
+	}
+	data := []interface{}{}
+	if err = w.client.Call("/admin/listAllUsers", struct{}{}, &data); err != nil {
+		return
+	}
+	err = w.printer.Print(data, userColumns...)
+	return
+}
+
+func (w *Ctl) addUser(args []string) (err error) {
+	request := struct {
+		Username  string `json:"username"`
+		Password  string `json:"password"`
+		AliasName string `json:"aliasName"`
+		Role      string `json:"role"`
+	}{}
+	flags := newFlagSet("user")
+	flags.StringVar(&request.Password, "password", "", "")
+	flags.StringVar(&request.AliasName, "alias", "", "")
+	flags.StringVar(&request.Role, "role", "", "")
+	rest, err := parseArgs(flags, args, 1)
+	if err != nil {
+		return
+	}
+	request.Username = rest[0]
+	if request.AliasName == "" {
+		request.AliasName = request.Username
+	}
+	err = w.client.Call("/admin/addUser", request, nil)
+	return
+}
+
+// updateUser 只修改通过参数指定的字段
+func (w *Ctl) updateUser(args []string) (err error) {
+	request := map[string]interface{}{}
+	flags := newFlagSet("user")
+	fields := map[string]string{"username": "username", "password": "password", "alias": "aliasName", "role": "role"}
+	for name := range fields {
+		flags.String(name, "", "")
+	}
+	rest, err := parseArgs(flags, args, 1)
+	if err != nil {
+		return
+	}
+	userID, err := parseID(rest[0])
+	if err != nil {
+		return
+	}
+	flags.Visit(func(f *flag.Flag) {
+		request[fields[f.Name]] = f.Value.String()
+	})
+	if len(request) == 0 {
+		return ErrorNoUpdate
+	}
+	request["userID"] = userID
+	err = w.client.Call("/admin/updateUserInfo", request, nil)
+	return
+}
+
+func (w *Ctl) deleteUser(args []string) (err error) {
+	request, err := parseIDArg("user", args)
+	if err != nil {
+		return
+	}
+	err = w.client.Call("/admin/deleteUser", map[string]int64{"userID": request.ID}, nil)
+	return
+}
+
+func (w *Ctl) listUserSessions(args []string) (err error) {
+	request, err := parseIDArg("user", args)
+	if err != nil {
+		return
+	}
+	data := []interface{}{}
+	if err = w.client.Call("/admin/listUserSessions", map[string]int64{"userID": request.ID}, &data); err != nil {
+		return
+	}
+	err = w.printer.Print(data)
+	return
+}
@@ -0,0 +1,187 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package ctl
+
+import (
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+var (
+	ErrorUsage          = errors.New("invalid usage")
+	ErrorUnknownCommand = errors.New("unknown command")
+	ErrorInvalidModule  = errors.New("module must be process, file or net")
+	ErrorInvalidID      = errors.New("id must be a positive integer")
+	ErrorInvalidFamily  = errors.New("family must be 4 or 6")
+)
+
+type Config struct {
+	Server    string
+	Token     string
+	Username  string
+	Password  string
+	Code      string
+	Format    string
+	TLSConfig *tls.Config
+
+	// DB 不为空时使用本地模式,直接读写 uranus-web 的数据库
+	DB   *sql.DB
+	Push bool
+}
+
+// caller 是 uranus-web 接口的调用方式,本地模式下由 LocalClient 直接操作数据库
+type caller interface {
+	Call(path string, request, data interface{}) error
+	Logout() error
+}
+
+type Ctl struct {
+	client  caller
+	printer *Printer
+	out     io.Writer
+}
+
+type command struct {
+	name  string
+	usage string
+	run   func(w *Ctl, args []string) error
+}
+
+var commands = []command{
+	{"module status", "<process|file|net>", (*Ctl).showModuleStatus},
+	{"module enable", "<process|file|net>", (*Ctl).enableModule},
+	{"module disable", "<process|file|net>", (*Ctl).disableModule},
+	{"judge show", "", (*Ctl).showJudge},
+	{"judge set", "<disable|audit|defense>", (*Ctl).updateJudge},
+	{"process events", "[-limit n] [-offset id]", (*Ctl).listProcessEvents},
+	{"process trust", "<event id>", (*Ctl).trustProcess},
+	{"process untrust", "<event id>", (*Ctl).untrustProcess},
+	{"file policies", "[-limit n] [-offset id]", (*Ctl).listFilePolicies},
+	{"file add", "<path> <perm>", (*Ctl).addFilePolicy},
+	{"file delete", "<policy id>", (*Ctl).deleteFilePolicy},
+	{"file events", "[-limit n] [-offset id]", (*Ctl).listFileEvents},
+	{"net policies", "[-limit n] [-offset id]", (*Ctl).listNetPolicies},
+	{"net add", "<rule|policy json|->", (*Ctl).addNetPolicy},
+	{"net update", "<policy id> <rule|policy json|->", (*Ctl).updateNetPolicy},
+	{"net delete", "<policy id>", (*Ctl).deleteNetPolicy},
+	{"net events", "[-limit n] [-offset id] [-family 4|6]", (*Ctl).listNetEvents},
+	{"user list", "", (*Ctl).listUsers},
+	{"user add", "-password p -role r [-alias a] <username>", (*Ctl).addUser},
+	{"user update", "[-username u] [-password p] [-alias a] [-role r] <user id>", (*Ctl).updateUser},
+	{"user delete", "<user id>", (*Ctl).deleteUser},
+	{"user sessions", "<user id>", (*Ctl).listUserSessions},
+	{"tail", "[-interval d] [-all] [process|file|net ...]", (*Ctl).tail},
+}
+
+// Usage 输出所有子命令的用法
+func Usage(out io.Writer) {
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(writer, "  %s\t%s\n", c.name, c.usage)
+	}
+	writer.Flush()
+}
+
+func find(args []string) (c command, rest []string, ok bool) {
+	for _, c = range commands {
+		fields := strings.Fields(c.name)
+		if len(args) >= len(fields) && strings.Join(args[:len(fields)], " ") == c.name {
+			return c, args[len(fields):], true
+		}
+	}
+	return
+}
+
+func newCaller(config Config) (c caller, err error) {
+	if config.DB != nil {
+		return NewLocalClient(config.DB, config.Push)
+	}
+
+	if config.Token == "" && (config.Username == "" || config.Password == "") {
+		return nil, ErrorNoCredential
+	}
+	client, err := NewClient(config.Server, config.Token, config.TLSConfig)
+	if err != nil {
+		return
+	}
+	if config.Token == "" {
+		if err = client.Login(config.Username, config.Password, config.Code); err != nil {
+			return
+		}
+	}
+	c = client
+	return
+}
+
+// Run 执行子命令,使用用户名密码登录时结束后会退出登录
+func Run(config Config, args []string, out io.Writer) (err error) {
+	c, rest, ok := find(args)
+	if !ok {
+		if len(args) == 0 {
+			return ErrorUsage
+		}
+		return fmt.Errorf("%w: %s", ErrorUnknownCommand, strings.Join(args, " "))
+	}
+
+	printer, err := NewPrinter(config.Format, out)
+	if err != nil {
+		return
+	}
+
+	client, err := newCaller(config)
+	if err != nil {
+		return
+	}
+	defer client.Logout()
+
+	w := &Ctl{
+		client:  client,
+		printer: printer,
+		out:     out,
+	}
+	err = c.run(w, rest)
+	return
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	return flags
+}
+
+// parseArgs 解析子命令的参数并检查位置参数的个数
+func parseArgs(flags *flag.FlagSet, args []string, count int) (rest []string, err error) {
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	rest = flags.Args()
+	if len(rest) != count {
+		err = fmt.Errorf("%w: expected %d arguments, got %d", ErrorUsage, count, len(rest))
+	}
+	return
+}
+
+func parseID(value string) (id int64, err error) {
+	id, err = strconv.ParseInt(value, 10, 64)
+	if err != nil || id <= 0 {
+		err = ErrorInvalidID
+	}
+	return
+}
+
+func parseModule(value string) (module string, err error) {
+	switch value {
+	case "process", "file", "net":
+		module = value
+	default:
+		err = ErrorInvalidModule
+	}
+	return
+}
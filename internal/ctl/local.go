@@ -0,0 +1,330 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package ctl
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/lanthora/uranus/internal/apply"
+	"github.com/lanthora/uranus/internal/config"
+	webfile "github.com/lanthora/uranus/internal/web/file"
+	webnet "github.com/lanthora/uranus/internal/web/net"
+	webprocess "github.com/lanthora/uranus/internal/web/process"
+	"github.com/lanthora/uranus/internal/web/user"
+	"github.com/lanthora/uranus/pkg/file"
+	"github.com/lanthora/uranus/pkg/net"
+	"github.com/lanthora/uranus/pkg/process"
+)
+
+var (
+	ErrorLocalUnsupported = errors.New("not supported in local mode")
+	ErrorPushFailed       = apply.ErrorPushFailed
+)
+
+var moduleStatusKeys = map[string]string{
+	"process": config.ProcessModuleStatus,
+	"file":    config.FileModuleStatus,
+	"net":     config.NetModuleStatus,
+}
+
+// moduleCounters 与 showModuleStatus 接口使用相同的统计查询
+var moduleCounters = map[string]struct {
+	policy func(db *sql.DB) (int, error)
+	unread func(db *sql.DB) (int, error)
+}{
+	"process": {webprocess.QueryPolicyCount, webprocess.QueryUnreadEventCount},
+	"file":    {webfile.QueryPolicyCount, webfile.QueryUnreadEventCount},
+	"net":     {webnet.QueryPolicyCount, webnet.QueryUnreadEventCount},
+}
+
+type localHandler func(c *LocalClient, request []byte) (data interface{}, err error)
+
+// LocalClient 不经过 uranus-web 直接读写它的数据库,用于 uranus-web 无法使用时的本地管理.
+// 查询和修改都调用 uranus-web 中的同一份实现,
+// push 为 true 时同时把修改下发到 hackernel,否则修改在 uranus-web 下次启动时生效.
+type LocalClient struct {
+	db     *sql.DB
+	config *config.Config
+	push   bool
+}
+
+func NewLocalClient(db *sql.DB, push bool) (c *LocalClient, err error) {
+	config, err := config.New(db)
+	if err != nil {
+		return
+	}
+	c = &LocalClient{
+		db:     db,
+		config: config,
+		push:   push,
+	}
+	return
+}
+
+// localRoutes 与 uranus-web 的接口一一对应,子命令不需要区分是否为本地模式
+var localRoutes = map[string]localHandler{}
+
+func init() {
+	for module := range moduleStatusKeys {
+		module := module
+		localRoutes["/"+module+"/showModuleStatus"] = func(c *LocalClient, request []byte) (interface{}, error) {
+			return c.showModuleStatus(module)
+		}
+		localRoutes["/"+module+"/enableModule"] = func(c *LocalClient, request []byte) (interface{}, error) {
+			return nil, c.updateModuleStatus(module, true)
+		}
+		localRoutes["/"+module+"/disableModule"] = func(c *LocalClient, request []byte) (interface{}, error) {
+			return nil, c.updateModuleStatus(module, false)
+		}
+	}
+	localRoutes["/process/showWorkMode"] = (*LocalClient).showWorkMode
+	localRoutes["/process/updateWorkMode"] = (*LocalClient).updateWorkMode
+	localRoutes["/process/listEvents"] = (*LocalClient).listProcessEvents
+	localRoutes["/process/updateEventStatus"] = (*LocalClient).updateProcessEventStatus
+	localRoutes["/file/listPolicies"] = (*LocalClient).listFilePolicies
+	localRoutes["/file/addPolicy"] = (*LocalClient).addFilePolicy
+	localRoutes["/file/deletePolicy"] = (*LocalClient).deleteFilePolicy
+	localRoutes["/file/listEvents"] = (*LocalClient).listFileEvents
+	localRoutes["/net/listPolicies"] = (*LocalClient).listNetPolicies
+	localRoutes["/net/addPolicy"] = (*LocalClient).addNetPolicy
+	localRoutes["/net/updatePolicy"] = (*LocalClient).updateNetPolicy
+	localRoutes["/net/deletePolicy"] = (*LocalClient).deleteNetPolicy
+	localRoutes["/net/listEvents"] = (*LocalClient).listNetEvents
+	localRoutes["/admin/listAllUsers"] = (*LocalClient).listUsers
+	localRoutes["/admin/updateUserInfo"] = (*LocalClient).resetPassword
+}
+
+func (c *LocalClient) Call(path string, request, data interface{}) (err error) {
+	handler, ok := localRoutes[path]
+	if !ok {
+		return fmt.Errorf("%s: %w", path, ErrorLocalUnsupported)
+	}
+	content, err := json.Marshal(request)
+	if err != nil {
+		return
+	}
+	result, err := handler(c, content)
+	if err != nil || data == nil || result == nil {
+		return
+	}
+	content, err = json.Marshal(result)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(content, data)
+	return
+}
+
+func (c *LocalClient) Logout() error {
+	return nil
+}
+
+func (c *LocalClient) showModuleStatus(module string) (data interface{}, err error) {
+	status, err := c.config.GetInteger(moduleStatusKeys[module])
+	if err != nil {
+		status = process.StatusDisable
+	}
+	policyCount, err := moduleCounters[module].policy(c.db)
+	if err != nil {
+		return
+	}
+	unreadEventCount, err := moduleCounters[module].unread(c.db)
+	if err != nil {
+		return
+	}
+	data = map[string]int{
+		"status":           status,
+		"policyCount":      policyCount,
+		"unreadEventCount": unreadEventCount,
+	}
+	return
+}
+
+func (c *LocalClient) updateModuleStatus(module string, enable bool) (err error) {
+	var status int
+	var push func() bool
+	switch module {
+	case "process":
+		status, push = process.StatusDisable, process.Disable
+		if enable {
+			status, push = process.StatusEnable, process.Enable
+		}
+	case "file":
+		status, push = file.StatusDisable, file.Disable
+		if enable {
+			status, push = file.StatusEnable, file.Enable
+		}
+	case "net":
+		status, push = net.StatusDisable, net.Disable
+		if enable {
+			status, push = net.StatusEnable, net.Enable
+		}
+	}
+	if err = c.config.SetInteger(moduleStatusKeys[module], status); err != nil || !c.push {
+		return
+	}
+	err = apply.Pushed(push())
+	return
+}
+
+func (c *LocalClient) showWorkMode(request []byte) (data interface{}, err error) {
+	judge, err := c.config.GetInteger(config.ProcessProtectionMode)
+	if err != nil {
+		judge, err = process.StatusJudgeDisable, nil
+	}
+	data = map[string]int{"judge": judge}
+	return
+}
+
+func (c *LocalClient) updateWorkMode(request []byte) (data interface{}, err error) {
+	args := struct {
+		Judge int `json:"judge"`
+	}{}
+	if err = json.Unmarshal(request, &args); err != nil {
+		return
+	}
+	if args.Judge < process.StatusJudgeDisable || args.Judge > process.StatusJudgeDefense {
+		err = ErrorInvalidJudge
+		return
+	}
+	if err = c.config.SetInteger(config.ProcessProtectionMode, args.Judge); err != nil || !c.push {
+		return
+	}
+	err = apply.Pushed(process.UpdateJudge(args.Judge))
+	return
+}
+
+func (c *LocalClient) listProcessEvents(request []byte) (data interface{}, err error) {
+	args := page{}
+	if err = json.Unmarshal(request, &args); err != nil {
+		return
+	}
+	return webprocess.QueryEventLimitOffset(c.db, args.Limit, args.Offset)
+}
+
+func (c *LocalClient) updateProcessEventStatus(request []byte) (data interface{}, err error) {
+	args := struct {
+		ID     int `json:"id"`
+		Status int `json:"status"`
+	}{}
+	if err = json.Unmarshal(request, &args); err != nil {
+		return
+	}
+	err = webprocess.UpdateEventStatus(c.db, args.ID, args.Status, c.push)
+	return
+}
+
+func (c *LocalClient) listFilePolicies(request []byte) (data interface{}, err error) {
+	args := page{}
+	if err = json.Unmarshal(request, &args); err != nil {
+		return
+	}
+	return webfile.QueryPolicyLimitOffset(c.db, args.Limit, args.Offset)
+}
+
+// addFilePolicy 不下发到 hackernel 时 fsid 和 ino 为 0,由 uranus-web 启动时根据路径重新获取
+func (c *LocalClient) addFilePolicy(request []byte) (data interface{}, err error) {
+	args := struct {
+		Path string    `json:"path"`
+		Perm file.Perm `json:"perm"`
+	}{}
+	if err = json.Unmarshal(request, &args); err != nil {
+		return
+	}
+	err = webfile.AddPolicy(c.db, args.Path, int(args.Perm), c.push)
+	return
+}
+
+func (c *LocalClient) deleteFilePolicy(request []byte) (data interface{}, err error) {
+	args := idRequest{}
+	if err = json.Unmarshal(request, &args); err != nil {
+		return
+	}
+	err = webfile.DeletePolicy(c.db, int(args.ID), c.push)
+	return
+}
+
+func (c *LocalClient) listFileEvents(request []byte) (data interface{}, err error) {
+	args := page{}
+	if err = json.Unmarshal(request, &args); err != nil {
+		return
+	}
+	return webfile.QueryEventLimitOffset(c.db, args.Limit, args.Offset)
+}
+
+func (c *LocalClient) listNetPolicies(request []byte) (data interface{}, err error) {
+	args := page{}
+	if err = json.Unmarshal(request, &args); err != nil {
+		return
+	}
+	return webnet.QueryPolicyLimitOffset(c.db, args.Limit, args.Offset)
+}
+
+func (c *LocalClient) addNetPolicy(request []byte) (data interface{}, err error) {
+	policy := net.Policy{}
+	if err = json.Unmarshal(request, &policy); err != nil {
+		return
+	}
+	if err = policy.Normalize(); err != nil {
+		return
+	}
+	if err = policy.Validate(); err != nil {
+		return
+	}
+	_, err = webnet.AddPolicy(c.db, policy, c.push)
+	return
+}
+
+func (c *LocalClient) updateNetPolicy(request []byte) (data interface{}, err error) {
+	policy := net.Policy{}
+	if err = json.Unmarshal(request, &policy); err != nil {
+		return
+	}
+	if err = policy.Normalize(); err != nil {
+		return
+	}
+	if err = policy.Validate(); err != nil {
+		return
+	}
+	err = webnet.UpdatePolicy(c.db, policy, c.push)
+	return
+}
+
+func (c *LocalClient) deleteNetPolicy(request []byte) (data interface{}, err error) {
+	args := idRequest{}
+	if err = json.Unmarshal(request, &args); err != nil {
+		return
+	}
+	err = webnet.DeletePolicy(c.db, args.ID, c.push)
+	return
+}
+
+func (c *LocalClient) listNetEvents(request []byte) (data interface{}, err error) {
+	args := netEventPage{}
+	if err = json.Unmarshal(request, &args); err != nil {
+		return
+	}
+	return webnet.QueryEventLimitOffset(c.db, args.Limit, args.Offset, args.Family)
+}
+
+func (c *LocalClient) listUsers(request []byte) (data interface{}, err error) {
+	return user.QueryAllUser(c.db)
+}
+
+// resetPassword 本地模式只支持重置密码,用于忘记密码或者 uranus-web 无法登录的情况
+func (c *LocalClient) resetPassword(request []byte) (data interface{}, err error) {
+	args := map[string]interface{}{}
+	if err = json.Unmarshal(request, &args); err != nil {
+		return
+	}
+	password, ok := args["password"].(string)
+	if !ok || len(args) != 2 {
+		err = fmt.Errorf("only the password can be updated: %w", ErrorLocalUnsupported)
+		return
+	}
+	userID, _ := args["userID"].(float64)
+	err = user.ResetPassword(c.db, int64(userID), password)
+	return
+}
@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package ctl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+)
+
+var (
+	ErrorInvalidFormat = errors.New("output format must be table or json")
+)
+
+// Printer 按表格或 JSON 输出接口返回的数据
+type Printer struct {
+	format string
+	out    io.Writer
+}
+
+func NewPrinter(format string, out io.Writer) (p *Printer, err error) {
+	if format != FormatTable && format != FormatJSON {
+		err = ErrorInvalidFormat
+		return
+	}
+	p = &Printer{format: format, out: out}
+	return
+}
+
+// Print 输出数据,表格格式按 columns 的顺序输出列,嵌套字段使用 a.b.c 形式的路径.
+// 没有指定 columns 时输出所有字段.
+func (p *Printer) Print(data interface{}, columns ...string) (err error) {
+	if p.format == FormatJSON {
+		encoder := json.NewEncoder(p.out)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		err = encoder.Encode(data)
+		return
+	}
+
+	value, err := normalize(data)
+	if err != nil {
+		return
+	}
+
+	writer := tabwriter.NewWriter(p.out, 0, 4, 2, ' ', 0)
+	switch value := value.(type) {
+	case []interface{}:
+		if len(columns) == 0 && len(value) != 0 {
+			columns = keys(value[0])
+		}
+		fmt.Fprintln(writer, strings.ToUpper(strings.Join(columns, "\t")))
+		for _, row := range value {
+			cells := []string{}
+			for _, column := range columns {
+				cells = append(cells, cell(lookup(row, column)))
+			}
+			fmt.Fprintln(writer, strings.Join(cells, "\t"))
+		}
+	case map[string]interface{}:
+		if len(columns) == 0 {
+			columns = keys(value)
+		}
+		for _, column := range columns {
+			fmt.Fprintf(writer, "%s:\t%s\n", column, cell(lookup(value, column)))
+		}
+	case nil:
+	default:
+		fmt.Fprintln(writer, cell(value))
+	}
+	err = writer.Flush()
+	return
+}
+
+// normalize 把结构体转换成 map,便于按 JSON 字段名取值
+func normalize(data interface{}) (value interface{}, err error) {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	err = decoder.Decode(&value)
+	return
+}
+
+func keys(value interface{}) (result []string) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for key := range object {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return
+}
+
+func lookup(value interface{}, path string) interface{} {
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+func cell(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "-"
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		return fmt.Sprint(value)
+	case []interface{}:
+		// 权限名称等字符串列表输出为 a,b,c
+		names := []string{}
+		for _, v := range value {
+			name, ok := v.(string)
+			if !ok {
+				names = nil
+				break
+			}
+			names = append(names, name)
+		}
+		if names != nil {
+			return strings.Join(names, ",")
+		}
+	case map[string]interface{}:
+		// 策略中的地址、协议和端口范围输出为 begin-end
+		if begin, ok := value["begin"]; ok && len(value) == 2 {
+			if end, ok := value["end"]; ok {
+				return cell(begin) + "-" + cell(end)
+			}
+		}
+	}
+	content, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(content)
+}
@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package ctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lanthora/uranus/pkg/file"
+	"github.com/lanthora/uranus/pkg/net"
+)
+
+// tailBatch 是每次拉取事件的数量
+const tailBatch = 500
+
+type tailEvent struct {
+	ID      int64  `json:"id"`
+	Status  int    `json:"status"`
+	Judge   int    `json:"judge"`
+	Argv    string `json:"argv"`
+	Binary  string `json:"binary"`
+	Pid     int    `json:"pid"`
+	Path    string `json:"path"`
+	Perm    int    `json:"perm"`
+	SrcAddr string `json:"saddr"`
+	SrcPort int    `json:"sport"`
+	DstAddr string `json:"daddr"`
+	DstPort int    `json:"dport"`
+}
+
+func (e *tailEvent) String(module string) string {
+	switch module {
+	case "process":
+		return fmt.Sprintf("[process] #%d judge=%d %s", e.ID, e.Judge, e.Argv)
+	case "file":
+		line := fmt.Sprintf("[file] #%d perm=%s %s", e.ID, strings.Join(file.PermNames(e.Perm), ","), e.Path)
+		if e.Binary != "" {
+			line += fmt.Sprintf(" by %s[%d]", e.Binary, e.Pid)
+		}
+		return line
+	default:
+		return fmt.Sprintf("[net] #%d %s => %s", e.ID, net.JoinHostPort(e.SrcAddr, e.SrcPort), net.JoinHostPort(e.DstAddr, e.DstPort))
+	}
+}
+
+// fetch 拉取 offset 之后的事件,没有新事件时返回原来的 offset
+func (w *Ctl) fetch(module string, offset int64, print bool) (next int64, count int, err error) {
+	next = offset
+	events := []json.RawMessage{}
+	request := page{Limit: tailBatch, Offset: int(offset)}
+	if err = w.client.Call("/"+module+"/listEvents", request, &events); err != nil {
+		return
+	}
+	for _, raw := range events {
+		event := tailEvent{}
+		if err = json.Unmarshal(raw, &event); err != nil {
+			return
+		}
+		next = event.ID
+		if !print {
+			continue
+		}
+		if w.printer.format == FormatJSON {
+			fmt.Fprintf(w.out, "{\"module\":%q,\"event\":%s}\n", module, raw)
+		} else {
+			fmt.Fprintln(w.out, event.String(module))
+		}
+	}
+	count = len(events)
+	return
+}
+
+// tail 持续输出新产生的事件,默认跳过已有的事件,收到 SIGINT 或 SIGTERM 后退出
+func (w *Ctl) tail(args []string) (err error) {
+	flags := newFlagSet("tail")
+	interval := flags.Duration("interval", time.Second, "")
+	all := flags.Bool("all", false, "")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	modules := flags.Args()
+	if len(modules) == 0 {
+		modules = []string{"process", "file", "net"}
+	}
+
+	offsets := map[string]int64{}
+	for _, module := range modules {
+		if _, err = parseModule(module); err != nil {
+			return
+		}
+		for count := tailBatch; count == tailBatch; {
+			offsets[module], count, err = w.fetch(module, offsets[module], *all)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigchan)
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sigchan:
+			return
+		case <-ticker.C:
+		}
+		for _, module := range modules {
+			for count := tailBatch; count == tailBatch; {
+				offsets[module], count, err = w.fetch(module, offsets[module], true)
+				if err != nil {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package i18n
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ZhCN = "zh-CN"
+	EnUS = "en-US"
+)
+
+// Default 是没有指定语言或者语言不受支持时使用的语言
+const Default = ZhCN
+
+var supported = []string{ZhCN, EnUS}
+
+// Catalog 按语言保存文本,缺少翻译时使用默认语言的文本
+type Catalog map[string]map[string]string
+
+func (c Catalog) Text(language, key string) string {
+	if text, ok := c[language][key]; ok {
+		return text
+	}
+	if text, ok := c[Default][key]; ok {
+		return text
+	}
+	return key
+}
+
+// Normalize 把 zh、en_US、EN-us 等写法转换成受支持的语言标签
+func Normalize(language string) (normalized string, ok bool) {
+	language = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(language), "_", "-"))
+	if language == "" {
+		return
+	}
+	for _, s := range supported {
+		if strings.ToLower(s) == language {
+			return s, true
+		}
+	}
+	// 只有主语言时选择该语言的第一个地区
+	primary := strings.SplitN(language, "-", 2)[0]
+	for _, s := range supported {
+		if strings.HasPrefix(strings.ToLower(s), primary+"-") {
+			return s, true
+		}
+	}
+	return
+}
+
+// Match 根据 Accept-Language 请求头选择权重最高的受支持语言
+func Match(acceptLanguage string) string {
+	type candidate struct {
+		language string
+		quality  float64
+	}
+	candidates := []candidate{}
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(part, ";")
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if language, ok := Normalize(fields[0]); ok && quality > 0 {
+			candidates = append(candidates, candidate{language: language, quality: quality})
+		}
+	}
+	if len(candidates) == 0 {
+		return Default
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].quality > candidates[j].quality
+	})
+	return candidates[0].language
+}
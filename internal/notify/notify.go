@@ -2,6 +2,7 @@ package notify
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -12,7 +13,10 @@ import (
 	"time"
 
 	"github.com/gen2brain/beeep"
+	"github.com/lanthora/uranus/internal/i18n"
 	"github.com/lanthora/uranus/internal/web/process"
+	"github.com/lanthora/uranus/internal/web/render"
+	"github.com/lanthora/uranus/internal/web/user"
 	"github.com/lanthora/uranus/pkg/file"
 	"github.com/lanthora/uranus/pkg/net"
 	"github.com/sirupsen/logrus"
@@ -23,7 +27,10 @@ type NotifyWorker struct {
 	wg                 sync.WaitGroup
 	username           string
 	password           string
+	token              string
+	language           string
 	server             string
+	tlsConfig          *tls.Config
 	client             *http.Client
 	ProcessEventOffset int64
 	FileEventOffset    int64
@@ -32,11 +39,31 @@ type NotifyWorker struct {
 
 const notifyNumberMax = 10
 
-func NewWorker(server, username, password string, processEventOffset, fileEventOffset, netEventOffset int64) *NotifyWorker {
+var catalog = i18n.Catalog{
+	i18n.ZhCN: {
+		"process.title": "进程防护事件",
+		"file.title":    "文件防护事件",
+		"file.process":  "访问进程",
+		"net.title":     "网络防护事件",
+		"ignored":       "已忽略积压的通知,请通过网页查看",
+	},
+	i18n.EnUS: {
+		"process.title": "Process Protection Event",
+		"file.title":    "File Protection Event",
+		"file.process":  "Process",
+		"net.title":     "Network Protection Event",
+		"ignored":       "Backlogged notifications were skipped, please check them on the web page",
+	},
+}
+
+func NewWorker(server, username, password, token, language string, tlsConfig *tls.Config, processEventOffset, fileEventOffset, netEventOffset int64) *NotifyWorker {
 	w := NotifyWorker{
 		server:             server,
 		username:           username,
 		password:           password,
+		token:              token,
+		language:           language,
+		tlsConfig:          tlsConfig,
 		ProcessEventOffset: processEventOffset,
 		FileEventOffset:    fileEventOffset,
 		NetEventOffset:     netEventOffset,
@@ -53,31 +80,61 @@ func (w *NotifyWorker) Start() (err error) {
 		return
 	}
 
-	w.client = &http.Client{Jar: jar}
+	w.client = &http.Client{
+		Jar:       jar,
+		Transport: &http.Transport{TLSClientConfig: w.tlsConfig},
+	}
+
+	// 使用 API Token 时不需要登录
+	if w.token == "" {
+		if err = w.login(); err != nil {
+			logrus.Fatal(err)
+			return
+		}
+	}
+
+	w.wg.Add(1)
+	go w.run()
+	return
+}
 
+func (w *NotifyWorker) login() (err error) {
 	body, err := json.Marshal(map[string]string{"username": w.username, "password": w.password})
 	if err != nil {
-		logrus.Fatal(err)
 		return
 	}
 
-	url := w.server + "/auth/login"
-	contentType := "application/json"
-	resp, err := w.client.Post(url, contentType, bytes.NewBuffer(body))
+	resp, err := w.post("/auth/login", bytes.NewBuffer(body))
 	if err != nil {
-		logrus.Fatal(err)
 		return
 	}
+	defer resp.Body.Close()
 
 	bytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		logrus.Fatal(err)
 		return
 	}
 	logrus.Infof("Login: %s", bytes)
+	return
+}
 
-	w.wg.Add(1)
-	go w.run()
+func (w *NotifyWorker) post(path string, body io.Reader) (resp *http.Response, err error) {
+	request, err := http.NewRequest(http.MethodPost, w.server+path, body)
+	if err != nil {
+		return
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept-Language", w.language)
+	if w.token != "" {
+		request.Header.Set("Authorization", "Bearer "+w.token)
+	}
+	// 使用 Cookie 登录时需要把 CSRF Token 放到请求头中
+	for _, cookie := range w.client.Jar.Cookies(request.URL) {
+		if cookie.Name == user.CSRFCookie {
+			request.Header.Set(user.CSRFHeader, cookie.Value)
+		}
+	}
+	resp, err = w.client.Do(request)
 	return
 }
 
@@ -85,9 +142,11 @@ func (w *NotifyWorker) Stop() {
 	w.running = false
 	w.wg.Wait()
 
-	url := w.server + "/auth/logout"
-	contentType := "application/json"
-	resp, err := w.client.Post(url, contentType, nil)
+	if w.token != "" {
+		return
+	}
+
+	resp, err := w.post("/auth/logout", nil)
 	if err != nil {
 		logrus.Error(err)
 		return
@@ -121,50 +180,78 @@ func (w *NotifyWorker) notify(title, message string) {
 	time.Sleep(500 * time.Millisecond)
 }
 
-func (w *NotifyWorker) updateProcessNotify() {
-	body, err := json.Marshal(map[string]int64{"offset": w.ProcessEventOffset, "limit": 5000})
+// listEvents 查询 offset 之后的事件并解析到 data 中, 查询失败时返回 false.
+// 使用 Cookie 登录时会话可能过期, 此时重新登录后再查询一次
+func (w *NotifyWorker) listEvents(path string, offset int64, data interface{}) bool {
+	body, err := json.Marshal(map[string]int64{"offset": offset, "limit": 5000})
 	if err != nil {
 		logrus.Error(err)
 		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
-		return
+		return false
 	}
 
-	url := w.server + "/process/listEvents"
-	contentType := "application/json"
-	resp, err := w.client.Post(url, contentType, bytes.NewBuffer(body))
+	doc, err := w.postDocument(path, body)
+	if err == nil && doc.Status == render.StatusUserNotLoggedIn && w.token == "" {
+		logrus.Infof("%s: session expired, login again", path)
+		if err = w.login(); err == nil {
+			doc, err = w.postDocument(path, body)
+		}
+	}
 	if err != nil {
 		logrus.Error(err)
 		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
-		return
+		return false
 	}
 
-	bytes, err := io.ReadAll(resp.Body)
+	if doc.Status != render.StatusSuccess {
+		logrus.Errorf("%s: status=%d message=%s", path, doc.Status, doc.Message)
+		return false
+	}
+
+	if len(doc.Data) == 0 {
+		return false
+	}
+
+	err = json.Unmarshal(doc.Data, data)
 	if err != nil {
 		logrus.Error(err)
 		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
-		return
+		return false
 	}
+	return true
+}
 
-	doc := struct {
-		Status  int             `json:"status"`
-		Message string          `json:"message"`
-		Data    []process.Event `json:"data"`
-	}{}
+type document struct {
+	Status  int             `json:"status"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
 
-	err = json.Unmarshal(bytes, &doc)
+func (w *NotifyWorker) postDocument(path string, body []byte) (doc document, err error) {
+	resp, err := w.post(path, bytes.NewBuffer(body))
 	if err != nil {
-		logrus.Error(err)
-		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
 		return
 	}
+	defer resp.Body.Close()
 
-	if doc.Data == nil {
+	bytes, err := io.ReadAll(resp.Body)
+	if err != nil {
 		return
 	}
 
-	for idx, event := range doc.Data {
+	err = json.Unmarshal(bytes, &doc)
+	return
+}
+
+func (w *NotifyWorker) updateProcessNotify() {
+	var events []process.Event
+	if !w.listEvents("/process/listEvents", w.ProcessEventOffset, &events) || len(events) == 0 {
+		return
+	}
+
+	for idx, event := range events {
 		w.ProcessEventOffset = event.ID
-		title := fmt.Sprintf("进程防护事件 (ID: %d)", event.ID)
+		title := fmt.Sprintf("%s (ID: %d)", catalog.Text(w.language, "process.title"), event.ID)
 		message := event.Argv
 		w.notify(title, message)
 
@@ -173,64 +260,37 @@ func (w *NotifyWorker) updateProcessNotify() {
 		}
 	}
 
-	if w.ProcessEventOffset != doc.Data[len(doc.Data)-1].ID {
-		title := "进程防护事件"
-		message := "已忽略积压的通知,请通过网页查看"
+	if w.ProcessEventOffset != events[len(events)-1].ID {
+		title := catalog.Text(w.language, "process.title")
+		message := catalog.Text(w.language, "ignored")
 		w.notify(title, message)
 
-		event := doc.Data[len(doc.Data)-1]
+		event := events[len(events)-1]
 		w.ProcessEventOffset = event.ID
-		title = fmt.Sprintf("进程防护事件 (ID: %d)", w.ProcessEventOffset)
+		title = fmt.Sprintf("%s (ID: %d)", catalog.Text(w.language, "process.title"), w.ProcessEventOffset)
 		message = event.Argv
 		w.notify(title, message)
 	}
 }
 
-func (w *NotifyWorker) updateFileNotify() {
-	body, err := json.Marshal(map[string]int64{"offset": w.FileEventOffset, "limit": 5000})
-	if err != nil {
-		logrus.Error(err)
-		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
-		return
-	}
-
-	url := w.server + "/file/listEvents"
-	contentType := "application/json"
-	resp, err := w.client.Post(url, contentType, bytes.NewBuffer(body))
-	if err != nil {
-		logrus.Error(err)
-		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
-		return
-	}
-
-	bytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		logrus.Error(err)
-		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
-		return
-	}
-
-	doc := struct {
-		Status  int          `json:"status"`
-		Message string       `json:"message"`
-		Data    []file.Event `json:"data"`
-	}{}
-
-	err = json.Unmarshal(bytes, &doc)
-	if err != nil {
-		logrus.Error(err)
-		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
-		return
+// fileMessage 输出文件路径, hackernel 上报了访问文件的进程时一并输出
+func (w *NotifyWorker) fileMessage(event file.Event) string {
+	if event.Binary == "" {
+		return event.Path
 	}
+	return fmt.Sprintf("%s\n%s: %s (pid %d)", event.Path, catalog.Text(w.language, "file.process"), event.Binary, event.Pid)
+}
 
-	if doc.Data == nil {
+func (w *NotifyWorker) updateFileNotify() {
+	var events []file.Event
+	if !w.listEvents("/file/listEvents", w.FileEventOffset, &events) || len(events) == 0 {
 		return
 	}
 
-	for idx, event := range doc.Data {
+	for idx, event := range events {
 		w.FileEventOffset = event.ID
-		title := fmt.Sprintf("文件防护事件 (ID: %d)", event.ID)
-		message := event.Path
+		title := fmt.Sprintf("%s (ID: %d)", catalog.Text(w.language, "file.title"), event.ID)
+		message := w.fileMessage(event)
 		w.notify(title, message)
 
 		if idx > notifyNumberMax {
@@ -238,64 +298,29 @@ func (w *NotifyWorker) updateFileNotify() {
 		}
 	}
 
-	if w.FileEventOffset != doc.Data[len(doc.Data)-1].ID {
-		title := "文件防护事件"
-		message := "已忽略积压的通知,请通过网页查看"
+	if w.FileEventOffset != events[len(events)-1].ID {
+		title := catalog.Text(w.language, "file.title")
+		message := catalog.Text(w.language, "ignored")
 		w.notify(title, message)
 
-		event := doc.Data[len(doc.Data)-1]
+		event := events[len(events)-1]
 		w.FileEventOffset = event.ID
-		title = fmt.Sprintf("文件防护事件 (ID: %d)", w.FileEventOffset)
-		message = event.Path
+		title = fmt.Sprintf("%s (ID: %d)", catalog.Text(w.language, "file.title"), w.FileEventOffset)
+		message = w.fileMessage(event)
 		w.notify(title, message)
 	}
 }
 
 func (w *NotifyWorker) updateNetNotify() {
-	body, err := json.Marshal(map[string]int64{"offset": w.NetEventOffset, "limit": 5000})
-	if err != nil {
-		logrus.Error(err)
-		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
-		return
-	}
-
-	url := w.server + "/net/listEvents"
-	contentType := "application/json"
-	resp, err := w.client.Post(url, contentType, bytes.NewBuffer(body))
-	if err != nil {
-		logrus.Error(err)
-		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
-		return
-	}
-
-	bytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		logrus.Error(err)
-		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
-		return
-	}
-
-	doc := struct {
-		Status  int         `json:"status"`
-		Message string      `json:"message"`
-		Data    []net.Event `json:"data"`
-	}{}
-
-	err = json.Unmarshal(bytes, &doc)
-	if err != nil {
-		logrus.Error(err)
-		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
-		return
-	}
-
-	if doc.Data == nil {
+	var events []net.Event
+	if !w.listEvents("/net/listEvents", w.NetEventOffset, &events) || len(events) == 0 {
 		return
 	}
 
-	for idx, event := range doc.Data {
+	for idx, event := range events {
 		w.NetEventOffset = event.ID
-		title := fmt.Sprintf("网络防护事件 (ID: %d)", event.ID)
-		message := fmt.Sprintf("%s:%d => %s:%d", event.SrcAddr, event.SrcPort, event.DstAddr, event.DstPort)
+		title := fmt.Sprintf("%s (ID: %d)", catalog.Text(w.language, "net.title"), event.ID)
+		message := net.JoinHostPort(event.SrcAddr, event.SrcPort) + " => " + net.JoinHostPort(event.DstAddr, event.DstPort)
 		w.notify(title, message)
 
 		if idx > notifyNumberMax {
@@ -303,15 +328,15 @@ func (w *NotifyWorker) updateNetNotify() {
 		}
 	}
 
-	if w.NetEventOffset != doc.Data[len(doc.Data)-1].ID {
-		title := "网络防护事件"
-		message := "已忽略积压的通知,请通过网页查看"
+	if w.NetEventOffset != events[len(events)-1].ID {
+		title := catalog.Text(w.language, "net.title")
+		message := catalog.Text(w.language, "ignored")
 		w.notify(title, message)
 
-		event := doc.Data[len(doc.Data)-1]
+		event := events[len(events)-1]
 		w.NetEventOffset = event.ID
-		title = fmt.Sprintf("网络防护事件 (ID: %d)", w.NetEventOffset)
-		message = fmt.Sprintf("%s:%d => %s:%d", event.SrcAddr, event.SrcPort, event.DstAddr, event.DstPort)
+		title = fmt.Sprintf("%s (ID: %d)", catalog.Text(w.language, "net.title"), w.NetEventOffset)
+		message = net.JoinHostPort(event.SrcAddr, event.SrcPort) + " => " + net.JoinHostPort(event.DstAddr, event.DstPort)
 		w.notify(title, message)
 	}
 }
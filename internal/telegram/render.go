@@ -4,9 +4,50 @@ package telegram
 import (
 	"encoding/json"
 	"fmt"
+
+	"github.com/lanthora/uranus/internal/i18n"
 )
 
-func RenderAuditProcReport(text string) (richText string) {
+var catalog = i18n.Catalog{
+	i18n.ZhCN: {
+		"audit.proc.report":   "进程审计",
+		"user.msg.sub":        "消息订阅",
+		"user.msg.unsub":      "消息退订",
+		"kernel.proc.enable":  "开启进程保护",
+		"kernel.proc.disable": "关闭进程保护",
+		"workdir":             "工作目录",
+		"binary":              "可执行程序",
+		"argv":                "参数列表",
+		"section":             "字段",
+		"status":              "状态",
+		"status.success":      "成功",
+		"status.failure":      "失败",
+	},
+	i18n.EnUS: {
+		"audit.proc.report":   "Process Audit",
+		"user.msg.sub":        "Message Subscription",
+		"user.msg.unsub":      "Message Unsubscription",
+		"kernel.proc.enable":  "Process Protection Enabled",
+		"kernel.proc.disable": "Process Protection Disabled",
+		"workdir":             "Working directory",
+		"binary":              "Executable",
+		"argv":                "Arguments",
+		"section":             "Section",
+		"status":              "Status",
+		"status.success":      "Success",
+		"status.failure":      "Failure",
+	},
+}
+
+func renderStatus(language string, ok bool) string {
+	status := catalog.Text(language, "status") + ": "
+	if ok {
+		return status + fmt.Sprintf("<u>%s</u>\n", catalog.Text(language, "status.success"))
+	}
+	return status + fmt.Sprintf("<u>%s</u>\n", catalog.Text(language, "status.failure"))
+}
+
+func RenderAuditProcReport(text, language string) (richText string) {
 	doc := map[string]interface{}{}
 
 	json.Unmarshal([]byte(text), &doc)
@@ -19,24 +60,19 @@ func RenderAuditProcReport(text string) (richText string) {
 	binary := doc["binary"].(string)
 	argv := doc["argv"].(string)
 
-	richText += "<b>进程审计</b>\n\n"
-	richText += "工作目录: "
+	richText += fmt.Sprintf("<b>%s</b>\n\n", catalog.Text(language, "audit.proc.report"))
+	richText += catalog.Text(language, "workdir") + ": "
 	richText += fmt.Sprintf("<u>%s</u>\n\n", workdir)
-	richText += "可执行程序: "
+	richText += catalog.Text(language, "binary") + ": "
 	richText += fmt.Sprintf("<u>%s</u>\n\n", binary)
-	richText += "参数列表: "
+	richText += catalog.Text(language, "argv") + ": "
 	richText += fmt.Sprintf("<u>%s</u>\n\n", argv)
-	richText += "状态: "
-	if judge == 1 {
-		richText += "<u>成功</u>\n"
-	} else {
-		richText += "<u>失败</u>\n"
-	}
+	richText += renderStatus(language, judge == 1)
 
 	return
 }
 
-func RenderUserMsgSub(text string) (rich string) {
+func RenderUserMsgSub(text, language string) (rich string) {
 	doc := map[string]interface{}{}
 
 	json.Unmarshal([]byte(text), &doc)
@@ -47,19 +83,14 @@ func RenderUserMsgSub(text string) (rich string) {
 	code := doc["code"].(float64)
 	section := doc["section"].(string)
 
-	rich += "<b>消息订阅</b>\n\n"
-	rich += "字段: "
+	rich += fmt.Sprintf("<b>%s</b>\n\n", catalog.Text(language, "user.msg.sub"))
+	rich += catalog.Text(language, "section") + ": "
 	rich += fmt.Sprintf("<u>%s</u>\n\n", section)
-	rich += "状态: "
-	if code == 0 {
-		rich += "<u>成功</u>\n"
-	} else {
-		rich += "<u>失败</u>\n"
-	}
+	rich += renderStatus(language, code == 0)
 	return
 }
 
-func RenderUserMsgUnsub(text string) (rich string) {
+func RenderUserMsgUnsub(text, language string) (rich string) {
 	doc := map[string]interface{}{}
 
 	json.Unmarshal([]byte(text), &doc)
@@ -70,19 +101,14 @@ func RenderUserMsgUnsub(text string) (rich string) {
 	code := doc["code"].(float64)
 	section := doc["section"].(string)
 
-	rich += "<b>消息退订</b>\n\n"
-	rich += "字段: "
+	rich += fmt.Sprintf("<b>%s</b>\n\n", catalog.Text(language, "user.msg.unsub"))
+	rich += catalog.Text(language, "section") + ": "
 	rich += fmt.Sprintf("<u>%s</u>\n\n", section)
-	rich += "状态: "
-	if code == 0 {
-		rich += "<u>成功</u>\n"
-	} else {
-		rich += "<u>失败</u>\n"
-	}
+	rich += renderStatus(language, code == 0)
 	return
 }
 
-func RenderKernelProcEnable(text string) (rich string) {
+func RenderKernelProcEnable(text, language string) (rich string) {
 	doc := map[string]interface{}{}
 
 	json.Unmarshal([]byte(text), &doc)
@@ -91,18 +117,13 @@ func RenderKernelProcEnable(text string) (rich string) {
 	}
 
 	code := doc["code"].(float64)
-	rich += "<b>开启进程保护</b>\n\n"
+	rich += fmt.Sprintf("<b>%s</b>\n\n", catalog.Text(language, "kernel.proc.enable"))
 
-	rich += "状态: "
-	if code == 0 {
-		rich += "<u>成功</u>\n"
-	} else {
-		rich += "<u>失败</u>\n"
-	}
+	rich += renderStatus(language, code == 0)
 	return
 }
 
-func RenderKernelProcDisable(text string) (rich string) {
+func RenderKernelProcDisable(text, language string) (rich string) {
 	doc := map[string]interface{}{}
 
 	json.Unmarshal([]byte(text), &doc)
@@ -111,13 +132,8 @@ func RenderKernelProcDisable(text string) (rich string) {
 	}
 
 	code := doc["code"].(float64)
-	rich += "<b>关闭进程保护</b>\n\n"
+	rich += fmt.Sprintf("<b>%s</b>\n\n", catalog.Text(language, "kernel.proc.disable"))
 
-	rich += "状态: "
-	if code == 0 {
-		rich += "<u>成功</u>\n"
-	} else {
-		rich += "<u>失败</u>\n"
-	}
+	rich += renderStatus(language, code == 0)
 	return
 }
@@ -19,12 +19,15 @@ type TelegramWorker struct {
 	wg      sync.WaitGroup
 	conn    *connector.Connector
 	bot     *Bot
+
+	language string
 }
 
-func NewWorker(token string, ownerID int64) *TelegramWorker {
+func NewWorker(token string, ownerID int64, language string) *TelegramWorker {
 	w := TelegramWorker{
-		bot:  NewBot(token, ownerID),
-		conn: connector.New(),
+		bot:      NewBot(token, ownerID),
+		conn:     connector.New(),
+		language: language,
 	}
 	return &w
 }
@@ -111,15 +114,15 @@ func (w *TelegramWorker) runReportToOwner() {
 		html := ""
 		switch doc["type"].(string) {
 		case "audit::proc::report":
-			html = RenderAuditProcReport(msg)
+			html = RenderAuditProcReport(msg, w.language)
 		case "user::msg::sub":
-			html = RenderUserMsgSub(msg)
+			html = RenderUserMsgSub(msg, w.language)
 		case "user::msg::unsub":
-			html = RenderUserMsgUnsub(msg)
+			html = RenderUserMsgUnsub(msg, w.language)
 		case "kernel::proc::enable":
-			html = RenderKernelProcEnable(msg)
+			html = RenderKernelProcEnable(msg, w.language)
 		case "kernel::proc::disable":
-			html = RenderKernelProcDisable(msg)
+			html = RenderKernelProcDisable(msg, w.language)
 		}
 		if html != "" {
 			w.bot.SendHtmlToOwner(html)
@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package audit
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lanthora/uranus/internal/web/render"
+	"github.com/lanthora/uranus/internal/web/user"
+)
+
+// 请求内容摘要的最大长度
+const requestSummaryMax = 256
+
+// 需要审计的模块
+var auditedGroups = []string{"/process/", "/file/", "/net/", "/admin/", "/ctrl/"}
+
+type Worker struct {
+	db *sql.DB
+}
+
+type Log struct {
+	ID        int64  `json:"id"`
+	Username  string `json:"username"`
+	Timestamp int64  `json:"timestamp"`
+	Endpoint  string `json:"endpoint"`
+	Request   string `json:"request"`
+	Status    int    `json:"status"`
+}
+
+// Init 需要在其他模块之前调用,否则之前注册的路由不会经过审计中间件
+func Init(router *gin.Engine, db *sql.DB) (err error) {
+	w := &Worker{
+		db: db,
+	}
+
+	if err = w.initAuditTable(); err != nil {
+		return
+	}
+
+	router.Use(w.middleware())
+
+	adminGroup := router.Group("/admin")
+	adminGroup.Use(user.AuthMiddleware())
+	adminGroup.POST("/listAuditLog", w.listAuditLog)
+	return
+}
+
+func needAudit(urlPath string) bool {
+	audited := false
+	for _, prefix := range auditedGroups {
+		if strings.HasPrefix(urlPath, prefix) {
+			audited = true
+			break
+		}
+	}
+	if !audited {
+		return false
+	}
+
+	// 只读接口不修改状态,不需要审计
+	return !user.IsReadonlyEndpoint(urlPath)
+}
+
+// 请求中需要隐藏的字段,字段名忽略大小写包含其中任意一个时隐藏
+var sensitiveKeys = []string{"password", "code", "token", "secret"}
+
+func sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range sensitiveKeys {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// redact 递归隐藏对象和数组中的敏感字段
+func redact(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			if sensitive(key) {
+				value[key] = "******"
+			} else {
+				value[key] = redact(item)
+			}
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redact(item)
+		}
+	}
+	return value
+}
+
+// summarize 隐藏请求中的密码,验证码和令牌等敏感字段,并按字符边界截断过长的内容
+func summarize(body []byte) string {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err == nil {
+		body, _ = json.Marshal(redact(doc))
+	}
+
+	summary := string(body)
+	if len(summary) > requestSummaryMax {
+		end := requestSummaryMax
+		for end > 0 && !utf8.RuneStart(summary[end]) {
+			end--
+		}
+		summary = summary[:end] + "..."
+	}
+	return summary
+}
+
+func (w *Worker) middleware() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		if !needAudit(context.Request.URL.Path) {
+			context.Next()
+			return
+		}
+
+		body := []byte{}
+		if context.Request.Body != nil {
+			body, _ = io.ReadAll(context.Request.Body)
+			context.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+		}
+
+		context.Next()
+
+		log := Log{
+			Timestamp: time.Now().Unix(),
+			Endpoint:  context.Request.URL.Path,
+			Request:   summarize(body),
+			Status:    render.StatusUnknownError,
+		}
+		if value, ok := context.Get(user.CurrentUserKey); ok {
+			log.Username = value.(user.User).Username
+		}
+		if value, ok := context.Get(render.StatusKey); ok {
+			log.Status = value.(int)
+		}
+		w.insertAuditLog(&log)
+	}
+}
+
+func (w *Worker) listAuditLog(context *gin.Context) {
+	request := struct {
+		Username string `json:"username"`
+		Limit    int    `json:"limit" binding:"number"`
+		Offset   int    `json:"offset" binding:"number"`
+	}{}
+
+	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+
+	logs, err := w.queryAuditLogLimitOffset(request.Username, request.Limit, request.Offset)
+	if err != nil {
+		render.Status(context, render.StatusAuditQueryLogFailed)
+		return
+	}
+	render.Success(context, logs)
+}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package audit
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	sqlCreateAuditTable         = `create table if not exists admin_audit(id integer primary key autoincrement, username text, timestamp integer not null, endpoint text not null, request text, status integer not null)`
+	sqlInsertAuditLog           = `insert into admin_audit(username,timestamp,endpoint,request,status) values(?,?,?,?,?)`
+	sqlQueryAuditLogLimitOffset = `select id,username,timestamp,endpoint,request,status from admin_audit where id>? and (?='' or username=?) order by id limit ?`
+)
+
+func (w *Worker) initAuditTable() (err error) {
+	_, err = w.db.Exec(sqlCreateAuditTable)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	return
+}
+
+func (w *Worker) insertAuditLog(log *Log) (err error) {
+	stmt, err := w.db.Prepare(sqlInsertAuditLog)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(log.Username, log.Timestamp, log.Endpoint, log.Request, log.Status)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	return
+}
+
+func (w *Worker) queryAuditLogLimitOffset(username string, limit, offset int) (logs []Log, err error) {
+	stmt, err := w.db.Prepare(sqlQueryAuditLogLimitOffset)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(offset, username, username, limit)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		log := Log{}
+		err = rows.Scan(&log.ID, &log.Username, &log.Timestamp, &log.Endpoint, &log.Request, &log.Status)
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+		logs = append(logs, log)
+	}
+	err = rows.Err()
+	if err != nil {
+		logrus.Error(err)
+	}
+	return
+}
@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrorInvalidCert = errors.New("invalid certificate")
+)
+
+const (
+	selfSignedCertFile = "web.crt"
+	selfSignedKeyFile  = "web.key"
+	selfSignedValidity = 10 * 365 * 24 * time.Hour
+)
+
+// loadOrCreateSelfSignedCert 返回持久化的自签名证书路径,证书不存在时生成新证书
+func loadOrCreateSelfSignedCert(dir string) (certFile, keyFile string, err error) {
+	certFile = filepath.Join(dir, selfSignedCertFile)
+	keyFile = filepath.Join(dir, selfSignedKeyFile)
+
+	if _, err = os.Stat(certFile); err == nil {
+		if _, err = os.Stat(keyFile); err == nil {
+			return
+		}
+	}
+
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		return
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return
+	}
+
+	hostname, _ := os.Hostname()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "uranus-web", Organization: []string{"hackernel"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname != "" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return
+	}
+
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err = os.WriteFile(keyFile, keyPem, 0600); err != nil {
+		return
+	}
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err = os.WriteFile(certFile, certPem, 0644); err != nil {
+		return
+	}
+
+	logrus.Infof("self-signed certificate created: %s", certFile)
+	return
+}
+
+// certFingerprint 计算证书的 sha256 指纹,供客户端固定证书使用
+func certFingerprint(certFile string) (fingerprint string, err error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		err = ErrorInvalidCert
+		return
+	}
+	sum := sha256.Sum256(block.Bytes)
+	fingerprint = hex.EncodeToString(sum[:])
+	return
+}
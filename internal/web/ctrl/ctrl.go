@@ -10,6 +10,7 @@ import (
 	"github.com/lanthora/uranus/internal/web/render"
 	"github.com/lanthora/uranus/internal/web/user"
 	"github.com/lanthora/uranus/pkg/ctrl"
+	"github.com/lanthora/uranus/pkg/logger"
 	"github.com/sirupsen/logrus"
 )
 
@@ -29,8 +30,10 @@ func Init(router *gin.Engine, db *sql.DB) (err error) {
 	ctrlGroup.Use(user.AuthMiddleware())
 
 	ctrlGroup.POST("/shutdown", w.shutdown)
-	ctrlGroup.GET("/enableDebug", w.enableDebug)
-	ctrlGroup.GET("/disableDebug", w.disableDebug)
+	ctrlGroup.POST("/enableDebug", w.enableDebug)
+	ctrlGroup.POST("/disableDebug", w.disableDebug)
+	ctrlGroup.POST("/updateLogLevel", w.updateLogLevel)
+	ctrlGroup.POST("/showLogLevel", w.showLogLevel)
 	return
 }
 
@@ -51,6 +54,33 @@ func (w *Worker) disableDebug(context *gin.Context) {
 	render.Status(context, render.StatusSuccess)
 }
 
+func (w *Worker) updateLogLevel(context *gin.Context) {
+	request := struct {
+		Level string `json:"level" binding:"required"`
+	}{}
+
+	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+
+	if err := logger.SetLevel(request.Level); err != nil {
+		render.Status(context, render.StatusInvalidArgument)
+		return
+	}
+	logrus.Infof("log level updated to %s", request.Level)
+	render.Status(context, render.StatusSuccess)
+}
+
+func (w *Worker) showLogLevel(context *gin.Context) {
+	response := struct {
+		Level string `json:"level"`
+	}{
+		Level: logger.GetLevel(),
+	}
+	render.Success(context, response)
+}
+
 func PProfMiddleware() gin.HandlerFunc {
 	return func(context *gin.Context) {
 		if strings.HasPrefix(context.Request.URL.Path, pprof.DefaultPrefix) {
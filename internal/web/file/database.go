@@ -2,6 +2,7 @@
 package file
 
 import (
+	"database/sql"
 	"time"
 
 	"github.com/lanthora/uranus/pkg/file"
@@ -11,25 +12,43 @@ import (
 const (
 	sqlInsertFilePolicy           = `insert into file_policy(path,fsid,ino,perm,timestamp,status) values(?,?,?,?,?,?)`
 	sqlUpdateFilePolicyById       = `update file_policy set fsid=?,ino=?,perm=?,timestamp=?,status=? where id=?`
-	sqlQueryFileEventLimitOffset  = `select id,path,fsid,ino,perm,timestamp,policy,status from file_event where id>? limit ?`
-	sqlQueryFilePolicyById        = `select id,path,fsid,ino,perm,timestamp,status from file_policy where id=?`
-	sqlQueryFilePolicyLimitOffset = `select id,path,fsid,ino,perm,timestamp,status from file_policy where id>? limit ?`
+	sqlQueryFileEventLimitOffset  = `select id,path,fsid,ino,perm,timestamp,policy,status,pid,uid,workdir,binary,argv,process from file_event where id>? limit ?`
+	sqlQueryFilePolicyById        = `select id,path,fsid,ino,perm,timestamp,status,rule from file_policy where id=?`
+	sqlQueryFilePolicyLimitOffset = `select id,path,fsid,ino,perm,timestamp,status,rule from file_policy where id>? limit ?`
 	sqlDeleteFilePolicyById       = `delete from file_policy where id=?`
 	sqlDeleteFileEventById        = `delete from file_event where id=?`
 	sqlUpdateFileEventStatusById  = `update file_event set status=? where id=?`
 	sqlQueryFileNormalPolicyCount = `select count(*) from file_policy where status=0`
 	sqlQueryFileUnreadEventCount  = `select count(*) from file_event where status=0`
+	sqlInsertFileRule             = `insert into file_rule(pattern,type,perm,timestamp) values(?,?,?,?)`
+	sqlQueryFileRuleById          = `select id,pattern,type,perm,timestamp from file_rule where id=?`
+	sqlQueryFileRuleLimitOffset   = `select id,pattern,type,perm,timestamp,(select count(*) from file_policy where rule=file_rule.id) from file_rule where id>? limit ?`
+	sqlDeleteFileRuleById         = `delete from file_rule where id=?`
+	sqlInsertRuleFilePolicy       = `insert into file_policy(path,fsid,ino,perm,timestamp,status,rule) values(?,?,?,?,?,?,?)`
+	sqlQueryFilePolicyByRule      = `select id,path,fsid,ino,perm,timestamp,status,rule from file_policy where rule=?`
+	sqlDeleteFilePolicyByRule     = `delete from file_policy where rule=?`
+	sqlQueryFilePolicyCountByPath = `select count(*) from file_policy where path=?`
 )
 
-func (w *Worker) insertFilePolicy(path string, fsid, ino int64, perm, status int) (err error) {
-	stmt, err := w.db.Prepare(sqlInsertFilePolicy)
+// preparer 是 *sql.DB 和 *sql.Tx 共有的方法,查询可以在事务内外使用
+type preparer interface {
+	Prepare(query string) (*sql.Stmt, error)
+}
+
+func (w *Worker) insertFilePolicy(tx *sql.Tx, path string, fsid, ino int64, perm, status int) (id int64, err error) {
+	stmt, err := tx.Prepare(sqlInsertFilePolicy)
 	if err != nil {
 		logrus.Error(err)
 		return
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(path, int64(fsid), int64(ino), perm, time.Now().Unix(), status)
+	result, err := stmt.Exec(path, int64(fsid), int64(ino), perm, time.Now().Unix(), status)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	id, err = result.LastInsertId()
 	if err != nil {
 		logrus.Error(err)
 		return
@@ -37,8 +56,8 @@ func (w *Worker) insertFilePolicy(path string, fsid, ino int64, perm, status int
 	return
 }
 
-func (w *Worker) updateFilePolicyById(fsid, ino int64, perm, status, id int) (err error) {
-	stmt, err := w.db.Prepare(sqlUpdateFilePolicyById)
+func (w *Worker) updateFilePolicyById(tx *sql.Tx, fsid, ino int64, perm, status int, id int64) (err error) {
+	stmt, err := tx.Prepare(sqlUpdateFilePolicyById)
 	if err != nil {
 		logrus.Error(err)
 		return
@@ -68,7 +87,8 @@ func (w *Worker) queryFileEventOffsetLimit(limit, offset int) (events []file.Eve
 	defer rows.Close()
 	for rows.Next() {
 		e := file.Event{}
-		err = rows.Scan(&e.ID, &e.Path, &e.Fsid, &e.Ino, &e.Perm, &e.Timestamp, &e.Policy, &e.Status)
+		err = rows.Scan(&e.ID, &e.Path, &e.Fsid, &e.Ino, &e.Perm, &e.Timestamp, &e.Policy, &e.Status,
+			&e.Pid, &e.Uid, &e.Workdir, &e.Binary, &e.Argv, &e.Process)
 		if err != nil {
 			logrus.Error(err)
 			return
@@ -79,15 +99,15 @@ func (w *Worker) queryFileEventOffsetLimit(limit, offset int) (events []file.Eve
 	return
 }
 
-func (w *Worker) queryFilePolicyById(id int) (event file.Policy, err error) {
-	stmt, err := w.db.Prepare(sqlQueryFilePolicyById)
+func (w *Worker) queryFilePolicyById(db preparer, id int) (event file.Policy, err error) {
+	stmt, err := db.Prepare(sqlQueryFilePolicyById)
 	if err != nil {
 		logrus.Error(err)
 		return
 	}
 	defer stmt.Close()
 
-	err = stmt.QueryRow(id).Scan(&event.ID, &event.Path, &event.Fsid, &event.Ino, &event.Perm, &event.Timestamp, &event.Status)
+	err = stmt.QueryRow(id).Scan(&event.ID, &event.Path, &event.Fsid, &event.Ino, &event.Perm, &event.Timestamp, &event.Status, &event.Rule)
 	if err != nil {
 		logrus.Error(err)
 	}
@@ -109,7 +129,7 @@ func (w *Worker) queryFilePolicyLimitOffset(limit, offset int) (policies []file.
 	defer rows.Close()
 	for rows.Next() {
 		policy := file.Policy{}
-		err = rows.Scan(&policy.ID, &policy.Path, &policy.Fsid, &policy.Ino, &policy.Perm, &policy.Timestamp, &policy.Status)
+		err = rows.Scan(&policy.ID, &policy.Path, &policy.Fsid, &policy.Ino, &policy.Perm, &policy.Timestamp, &policy.Status, &policy.Rule)
 		if err != nil {
 			logrus.Error(err)
 			return
@@ -123,8 +143,8 @@ func (w *Worker) queryFilePolicyLimitOffset(limit, offset int) (policies []file.
 	return
 }
 
-func (w *Worker) deleteFilePolicyById(id int) (err error) {
-	stmt, err := w.db.Prepare(sqlDeleteFilePolicyById)
+func (w *Worker) deleteFilePolicyById(tx *sql.Tx, id int) (err error) {
+	stmt, err := tx.Prepare(sqlDeleteFilePolicyById)
 	if err != nil {
 		logrus.Error(err)
 		return
@@ -202,3 +222,185 @@ func (w *Worker) queryFileUnreadEventCount() (count int, err error) {
 	}
 	return
 }
+
+func (w *Worker) insertFileRule(tx *sql.Tx, rule file.Rule) (id int64, err error) {
+	stmt, err := tx.Prepare(sqlInsertFileRule)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(rule.Pattern, rule.Type, rule.Perm, time.Now().Unix())
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	id, err = result.LastInsertId()
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	return
+}
+
+func (w *Worker) queryFileRuleById(tx *sql.Tx, id int64) (rule file.Rule, err error) {
+	stmt, err := tx.Prepare(sqlQueryFileRuleById)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRow(id).Scan(&rule.ID, &rule.Pattern, &rule.Type, &rule.Perm, &rule.Timestamp)
+	if err != nil {
+		logrus.Error(err)
+	}
+	return
+}
+
+func (w *Worker) queryFileRuleLimitOffset(limit, offset int) (rules []file.Rule, err error) {
+	stmt, err := w.db.Prepare(sqlQueryFileRuleLimitOffset)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(offset, limit)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rule := file.Rule{}
+		err = rows.Scan(&rule.ID, &rule.Pattern, &rule.Type, &rule.Perm, &rule.Timestamp, &rule.Policies)
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+		rules = append(rules, rule)
+	}
+	err = rows.Err()
+	if err != nil {
+		logrus.Error(err)
+	}
+	return
+}
+
+// deleteFileRuleById 同时删除规则展开的所有策略
+func (w *Worker) deleteFileRuleById(tx *sql.Tx, id int64) (err error) {
+	stmt, err := tx.Prepare(sqlDeleteFilePolicyByRule)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+	if _, err = stmt.Exec(id); err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	stmt, err = tx.Prepare(sqlDeleteFileRuleById)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+	if _, err = stmt.Exec(id); err != nil {
+		logrus.Error(err)
+		return
+	}
+	return
+}
+
+// queryUnprotectedPaths 过滤掉已经有策略的文件
+func (w *Worker) queryUnprotectedPaths(tx *sql.Tx, paths []string) (unprotected []string, err error) {
+	stmt, err := tx.Prepare(sqlQueryFilePolicyCountByPath)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+
+	for _, path := range paths {
+		n := 0
+		if err = stmt.QueryRow(path).Scan(&n); err != nil {
+			logrus.Error(err)
+			return
+		}
+		if n == 0 {
+			unprotected = append(unprotected, path)
+		}
+	}
+	return
+}
+
+// insertRulePolicies 插入规则展开并已经下发的文件策略
+func (w *Worker) insertRulePolicies(tx *sql.Tx, rule file.Rule, policies []file.Policy) (err error) {
+	stmt, err := tx.Prepare(sqlInsertRuleFilePolicy)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+
+	for _, policy := range policies {
+		_, err = stmt.Exec(policy.Path, policy.Fsid, policy.Ino, rule.Perm, time.Now().Unix(), file.StatusPolicyNormal, rule.ID)
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+	}
+	return
+}
+
+func (w *Worker) queryFilePolicyByRule(tx *sql.Tx, rule int64) (policies []file.Policy, err error) {
+	stmt, err := tx.Prepare(sqlQueryFilePolicyByRule)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(rule)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		policy := file.Policy{}
+		err = rows.Scan(&policy.ID, &policy.Path, &policy.Fsid, &policy.Ino, &policy.Perm, &policy.Timestamp, &policy.Status, &policy.Rule)
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+		policies = append(policies, policy)
+	}
+	err = rows.Err()
+	if err != nil {
+		logrus.Error(err)
+	}
+	return
+}
+
+// QueryPolicyLimitOffset 和下面的查询不经过 uranus-web 直接读取数据库,供 uranus-ctl 本地模式使用
+func QueryPolicyLimitOffset(db *sql.DB, limit, offset int) (policies []file.Policy, err error) {
+	w := &Worker{db: db}
+	return w.queryFilePolicyLimitOffset(limit, offset)
+}
+
+func QueryEventLimitOffset(db *sql.DB, limit, offset int) (events []file.Event, err error) {
+	w := &Worker{db: db}
+	return w.queryFileEventOffsetLimit(limit, offset)
+}
+
+func QueryPolicyCount(db *sql.DB) (count int, err error) {
+	w := &Worker{db: db}
+	return w.queryFileNormalPolicyCount()
+}
+
+func QueryUnreadEventCount(db *sql.DB) (count int, err error) {
+	w := &Worker{db: db}
+	return w.queryFileUnreadEventCount()
+}
@@ -3,8 +3,11 @@ package file
 
 import (
 	"database/sql"
+	"errors"
+	"path/filepath"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lanthora/uranus/internal/apply"
 	"github.com/lanthora/uranus/internal/config"
 	"github.com/lanthora/uranus/internal/web/render"
 	"github.com/lanthora/uranus/internal/web/user"
@@ -42,6 +45,10 @@ func Init(router *gin.Engine, db *sql.DB) (err error) {
 	fileGroup.POST("/listPolicies", w.listPolicies)
 	fileGroup.POST("/showPolicy", w.showPolicy)
 
+	fileGroup.POST("/addRule", w.addRule)
+	fileGroup.POST("/deleteRule", w.deleteRule)
+	fileGroup.POST("/listRules", w.listRules)
+
 	fileGroup.POST("/updateEventStatus", w.updateEventStatus)
 	fileGroup.POST("/deleteEvent", w.deleteEvent)
 	fileGroup.POST("/listEvents", w.listEvents)
@@ -100,112 +107,328 @@ func (w *Worker) disableModule(context *gin.Context) {
 	render.Status(context, render.StatusSuccess)
 }
 
+// setPolicy 下发文件策略并返回 hackernel 中的 fsid 和 ino
+func setPolicy(path string, perm, flag int) (fsid, ino int64, err error) {
+	fsid, ino, status, err := file.SetPolicy(path, perm, flag)
+	if err != nil {
+		return 0, 0, apply.PushError(err)
+	}
+	err = file.StatusError(status)
+	return
+}
+
+// AddPolicy 添加文件策略, push 为 false 时 fsid 和 ino 为 0,由 uranus-web 启动时根据路径重新获取.
+// 路径规范化后保存,文件被替换时按路径查询策略
+func AddPolicy(db *sql.DB, path string, perm int, push bool) (err error) {
+	w := &Worker{db: db}
+	path = filepath.Clean(path)
+	fsid, ino := int64(0), int64(0)
+	return apply.Run(w.db, apply.Change{
+		Push: func() (err error) {
+			fsid, ino, err = setPolicy(path, perm, file.FlagNew)
+			return
+		},
+		Commit: func(tx *sql.Tx) (err error) {
+			_, err = w.insertFilePolicy(tx, path, fsid, ino, perm, file.StatusPolicyNormal)
+			return
+		},
+		Revert: func() {
+			file.SetPolicy(path, 0, file.FlagAny)
+		},
+		Offline: !push,
+	})
+}
+
+// UpdatePolicy 更新文件策略的权限,规则展开的策略只能通过规则管理
+func UpdatePolicy(db *sql.DB, id int, perm int, push bool) (err error) {
+	w := &Worker{db: db}
+	old := file.Policy{}
+	fsid, ino := int64(0), int64(0)
+	return apply.Run(w.db, apply.Change{
+		Write: func(tx *sql.Tx) (err error) {
+			if old, err = w.queryFilePolicyById(tx, id); err != nil {
+				return
+			}
+			if old.Rule != 0 {
+				err = file.ErrorPolicyOwnedByRule
+			}
+			fsid, ino = old.Fsid, old.Ino
+			return
+		},
+		Push: func() (err error) {
+			fsid, ino, err = setPolicy(old.Path, perm, file.FlagUpdate)
+			return
+		},
+		Commit: func(tx *sql.Tx) error {
+			return w.updateFilePolicyById(tx, fsid, ino, perm, file.StatusPolicyNormal, old.ID)
+		},
+		Revert: func() {
+			file.SetPolicy(old.Path, old.Perm, file.FlagUpdate)
+		},
+		Offline: !push,
+	})
+}
+
+// DeletePolicy 删除策略时不检查文件是否存在,文件被删除后也可以删除对应的策略.
+// 规则展开的策略只能通过删除规则删除
+func DeletePolicy(db *sql.DB, id int, push bool) (err error) {
+	w := &Worker{db: db}
+	old := file.Policy{}
+	return apply.Run(w.db, apply.Change{
+		Write: func(tx *sql.Tx) (err error) {
+			if old, err = w.queryFilePolicyById(tx, id); err == nil && old.Rule != 0 {
+				err = file.ErrorPolicyOwnedByRule
+			}
+			return
+		},
+		Push: func() error {
+			_, _, _, err := file.SetPolicy(old.Path, 0, file.FlagAny)
+			return apply.PushError(err)
+		},
+		Commit: func(tx *sql.Tx) error {
+			return w.deleteFilePolicyById(tx, id)
+		},
+		Revert: func() {
+			file.SetPolicy(old.Path, old.Perm, file.FlagNew)
+		},
+		Offline: !push,
+	})
+}
+
 func (w *Worker) addPolicy(context *gin.Context) {
 	request := struct {
-		Path string `json:"path" binding:"required"`
-		Perm int    `json:"perm" binding:"number"`
+		Path string    `json:"path" binding:"required"`
+		Perm file.Perm `json:"perm" binding:"number"`
 	}{}
 
 	if err := context.ShouldBindJSON(&request); err != nil {
-		render.Status(context, render.StatusInvalidArgument)
-		return
-	}
-	fsid, ino, status, err := file.SetPolicy(request.Path, request.Perm, file.FlagNew)
-	if err != nil || status == file.StatusPolicyUnknown {
-		render.Status(context, render.StatusUnknownError)
+		render.InvalidArgument(context, err)
 		return
 	}
 
-	if status == file.StatusPolicyConflict {
+	err := AddPolicy(w.db, request.Path, int(request.Perm), true)
+	switch {
+	case err == nil:
+		render.Status(context, render.StatusSuccess)
+	case errors.Is(err, file.ErrorPolicyConflict):
 		render.Status(context, render.StatusFileAddPolicyConflict)
-		return
-	}
-
-	if status == file.StatusPolicyConflict {
+	case errors.Is(err, file.ErrorFileNotExist):
 		render.Status(context, render.StatusFileAddPolicyFileNotExist)
-		return
-	}
-
-	err = w.insertFilePolicy(request.Path, fsid, ino, request.Perm, file.StatusPolicyNormal)
-	if err != nil {
+	case errors.Is(err, file.ErrorPolicyUnknown), errors.Is(err, apply.ErrorPushFailed):
+		render.Status(context, render.StatusUnknownError)
+	default:
 		render.Status(context, render.StatusFileAddPolicyFailed)
-		return
 	}
-
-	render.Status(context, render.StatusSuccess)
 }
 
 func (w *Worker) updatePolicy(context *gin.Context) {
 	request := struct {
-		ID   int `json:"id" binding:"number"`
-		Perm int `json:"perm" binding:"number"`
+		ID   int       `json:"id" binding:"number"`
+		Perm file.Perm `json:"perm" binding:"number"`
 	}{}
 
 	if err := context.ShouldBindJSON(&request); err != nil {
-		render.Status(context, render.StatusInvalidArgument)
+		render.InvalidArgument(context, err)
 		return
 	}
 
-	policy, err := w.queryFilePolicyById(request.ID)
-	if err != nil {
+	err := UpdatePolicy(w.db, request.ID, int(request.Perm), true)
+	switch {
+	case err == nil:
+		render.Status(context, render.StatusSuccess)
+	case errors.Is(err, file.ErrorPolicyConflict):
+		render.Status(context, render.StatusFileUpdatePolicyConflict)
+	case errors.Is(err, file.ErrorFileNotExist):
+		render.Status(context, render.StatusFileUpdatePolicyFileNotExist)
+	case errors.Is(err, file.ErrorPolicyOwnedByRule):
+		render.Status(context, render.StatusFilePolicyOwnedByRule)
+	case errors.Is(err, apply.ErrorPushFailed), errors.Is(err, file.ErrorPolicyUnknown), err == sql.ErrNoRows:
 		render.Status(context, render.StatusUnknownError)
+	default:
+		render.Status(context, render.StatusFileUpdatePolicyFailed)
+	}
+}
+
+// deletePolicy 删除策略时不检查文件是否存在,文件被删除后也可以删除对应的策略
+func (w *Worker) deletePolicy(context *gin.Context) {
+	request := struct {
+		ID int `json:"id" binding:"number"`
+	}{}
+
+	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
 		return
 	}
 
-	fsid, ino, status, err := file.SetPolicy(policy.Path, request.Perm, file.FlagUpdate)
-	if err != nil || status == file.StatusPolicyUnknown {
+	err := DeletePolicy(w.db, request.ID, true)
+	switch {
+	case err == nil:
+		render.Status(context, render.StatusSuccess)
+	case errors.Is(err, file.ErrorPolicyOwnedByRule):
+		render.Status(context, render.StatusFilePolicyOwnedByRule)
+	case errors.Is(err, apply.ErrorPushFailed), err == sql.ErrNoRows:
 		render.Status(context, render.StatusUnknownError)
-		return
+	default:
+		render.Status(context, render.StatusFileDeletePolicyFailed)
 	}
+}
 
-	if status == file.StatusPolicyConflict {
-		render.Status(context, render.StatusFileUpdatePolicyConflict)
-		return
+func pushRulePolicies(rule file.Rule, paths []string, pushed *[]file.Policy) (err error) {
+	for _, path := range paths {
+		fsid, ino, status, err := file.SetPolicy(path, rule.Perm, file.FlagNew)
+		if err != nil {
+			revertRulePolicies(*pushed)
+			return apply.PushError(err)
+		}
+		if status != file.StatusPolicyNormal {
+			continue
+		}
+		*pushed = append(*pushed, file.Policy{Path: path, Fsid: fsid, Ino: ino, Perm: rule.Perm})
 	}
+	return
+}
 
-	if status == file.StatusPolicyConflict {
-		render.Status(context, render.StatusFileUpdatePolicyFileNotExist)
+func revertRulePolicies(pushed []file.Policy) {
+	for _, policy := range pushed {
+		file.SetPolicy(policy.Path, 0, file.FlagAny)
+	}
+}
+
+// addRule 添加目录或通配符规则, type 为 1 时保护目录下的文件, 2 时包含所有子目录, 3 时 pattern 为通配符.
+// 规则展开的文件策略由 worker 随文件的增加和删除更新
+func (w *Worker) addRule(context *gin.Context) {
+	request := struct {
+		Pattern string    `json:"pattern" binding:"required"`
+		Type    int       `json:"type" binding:"oneof=1 2 3"`
+		Perm    file.Perm `json:"perm" binding:"number"`
+	}{}
+
+	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
 		return
 	}
 
-	err = w.updateFilePolicyById(fsid, ino, request.Perm, file.StatusPolicyNormal, request.ID)
-	if err != nil {
-		logrus.Error(err)
-		render.Status(context, render.StatusFileUpdatePolicyFailed)
+	rule := file.Rule{Pattern: request.Pattern, Type: request.Type, Perm: int(request.Perm)}
+	if err := rule.Validate(); err != nil {
+		render.InvalidArgument(context, err)
 		return
 	}
 
-	render.Status(context, render.StatusSuccess)
+	// 遍历目录可能比较耗时,在事务外完成
+	paths, _, err := rule.Walk("")
+	pushed := []file.Policy{}
+	if err == nil {
+		err = apply.Run(w.db, apply.Change{
+			Write: func(tx *sql.Tx) (err error) {
+				paths, err = w.queryUnprotectedPaths(tx, paths)
+				return
+			},
+			Push: func() error {
+				return pushRulePolicies(rule, paths, &pushed)
+			},
+			Commit: func(tx *sql.Tx) (err error) {
+				if rule.ID, err = w.insertFileRule(tx, rule); err != nil {
+					return
+				}
+				return w.insertRulePolicies(tx, rule, pushed)
+			},
+			Revert: func() {
+				revertRulePolicies(pushed)
+			},
+		})
+	}
+	switch {
+	case err == nil:
+		response := struct {
+			ID       int64 `json:"id"`
+			Policies int   `json:"policies"`
+		}{
+			ID:       rule.ID,
+			Policies: len(pushed),
+		}
+		render.Success(context, response)
+	case errors.Is(err, file.ErrorFileNotExist):
+		render.Status(context, render.StatusFileAddPolicyFileNotExist)
+	case errors.Is(err, file.ErrorRuleTooManyFiles):
+		render.Status(context, render.StatusFileAddRuleTooManyFiles)
+	case errors.Is(err, apply.ErrorPushFailed):
+		render.Status(context, render.StatusUnknownError)
+	default:
+		render.Status(context, render.StatusFileAddRuleFailed)
+	}
 }
 
-func (w *Worker) deletePolicy(context *gin.Context) {
+// deleteRule 删除规则及其展开的所有文件策略
+func (w *Worker) deleteRule(context *gin.Context) {
 	request := struct {
-		ID int `json:"id" binding:"number"`
+		ID int64 `json:"id" binding:"number"`
 	}{}
 
 	if err := context.ShouldBindJSON(&request); err != nil {
-		render.Status(context, render.StatusInvalidArgument)
+		render.InvalidArgument(context, err)
 		return
 	}
 
-	policy, err := w.queryFilePolicyById(request.ID)
-	if err != nil {
+	policies := []file.Policy{}
+	removed := []file.Policy{}
+	revert := func() {
+		for _, policy := range removed {
+			file.SetPolicy(policy.Path, policy.Perm, file.FlagNew)
+		}
+	}
+	err := apply.Run(w.db, apply.Change{
+		Write: func(tx *sql.Tx) (err error) {
+			if _, err = w.queryFileRuleById(tx, request.ID); err != nil {
+				return
+			}
+			policies, err = w.queryFilePolicyByRule(tx, request.ID)
+			return
+		},
+		Push: func() error {
+			for _, policy := range policies {
+				if policy.Status != file.StatusPolicyNormal {
+					continue
+				}
+				if _, _, _, err := file.SetPolicy(policy.Path, 0, file.FlagAny); err != nil {
+					revert()
+					return apply.PushError(err)
+				}
+				removed = append(removed, policy)
+			}
+			return nil
+		},
+		Commit: func(tx *sql.Tx) error {
+			return w.deleteFileRuleById(tx, request.ID)
+		},
+		Revert: revert,
+	})
+	switch {
+	case err == nil:
+		render.Status(context, render.StatusSuccess)
+	case errors.Is(err, apply.ErrorPushFailed), err == sql.ErrNoRows:
 		render.Status(context, render.StatusUnknownError)
-		return
+	default:
+		render.Status(context, render.StatusFileDeleteRuleFailed)
 	}
+}
 
-	_, _, _, err = file.SetPolicy(policy.Path, 0, file.FlagAny)
-	if err != nil {
-		render.Status(context, render.StatusUnknownError)
+func (w *Worker) listRules(context *gin.Context) {
+	request := struct {
+		Limit  int `json:"limit" binding:"number"`
+		Offset int `json:"offset" binding:"number"`
+	}{}
+
+	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
 		return
 	}
 
-	err = w.deleteFilePolicyById(request.ID)
+	rules, err := w.queryFileRuleLimitOffset(request.Limit, request.Offset)
 	if err != nil {
-		render.Status(context, render.StatusFileDeletePolicyFailed)
+		render.Status(context, render.StatusFileQueryRuleListFailed)
 		return
 	}
-	render.Status(context, render.StatusSuccess)
+	render.Success(context, rules)
 }
 
 // TODO: 清空配置列表
@@ -220,7 +443,7 @@ func (w *Worker) listPolicies(context *gin.Context) {
 	}{}
 
 	if err := context.ShouldBindJSON(&request); err != nil {
-		render.Status(context, render.StatusInvalidArgument)
+		render.InvalidArgument(context, err)
 		return
 	}
 
@@ -239,11 +462,11 @@ func (w *Worker) showPolicy(context *gin.Context) {
 	}{}
 
 	if err := context.ShouldBindJSON(&request); err != nil {
-		render.Status(context, render.StatusInvalidArgument)
+		render.InvalidArgument(context, err)
 		return
 	}
 
-	policy, err := w.queryFilePolicyById(request.ID)
+	policy, err := w.queryFilePolicyById(w.db, request.ID)
 	if err != nil {
 		render.Status(context, render.StatusFileQueryPolicyByIdFailed)
 		return
@@ -258,7 +481,7 @@ func (w *Worker) listEvents(context *gin.Context) {
 	}{}
 
 	if err := context.ShouldBindJSON(&request); err != nil {
-		render.Status(context, render.StatusInvalidArgument)
+		render.InvalidArgument(context, err)
 		return
 	}
 
@@ -276,7 +499,7 @@ func (w *Worker) deleteEvent(context *gin.Context) {
 	}{}
 
 	if err := context.ShouldBindJSON(&request); err != nil {
-		render.Status(context, render.StatusInvalidArgument)
+		render.InvalidArgument(context, err)
 		return
 	}
 
@@ -295,7 +518,7 @@ func (w *Worker) updateEventStatus(context *gin.Context) {
 	}{}
 
 	if err := context.ShouldBindJSON(&request); err != nil {
-		render.Status(context, render.StatusInvalidArgument)
+		render.InvalidArgument(context, err)
 		return
 	}
 
@@ -0,0 +1,26 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package web
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// 内嵌的 webui 只加载同源的脚本和样式,组件库会写入内联样式
+const contentSecurityPolicy = "default-src 'self'; script-src 'self'; style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data:; font-src 'self' data:; connect-src 'self'; " +
+	"object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+func securityHeaders() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		header := context.Writer.Header()
+		header.Set("Content-Security-Policy", contentSecurityPolicy)
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Referrer-Policy", "no-referrer")
+		header.Set("Cross-Origin-Opener-Policy", "same-origin")
+		if context.Request.TLS != nil {
+			header.Set("Strict-Transport-Security", "max-age=31536000")
+		}
+		context.Next()
+	}
+}
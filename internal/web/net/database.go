@@ -2,30 +2,54 @@
 package net
 
 import (
+	"database/sql"
+
 	"github.com/lanthora/uranus/pkg/net"
 	"github.com/sirupsen/logrus"
 )
 
 const (
-	sqlInsertNetPolicy           = `insert into net_policy(priority,addr_src_begin,addr_src_end,addr_dst_begin,addr_dst_end,protocol_begin,protocol_end,port_src_begin,port_src_end,port_dst_begin,port_dst_end,flags,response) values(?,?,?,?,?,?,?,?,?,?,?,?,?)`
-	sqlDeleteNetPolicyById       = `delete from net_policy where id=?`
-	sqlQueryNetPolicyLimitOffset = `select id,priority,addr_src_begin,addr_src_end,addr_dst_begin,addr_dst_end,protocol_begin,protocol_end,port_src_begin,port_src_end,port_dst_begin,port_dst_end,flags,response from net_policy where id>? limit ?`
-	sqlQueryNetEventLimitOffset  = `select id,protocol,saddr,daddr,sport,dport,timestamp,policy,status from net_event where id>? limit ?`
-	sqlDeleteNetEventById        = `delete from net_event where id=?`
-	sqlUpdateNetEventStatusById  = `update net_event set status=? where id=?`
-	sqlQueryNetPolicyCount       = `select count(*) from net_policy`
-	sqlQueryNetUnreadEventCount  = `select count(*) from net_event where status=0`
+	sqlInsertNetPolicy              = `insert into net_policy(id,priority,addr_src_begin,addr_src_end,addr_dst_begin,addr_dst_end,protocol_begin,protocol_end,port_src_begin,port_src_end,port_dst_begin,port_dst_end,flags,response) values(?,?,?,?,?,?,?,?,?,?,?,?,?,?)`
+	sqlReserveNetPolicyId           = `insert into net_policy default values`
+	sqlDeleteNetPolicyById          = `delete from net_policy where id=?`
+	sqlQueryNetPolicyById           = `select id,priority,addr_src_begin,addr_src_end,addr_dst_begin,addr_dst_end,protocol_begin,protocol_end,port_src_begin,port_src_end,port_dst_begin,port_dst_end,flags,response from net_policy where id=?`
+	sqlUpdateNetPolicyById          = `update net_policy set priority=?,addr_src_begin=?,addr_src_end=?,addr_dst_begin=?,addr_dst_end=?,protocol_begin=?,protocol_end=?,port_src_begin=?,port_src_end=?,port_dst_begin=?,port_dst_end=?,flags=?,response=? where id=?`
+	sqlQueryNetPolicyLimitOffset    = `select id,priority,addr_src_begin,addr_src_end,addr_dst_begin,addr_dst_end,protocol_begin,protocol_end,port_src_begin,port_src_end,port_dst_begin,port_dst_end,flags,response from net_policy where id>? limit ?`
+	sqlQueryNetEventLimitOffset     = `select id,protocol,saddr,daddr,sport,dport,timestamp,policy,status from net_event where id>? limit ?`
+	sqlQueryNetIPv4EventLimitOffset = `select id,protocol,saddr,daddr,sport,dport,timestamp,policy,status from net_event where id>? and instr(saddr,':')=0 limit ?`
+	sqlQueryNetIPv6EventLimitOffset = `select id,protocol,saddr,daddr,sport,dport,timestamp,policy,status from net_event where id>? and instr(saddr,':')>0 limit ?`
+	sqlDeleteNetEventById           = `delete from net_event where id=?`
+	sqlUpdateNetEventStatusById     = `update net_event set status=? where id=?`
+	sqlQueryNetPolicyCount          = `select count(*) from net_policy`
+	sqlQueryNetUnreadEventCount     = `select count(*) from net_event where status=0`
 )
 
-func (w *Worker) insertNetPolicy(policy *net.Policy) (id int64, err error) {
-	stmt, err := w.db.Prepare(sqlInsertNetPolicy)
+// reserveNetPolicyId 插入一条空记录后立即删除,占用一个不会被再次分配的自增 id
+func (w *Worker) reserveNetPolicyId(tx *sql.Tx) (id int64, err error) {
+	result, err := tx.Exec(sqlReserveNetPolicyId)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	id, err = result.LastInsertId()
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	err = w.deleteNetPolicyById(tx, id)
+	return
+}
+
+// insertNetPolicy 使用 reserveNetPolicyId 占用的 id 保存策略
+func (w *Worker) insertNetPolicy(tx *sql.Tx, policy *net.Policy) (err error) {
+	stmt, err := tx.Prepare(sqlInsertNetPolicy)
 	if err != nil {
 		logrus.Error(err)
 		return
 	}
 	defer stmt.Close()
 
-	result, err := stmt.Exec(policy.Priority,
+	_, err = stmt.Exec(policy.ID, policy.Priority,
 		policy.Addr.Src.Begin, policy.Addr.Src.End,
 		policy.Addr.Dst.Begin, policy.Addr.Dst.End,
 		policy.Protocol.Begin, policy.Protocol.End,
@@ -36,16 +60,32 @@ func (w *Worker) insertNetPolicy(policy *net.Policy) (id int64, err error) {
 		logrus.Error(err)
 		return
 	}
-	id, err = result.LastInsertId()
+	return
+}
+
+func (w *Worker) updateNetPolicyById(tx *sql.Tx, policy *net.Policy) (err error) {
+	stmt, err := tx.Prepare(sqlUpdateNetPolicyById)
 	if err != nil {
 		logrus.Error(err)
 		return
 	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(policy.Priority,
+		policy.Addr.Src.Begin, policy.Addr.Src.End,
+		policy.Addr.Dst.Begin, policy.Addr.Dst.End,
+		policy.Protocol.Begin, policy.Protocol.End,
+		policy.Port.Src.Begin, policy.Port.Src.End,
+		policy.Port.Dst.Begin, policy.Port.Dst.End,
+		policy.Flags, policy.Response, policy.ID)
+	if err != nil {
+		logrus.Error(err)
+	}
 	return
 }
 
-func (w *Worker) deleteNetPolicyById(id int) (err error) {
-	stmt, err := w.db.Prepare(sqlDeleteNetPolicyById)
+func (w *Worker) deleteNetPolicyById(tx *sql.Tx, id int64) (err error) {
+	stmt, err := tx.Prepare(sqlDeleteNetPolicyById)
 	if err != nil {
 		logrus.Error(err)
 		return
@@ -70,6 +110,31 @@ func (w *Worker) deleteNetPolicyById(id int) (err error) {
 	return
 }
 
+func (w *Worker) queryNetPolicyById(tx *sql.Tx, id int64) (policy net.Policy, err error) {
+	stmt, err := tx.Prepare(sqlQueryNetPolicyById)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRow(id).Scan(&policy.ID, &policy.Priority,
+		&policy.Addr.Src.Begin, &policy.Addr.Src.End,
+		&policy.Addr.Dst.Begin, &policy.Addr.Dst.End,
+		&policy.Protocol.Begin, &policy.Protocol.End,
+		&policy.Port.Src.Begin, &policy.Port.Src.End,
+		&policy.Port.Dst.Begin, &policy.Port.Dst.End,
+		&policy.Flags, &policy.Response)
+	if err == sql.ErrNoRows {
+		err = net.ErrorPolicyNotExist
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+	}
+	return
+}
+
 func (w *Worker) queryNetPolicyLimitOffset(limit, offset int) (policies []net.Policy, err error) {
 	stmt, err := w.db.Prepare(sqlQueryNetPolicyLimitOffset)
 	if err != nil {
@@ -105,8 +170,17 @@ func (w *Worker) queryNetPolicyLimitOffset(limit, offset int) (policies []net.Po
 	return
 }
 
-func (w *Worker) queryNetEventOffsetLimit(limit, offset int) (events []net.Event, err error) {
-	stmt, err := w.db.Prepare(sqlQueryNetEventLimitOffset)
+// queryNetEventOffsetLimit 按协议族过滤事件, family 为 0 时返回所有事件.
+// 事件地址入库时已经转换成规范形式,只有 IPv6 地址包含冒号
+func (w *Worker) queryNetEventOffsetLimit(limit, offset, family int) (events []net.Event, err error) {
+	query := sqlQueryNetEventLimitOffset
+	switch family {
+	case net.FamilyIPv4:
+		query = sqlQueryNetIPv4EventLimitOffset
+	case net.FamilyIPv6:
+		query = sqlQueryNetIPv6EventLimitOffset
+	}
+	stmt, err := w.db.Prepare(query)
 	if err != nil {
 		logrus.Error(err)
 		return
@@ -127,6 +201,7 @@ func (w *Worker) queryNetEventOffsetLimit(limit, offset int) (events []net.Event
 			logrus.Error(err)
 			return
 		}
+		e.Family = net.AddrFamily(e.SrcAddr)
 		events = append(events, e)
 	}
 	err = rows.Err()
@@ -196,3 +271,24 @@ func (w *Worker) queryNetUnreadEventCount() (count int, err error) {
 	}
 	return
 }
+
+// QueryPolicyLimitOffset 和下面的查询不经过 uranus-web 直接读取数据库,供 uranus-ctl 本地模式使用
+func QueryPolicyLimitOffset(db *sql.DB, limit, offset int) (policies []net.Policy, err error) {
+	w := &Worker{db: db}
+	return w.queryNetPolicyLimitOffset(limit, offset)
+}
+
+func QueryEventLimitOffset(db *sql.DB, limit, offset, family int) (events []net.Event, err error) {
+	w := &Worker{db: db}
+	return w.queryNetEventOffsetLimit(limit, offset, family)
+}
+
+func QueryPolicyCount(db *sql.DB) (count int, err error) {
+	w := &Worker{db: db}
+	return w.queryNetPolicyCount()
+}
+
+func QueryUnreadEventCount(db *sql.DB) (count int, err error) {
+	w := &Worker{db: db}
+	return w.queryNetUnreadEventCount()
+}
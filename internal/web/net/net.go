@@ -3,8 +3,10 @@ package net
 
 import (
 	"database/sql"
+	"errors"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lanthora/uranus/internal/apply"
 	"github.com/lanthora/uranus/internal/config"
 	"github.com/lanthora/uranus/internal/web/render"
 	"github.com/lanthora/uranus/internal/web/user"
@@ -36,6 +38,8 @@ func Init(router *gin.Engine, db *sql.DB) (err error) {
 	netGroup.POST("/showModuleStatus", w.showModuleStatus)
 
 	netGroup.POST("/addPolicy", w.addPolicy)
+	netGroup.POST("/addRule", w.addRule)
+	netGroup.POST("/updatePolicy", w.updatePolicy)
 	netGroup.POST("/deletePolicy", w.deletePolicy)
 	netGroup.POST("/listPolicies", w.listPolicies)
 
@@ -104,21 +108,159 @@ func (w *Worker) addPolicy(context *gin.Context) {
 	request := net.Policy{}
 
 	if err := context.ShouldBindJSON(&request); err != nil {
-		render.Status(context, render.StatusInvalidArgument)
+		render.InvalidArgument(context, err)
 		return
 	}
 
-	id, err := w.insertNetPolicy(&request)
+	// 地址可以使用 CIDR 表示, end 为空时表示单个地址
+	if err := request.Normalize(); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+	if err := request.Validate(); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+
+	w.savePolicy(context, request)
+}
+
+// addRule 添加文本形式的网络策略,例如 deny out tcp 10.0.0.0/8 -> any:22 prio 10
+func (w *Worker) addRule(context *gin.Context) {
+	request := struct {
+		Rule string `json:"rule" binding:"required"`
+	}{}
+
+	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+
+	policy, err := net.ParseRule(request.Rule)
 	if err != nil {
-		render.Status(context, render.StatusNetAddPolicyDatabaseFailed)
+		render.InvalidArgument(context, err)
 		return
 	}
 
-	request.ID = id
-	if ok := net.AddPolicy(request); !ok {
+	w.savePolicy(context, policy)
+}
+
+// AddPolicy 保存并下发网络策略, policy 需要先检查合法性.
+// hackernel 使用数据库中的 id,先占用 id,下发成功后再插入记录
+func AddPolicy(db *sql.DB, policy net.Policy, push bool) (id int64, err error) {
+	w := &Worker{db: db}
+	err = apply.Run(w.db, apply.Change{
+		Write: func(tx *sql.Tx) (err error) {
+			policy.ID, err = w.reserveNetPolicyId(tx)
+			return
+		},
+		Push: func() error {
+			return apply.Pushed(net.AddPolicy(policy))
+		},
+		Commit: func(tx *sql.Tx) error {
+			return w.insertNetPolicy(tx, &policy)
+		},
+		Revert: func() {
+			net.DeletePolicy(int(policy.ID))
+		},
+		Offline: !push,
+	})
+	id = policy.ID
+	return
+}
+
+// UpdatePolicy 原地替换网络策略, id 保持不变.
+// 替换时过渡策略占用一个新的 id,替换过程中 hackernel 始终有策略生效,过渡策略在替换后删除
+func UpdatePolicy(db *sql.DB, policy net.Policy, push bool) (err error) {
+	w := &Worker{db: db}
+	old, temporary := net.Policy{}, int64(0)
+	return apply.Run(w.db, apply.Change{
+		Write: func(tx *sql.Tx) (err error) {
+			if old, err = w.queryNetPolicyById(tx, policy.ID); err != nil {
+				return
+			}
+			temporary, err = w.reserveNetPolicyId(tx)
+			return
+		},
+		Push: func() error {
+			return apply.Pushed(net.ReplacePolicy(old, policy, temporary))
+		},
+		Commit: func(tx *sql.Tx) error {
+			return w.updateNetPolicyById(tx, &policy)
+		},
+		Revert: func() {
+			net.ReplacePolicy(policy, old, temporary)
+		},
+		Offline: !push,
+	})
+}
+
+func DeletePolicy(db *sql.DB, id int64, push bool) (err error) {
+	w := &Worker{db: db}
+	old := net.Policy{}
+	return apply.Run(w.db, apply.Change{
+		Write: func(tx *sql.Tx) (err error) {
+			old, err = w.queryNetPolicyById(tx, id)
+			return
+		},
+		Push: func() error {
+			return apply.Pushed(net.DeletePolicy(int(id)))
+		},
+		Commit: func(tx *sql.Tx) error {
+			return w.deleteNetPolicyById(tx, id)
+		},
+		Revert: func() {
+			net.AddPolicy(old)
+		},
+		Offline: !push,
+	})
+}
+
+func (w *Worker) savePolicy(context *gin.Context, policy net.Policy) {
+	_, err := AddPolicy(w.db, policy, true)
+	if errors.Is(err, apply.ErrorPushFailed) {
 		render.Status(context, render.StatusNetAddPolicyFailed)
 		return
 	}
+	if err != nil {
+		render.Status(context, render.StatusNetAddPolicyDatabaseFailed)
+		return
+	}
+
+	render.Status(context, render.StatusSuccess)
+}
+
+// updatePolicy 原地替换网络策略, id 保持不变
+func (w *Worker) updatePolicy(context *gin.Context) {
+	request := net.Policy{}
+
+	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+
+	if err := request.Normalize(); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+	if err := request.Validate(); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+
+	err := UpdatePolicy(w.db, request, true)
+	if err == net.ErrorPolicyNotExist {
+		render.Status(context, render.StatusNetPolicyNotExist)
+		return
+	}
+	if errors.Is(err, apply.ErrorPushFailed) {
+		render.Status(context, render.StatusNetUpdatePolicyFailed)
+		return
+	}
+	if err != nil {
+		render.Status(context, render.StatusNetUpdatePolicyDatabaseFailed)
+		return
+	}
 
 	render.Status(context, render.StatusSuccess)
 }
@@ -129,24 +271,21 @@ func (w *Worker) deletePolicy(context *gin.Context) {
 	}{}
 
 	if err := context.ShouldBindJSON(&request); err != nil {
-		render.Status(context, render.StatusInvalidArgument)
+		render.InvalidArgument(context, err)
 		return
 	}
 
-	err := w.deleteNetPolicyById(request.ID)
+	err := DeletePolicy(w.db, int64(request.ID), true)
 	if err == net.ErrorPolicyNotExist {
 		render.Status(context, render.StatusNetPolicyNotExist)
 		return
 	}
-
-	if err != nil {
-		render.Status(context, render.StatusNetDeletePolicyDatabaseFailed)
+	if errors.Is(err, apply.ErrorPushFailed) {
+		render.Status(context, render.StatusNetDeletePolicyFailed)
 		return
 	}
-
-	ok := net.DeletePolicy(request.ID)
-	if !ok {
-		render.Status(context, render.StatusNetDeletePolicyFailed)
+	if err != nil {
+		render.Status(context, render.StatusNetDeletePolicyDatabaseFailed)
 		return
 	}
 
@@ -160,7 +299,7 @@ func (w *Worker) listPolicies(context *gin.Context) {
 	}{}
 
 	if err := context.ShouldBindJSON(&request); err != nil {
-		render.Status(context, render.StatusInvalidArgument)
+		render.InvalidArgument(context, err)
 		return
 	}
 
@@ -173,18 +312,20 @@ func (w *Worker) listPolicies(context *gin.Context) {
 	render.Success(context, policies)
 }
 
+// listEvents 返回网络事件, family 为 4 或 6 时只返回对应协议族的事件
 func (w *Worker) listEvents(context *gin.Context) {
 	request := struct {
 		Limit  int `json:"limit" binding:"number"`
 		Offset int `json:"offset" binding:"number"`
+		Family int `json:"family" binding:"oneof=0 4 6"`
 	}{}
 
 	if err := context.ShouldBindJSON(&request); err != nil {
-		render.Status(context, render.StatusInvalidArgument)
+		render.InvalidArgument(context, err)
 		return
 	}
 
-	events, err := w.queryNetEventOffsetLimit(request.Limit, request.Offset)
+	events, err := w.queryNetEventOffsetLimit(request.Limit, request.Offset, request.Family)
 	if err != nil {
 		render.Status(context, render.StatusNetQueryEventFailed)
 		return
@@ -198,7 +339,7 @@ func (w *Worker) deleteEvent(context *gin.Context) {
 	}{}
 
 	if err := context.ShouldBindJSON(&request); err != nil {
-		render.Status(context, render.StatusInvalidArgument)
+		render.InvalidArgument(context, err)
 		return
 	}
 
@@ -217,7 +358,7 @@ func (w *Worker) updateEventStatus(context *gin.Context) {
 	}{}
 
 	if err := context.ShouldBindJSON(&request); err != nil {
-		render.Status(context, render.StatusInvalidArgument)
+		render.InvalidArgument(context, err)
 		return
 	}
 
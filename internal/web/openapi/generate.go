@@ -0,0 +1,423 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//go:build ignore
+
+// generate.go 解析各模块注册的路由以及处理函数中的请求和响应结构体,生成 openapi.json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	modulePath = "github.com/lanthora/uranus"
+	moduleRoot = "../../.."
+)
+
+// 测试时输出到临时文件,与提交的 openapi.json 比较
+var output = flag.String("output", "openapi.json", "output file")
+
+// 注册路由的模块,与 web.Init 中的初始化顺序保持一致
+var modules = []string{"audit", "user", "process", "file", "net", "ctrl", "status"}
+
+// 不需要登录就能访问的接口
+var anonymous = map[string]bool{
+	"/auth/login": true,
+	"/auth/setup": true,
+}
+
+type moduleImporter struct {
+	fset     *token.FileSet
+	std      types.Importer
+	packages map[string]*types.Package
+}
+
+func (m *moduleImporter) Import(importPath string) (*types.Package, error) {
+	if pkg, ok := m.packages[importPath]; ok {
+		return pkg, nil
+	}
+	if strings.HasPrefix(importPath, modulePath+"/") {
+		dir := filepath.Join(moduleRoot, strings.TrimPrefix(importPath, modulePath+"/"))
+		pkg, _, _, err := m.check(importPath, dir)
+		return pkg, err
+	}
+	if pkg, err := m.std.Import(importPath); err == nil {
+		m.packages[importPath] = pkg
+		return pkg, nil
+	}
+	// 第三方依赖不会出现在请求和响应结构体中,用空包代替,忽略由此产生的类型错误
+	pkg := types.NewPackage(importPath, path.Base(importPath))
+	pkg.MarkComplete()
+	m.packages[importPath] = pkg
+	return pkg, nil
+}
+
+func (m *moduleImporter) check(importPath, dir string) (pkg *types.Package, files []*ast.File, info *types.Info, err error) {
+	buildPkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return
+	}
+	for _, name := range buildPkg.GoFiles {
+		file, err := parser.ParseFile(m.fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		files = append(files, file)
+	}
+
+	info = &types.Info{
+		Types: map[ast.Expr]types.TypeAndValue{},
+	}
+	config := types.Config{
+		Importer: m,
+		Error:    func(error) {},
+	}
+	pkg, _ = config.Check(importPath, m.fset, files, info)
+	m.packages[importPath] = pkg
+	return
+}
+
+type route struct {
+	method  string
+	path    string
+	handler string
+}
+
+// overrides 是自定义了 JSON 编解码的类型,无法从 Go 类型推导出结构
+var overrides = map[string]map[string]interface{}{
+	"file.Perm": {
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "integer", "format": "int64"},
+			map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string", "enum": []string{"read", "write", "unlink", "rename", "audit-read", "audit-write", "audit-unlink", "audit-rename", "all", "audit-all"}}},
+		},
+	},
+}
+
+type generator struct {
+	components map[string]interface{}
+}
+
+// schema 把 Go 类型转换成 JSON Schema,具名结构体放到 components 中引用
+func (g *generator) schema(t types.Type) map[string]interface{} {
+	switch t := t.(type) {
+	case *types.Pointer:
+		return g.schema(t.Elem())
+	case *types.Named:
+		if t.Obj().Pkg() != nil {
+			if schema, ok := overrides[t.Obj().Pkg().Name()+"."+t.Obj().Name()]; ok {
+				return schema
+			}
+		}
+		if _, ok := t.Underlying().(*types.Struct); ok && t.Obj().Pkg() != nil {
+			name := t.Obj().Pkg().Name() + "." + t.Obj().Name()
+			if _, ok := g.components[name]; !ok {
+				g.components[name] = nil
+				g.components[name] = g.schema(t.Underlying())
+			}
+			return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+		}
+		return g.schema(t.Underlying())
+	case *types.Basic:
+		switch {
+		case t.Info()&types.IsBoolean != 0:
+			return map[string]interface{}{"type": "boolean"}
+		case t.Info()&types.IsInteger != 0:
+			return map[string]interface{}{"type": "integer", "format": "int64"}
+		case t.Info()&types.IsFloat != 0:
+			return map[string]interface{}{"type": "number"}
+		case t.Info()&types.IsString != 0:
+			return map[string]interface{}{"type": "string"}
+		}
+	case *types.Slice:
+		if basic, ok := t.Elem().(*types.Basic); ok && basic.Kind() == types.Byte {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case *types.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case *types.Struct:
+		properties := map[string]interface{}{}
+		required := []string{}
+		g.fields(t, properties, &required)
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) != 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	return map[string]interface{}{}
+}
+
+func (g *generator) fields(t *types.Struct, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumFields(); i++ {
+		field := t.Field(i)
+		tag := reflect.StructTag(t.Tag(i))
+		name := strings.Split(tag.Get("json"), ",")[0]
+		if name == "-" || !field.Exported() {
+			continue
+		}
+		if field.Embedded() && name == "" {
+			if embedded, ok := field.Type().Underlying().(*types.Struct); ok {
+				g.fields(embedded, properties, required)
+				continue
+			}
+		}
+		if name == "" {
+			name = field.Name()
+		}
+		properties[name] = g.schema(field.Type())
+		for _, rule := range strings.Split(tag.Get("binding"), ",") {
+			if rule == "required" {
+				*required = append(*required, name)
+			}
+		}
+	}
+}
+
+// routes 查找 xxxGroup := router.Group("/xxx") 和 xxxGroup.POST("/yyy", w.yyy) 形式的路由注册
+func routes(files []*ast.File) (result []route) {
+	groups := map[string]string{}
+	for _, file := range files {
+		ast.Inspect(file, func(node ast.Node) bool {
+			switch node := node.(type) {
+			case *ast.AssignStmt:
+				if len(node.Lhs) != 1 || len(node.Rhs) != 1 {
+					return true
+				}
+				call, ok := node.Rhs[0].(*ast.CallExpr)
+				if !ok || len(call.Args) != 1 {
+					return true
+				}
+				selector, ok := call.Fun.(*ast.SelectorExpr)
+				if !ok || selector.Sel.Name != "Group" {
+					return true
+				}
+				if ident, ok := node.Lhs[0].(*ast.Ident); ok {
+					groups[ident.Name] = stringLiteral(call.Args[0])
+				}
+			case *ast.CallExpr:
+				selector, ok := node.Fun.(*ast.SelectorExpr)
+				if !ok || len(node.Args) != 2 {
+					return true
+				}
+				group, ok := selector.X.(*ast.Ident)
+				if !ok {
+					return true
+				}
+				prefix, ok := groups[group.Name]
+				if !ok {
+					return true
+				}
+				handler, ok := node.Args[1].(*ast.SelectorExpr)
+				if !ok {
+					return true
+				}
+				result = append(result, route{
+					method:  strings.ToLower(selector.Sel.Name),
+					path:    prefix + stringLiteral(node.Args[0]),
+					handler: handler.Sel.Name,
+				})
+			}
+			return true
+		})
+	}
+	return
+}
+
+func stringLiteral(expr ast.Expr) string {
+	literal, ok := expr.(*ast.BasicLit)
+	if !ok || literal.Kind != token.STRING {
+		return ""
+	}
+	value, _ := strconv.Unquote(literal.Value)
+	return value
+}
+
+// handler 找到处理函数中名为 request 的变量和 render.Success 的参数
+func handler(files []*ast.File, info *types.Info, name string) (doc string, request, response types.Type) {
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			function, ok := decl.(*ast.FuncDecl)
+			if !ok || function.Recv == nil || function.Name.Name != name {
+				continue
+			}
+			if function.Doc != nil && !strings.HasPrefix(function.Doc.Text(), "TODO") {
+				doc = strings.TrimSpace(strings.TrimPrefix(function.Doc.Text(), name))
+			}
+			ast.Inspect(function.Body, func(node ast.Node) bool {
+				switch node := node.(type) {
+				case *ast.AssignStmt:
+					if ident, ok := node.Lhs[0].(*ast.Ident); ok && ident.Name == "request" && request == nil {
+						request = info.TypeOf(node.Rhs[0])
+					}
+				case *ast.CallExpr:
+					selector, ok := node.Fun.(*ast.SelectorExpr)
+					if !ok || selector.Sel.Name != "Success" || len(node.Args) != 2 {
+						return true
+					}
+					if pkg, ok := selector.X.(*ast.Ident); ok && pkg.Name == "render" {
+						response = info.TypeOf(node.Args[1])
+					}
+				}
+				return true
+			})
+			return
+		}
+	}
+	return
+}
+
+func operationID(urlPath string) string {
+	parts := strings.Split(strings.Trim(urlPath, "/"), "/")
+	for i := 1; i < len(parts); i++ {
+		parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+	}
+	return strings.Join(parts, "")
+}
+
+func main() {
+	flag.Parse()
+
+	fset := token.NewFileSet()
+	m := &moduleImporter{
+		fset:     fset,
+		std:      importer.ForCompiler(fset, "source", nil),
+		packages: map[string]*types.Package{},
+	}
+	g := &generator{
+		components: map[string]interface{}{},
+	}
+
+	paths := map[string]interface{}{}
+	tags := []interface{}{}
+	for _, module := range modules {
+		importPath := modulePath + "/internal/web/" + module
+		_, files, info, err := m.check(importPath, filepath.Join(moduleRoot, "internal/web", module))
+		if err != nil {
+			panic(err)
+		}
+
+		for _, r := range routes(files) {
+			doc, request, response := handler(files, info, r.handler)
+			tag := strings.Split(strings.Trim(r.path, "/"), "/")[0]
+
+			data := map[string]interface{}{}
+			if response != nil {
+				data = g.schema(response)
+			}
+			operation := map[string]interface{}{
+				"operationId": operationID(r.path),
+				"tags":        []string{tag},
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "status 为 0 时请求成功,其他值表示失败原因",
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{
+								"schema": map[string]interface{}{
+									"allOf": []interface{}{
+										map[string]interface{}{"$ref": "#/components/schemas/render.Response"},
+										map[string]interface{}{"type": "object", "properties": map[string]interface{}{"data": data}},
+									},
+								},
+							},
+						},
+					},
+				},
+			}
+			if doc != "" {
+				operation["summary"] = doc
+			}
+			if request != nil {
+				operation["requestBody"] = map[string]interface{}{
+					"required": true,
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{"schema": g.schema(request)},
+					},
+				}
+			}
+			if anonymous[r.path] {
+				operation["security"] = []interface{}{}
+			}
+			paths[r.path] = map[string]interface{}{r.method: operation}
+		}
+	}
+
+	seen := map[string]bool{}
+	names := []string{}
+	for urlPath := range paths {
+		tag := strings.Split(strings.Trim(urlPath, "/"), "/")[0]
+		if !seen[tag] {
+			seen[tag] = true
+			names = append(names, tag)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tags = append(tags, map[string]interface{}{"name": name})
+	}
+
+	g.components["render.Response"] = map[string]interface{}{
+		"type":     "object",
+		"required": []string{"status", "message"},
+		"properties": map[string]interface{}{
+			"status":  map[string]interface{}{"type": "integer"},
+			"message": map[string]interface{}{"type": "string"},
+			"code":    map[string]interface{}{"type": "string", "description": "v2 返回的英文错误码"},
+			"details": map[string]interface{}{"type": "object", "description": "v2 返回的错误详情"},
+		},
+	}
+
+	document := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "uranus-web",
+			"version": "v1",
+			"license": map[string]interface{}{"name": "AGPL-3.0-or-later"},
+		},
+		"servers": []interface{}{
+			map[string]interface{}{"url": "/api/v1", "description": "失败时 HTTP 状态码也是 200"},
+			map[string]interface{}{"url": "/api/v2", "description": "失败时返回对应的 HTTP 状态码、错误码和错误详情"},
+		},
+		"tags":  tags,
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.components,
+			"securitySchemes": map[string]interface{}{
+				"session": map[string]interface{}{"type": "apiKey", "in": "cookie", "name": "session"},
+				"csrf":    map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-CSRF-Token"},
+				"token":   map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"session": []string{}, "csrf": []string{}},
+			map[string]interface{}{"token": []string{}},
+		},
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(document); err != nil {
+		panic(err)
+	}
+}
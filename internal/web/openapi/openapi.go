@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package openapi
+
+//go:generate go run generate.go
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// openapi.json 由 generate.go 生成,修改路由、请求结构体或处理函数的注释后需要执行 go generate,
+// TestDocumentUpToDate 检查提交的文档是否过期
+//
+//go:embed openapi.json
+var document []byte
+
+// 不属于业务接口,不需要出现在文档中的路由
+var undocumentedPrefixes = []string{"/debug/pprof/", "/openapi.json"}
+
+func Init(router *gin.Engine) (err error) {
+	router.GET("/openapi.json", func(context *gin.Context) {
+		context.Data(http.StatusOK, "application/json; charset=UTF-8", document)
+	})
+	return
+}
+
+// Check 比较已注册的路由与文档,文档过期时输出警告
+func Check(routes gin.RoutesInfo) (err error) {
+	spec := struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}{}
+	if err = json.Unmarshal(document, &spec); err != nil {
+		return
+	}
+
+	registered := map[string]bool{}
+	for _, route := range routes {
+		if undocumented(route.Path) {
+			continue
+		}
+		registered[route.Method+" "+route.Path] = true
+		if _, ok := spec.Paths[route.Path][strings.ToLower(route.Method)]; !ok {
+			logrus.Warnf("route %s %s is not documented in openapi.json", route.Method, route.Path)
+		}
+	}
+
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			if !registered[strings.ToUpper(method)+" "+path] {
+				logrus.Warnf("openapi.json documents %s %s which is not registered", strings.ToUpper(method), path)
+			}
+		}
+	}
+	return
+}
+
+func undocumented(path string) bool {
+	for _, prefix := range undocumentedPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package openapi
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestDocumentUpToDate(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "openapi.json")
+	cmd := exec.Command("go", "run", "generate.go", "-output", output)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generate: %v\n%s", err, out)
+	}
+
+	generated, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(generated, document) {
+		t.Fatal("openapi.json is out of date, run go generate ./internal/web/openapi")
+	}
+}
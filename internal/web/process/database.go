@@ -2,13 +2,15 @@
 package process
 
 import (
+	"database/sql"
+
 	"github.com/sirupsen/logrus"
 )
 
 const (
 	sqlQueryProcessLimitOffset      = `select id,workdir,binary,argv,count,judge,status from process_event where id>? limit ?`
 	sqlUpdateProcessStatus          = `update process_event set status=? where id=?`
-	sqlQueryProcessCmdById          = `select workdir,binary,argv from process_event where id=?`
+	sqlQueryProcessCmdById          = `select workdir,binary,argv,status from process_event where id=?`
 	sqlQueryProcessPolicyCount      = `select count(*) from process_event`
 	sqlQueryProcessUnreadEventCount = `select count(*) from process_event where status=0`
 )
@@ -42,36 +44,30 @@ func (w *Worker) queryLimitOffset(limit, offset int) (events []Event, err error)
 	return
 }
 
-func (w *Worker) updateStatus(id int64, status int) bool {
-	stmt, err := w.db.Prepare(sqlUpdateProcessStatus)
+func (w *Worker) updateStatus(tx *sql.Tx, id int64, status int) (err error) {
+	stmt, err := tx.Prepare(sqlUpdateProcessStatus)
 	if err != nil {
 		logrus.Error(err)
-		return false
+		return
 	}
 	defer stmt.Close()
 
-	result, err := stmt.Exec(status, id)
-	if err != nil {
-		logrus.Error(err)
-		return false
-	}
-	affected, err := result.RowsAffected()
+	_, err = stmt.Exec(status, id)
 	if err != nil {
 		logrus.Error(err)
-		return false
 	}
-	return affected == 1
+	return
 }
 
-func (w *Worker) queryCmdById(id int) (workdir, binary, argv string, err error) {
-	stmt, err := w.db.Prepare(sqlQueryProcessCmdById)
+func (w *Worker) queryCmdById(tx *sql.Tx, id int) (workdir, binary, argv string, status int, err error) {
+	stmt, err := tx.Prepare(sqlQueryProcessCmdById)
 	if err != nil {
 		logrus.Error(err)
 		return
 	}
 	defer stmt.Close()
 
-	err = stmt.QueryRow(id).Scan(&workdir, &binary, &argv)
+	err = stmt.QueryRow(id).Scan(&workdir, &binary, &argv, &status)
 	return
 }
 
@@ -104,3 +100,19 @@ func (w *Worker) queryProcessUnreadEventCount() (count int, err error) {
 	}
 	return
 }
+
+// QueryEventLimitOffset 和下面的查询不经过 uranus-web 直接读取数据库,供 uranus-ctl 本地模式使用
+func QueryEventLimitOffset(db *sql.DB, limit, offset int) (events []Event, err error) {
+	w := &Worker{db: db}
+	return w.queryLimitOffset(limit, offset)
+}
+
+func QueryPolicyCount(db *sql.DB) (count int, err error) {
+	w := &Worker{db: db}
+	return w.queryProcessPolicyCount()
+}
+
+func QueryUnreadEventCount(db *sql.DB) (count int, err error) {
+	w := &Worker{db: db}
+	return w.queryProcessUnreadEventCount()
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lanthora/uranus/internal/apply"
 	"github.com/lanthora/uranus/internal/config"
 	"github.com/lanthora/uranus/internal/web/render"
 	"github.com/lanthora/uranus/internal/web/user"
@@ -127,7 +128,7 @@ func (w *Worker) updateWorkMode(context *gin.Context) {
 	}{}
 
 	if err := context.ShouldBindJSON(&request); err != nil {
-		render.Status(context, render.StatusInvalidArgument)
+		render.InvalidArgument(context, err)
 		return
 	}
 
@@ -155,7 +156,7 @@ func (w *Worker) listEvents(context *gin.Context) {
 	}{}
 
 	if err := context.ShouldBindJSON(&request); err != nil {
-		render.Status(context, render.StatusInvalidArgument)
+		render.InvalidArgument(context, err)
 		return
 	}
 
@@ -167,6 +168,28 @@ func (w *Worker) listEvents(context *gin.Context) {
 	render.Success(context, events)
 }
 
+// UpdateEventStatus 更新进程事件的状态,可信的命令同时添加到 hackernel
+func UpdateEventStatus(db *sql.DB, id int, status int, push bool) (err error) {
+	w := &Worker{db: db}
+	workdir, binary, argv, old := "", "", "", process.StatusPending
+	return apply.Run(w.db, apply.Change{
+		Write: func(tx *sql.Tx) (err error) {
+			workdir, binary, argv, old, err = w.queryCmdById(tx, id)
+			return
+		},
+		Push: func() error {
+			return apply.PushError(setCmdStatus(workdir, binary, argv, status))
+		},
+		Commit: func(tx *sql.Tx) error {
+			return w.updateStatus(tx, int64(id), status)
+		},
+		Revert: func() {
+			setCmdStatus(workdir, binary, argv, old)
+		},
+		Offline: !push,
+	})
+}
+
 func (w *Worker) updateEventStatus(context *gin.Context) {
 	request := struct {
 		ID     int `json:"id" binding:"number"`
@@ -174,34 +197,28 @@ func (w *Worker) updateEventStatus(context *gin.Context) {
 	}{}
 
 	if err := context.ShouldBindJSON(&request); err != nil {
-		render.Status(context, render.StatusInvalidArgument)
+		render.InvalidArgument(context, err)
 		return
 	}
 
-	workdir, binary, argv, err := w.queryCmdById(request.ID)
-	if err != nil {
+	err := UpdateEventStatus(w.db, request.ID, request.Status, true)
+	if err == sql.ErrNoRows {
 		render.Status(context, render.StatusInvalidArgument)
 		return
 	}
-
-	switch request.Status {
-	case process.StatusTrusted:
-		err = process.SetTrustedCmd(workdir, binary, argv)
-	default:
-		err = process.SetUntrustedCmd(workdir, binary, argv)
-	}
-
 	if err != nil {
 		render.Status(context, render.StatusProcessUpdatePolicyFailed)
 		return
 	}
+	render.Status(context, render.StatusSuccess)
+}
 
-	ok := w.updateStatus(int64(request.ID), request.Status)
-	if !ok {
-		render.Status(context, render.StatusProcessUpdatePolicyFailed)
-		return
+// setCmdStatus 只有可信的命令需要添加到 hackernel,其他状态都从可信列表中删除
+func setCmdStatus(workdir, binary, argv string, status int) error {
+	if status == process.StatusTrusted {
+		return process.SetTrustedCmd(workdir, binary, argv)
 	}
-	render.Status(context, render.StatusSuccess)
+	return process.SetUntrustedCmd(workdir, binary, argv)
 }
 
 // TODO: 补充进程事件删除功能
@@ -214,7 +231,7 @@ func (w *Worker) updateDefaultEventStatus(context *gin.Context) {
 		Status int `json:"status" binding:"number"`
 	}{}
 	if err := context.ShouldBindJSON(&request); err != nil {
-		render.Status(context, render.StatusInvalidArgument)
+		render.InvalidArgument(context, err)
 		return
 	}
 	if err := w.config.SetInteger(config.ProcessCmdDefaultStatus, request.Status); err != nil {
@@ -0,0 +1,198 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package render
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// 从 v2 开始响应使用 HTTP 状态码表示失败,并带有稳定的英文错误码和错误详情
+const (
+	Version1 = 1
+	Version2 = 2
+)
+
+const detailsKey = "render.details"
+
+type versionKey struct{}
+
+// codes 是对外公开的错误码,客户端应该依赖错误码而不是提示信息,已有的错误码不能修改
+var codes = map[int]string{
+	StatusSuccess:                       "SUCCESS",
+	StatusUnknownError:                  "UNKNOWN_ERROR",
+	StatusInvalidArgument:               "INVALID_ARGUMENT",
+	StatusUserNotLoggedIn:               "USER_NOT_LOGGED_IN",
+	StatusUserPermissionDenied:          "USER_PERMISSION_DENIED",
+	StatusUserLoginFaild:                "USER_LOGIN_FAILED",
+	StatusUserCreateUserFailed:          "USER_CREATE_USER_FAILED",
+	StatusUserQueryUserFailed:           "USER_QUERY_USER_FAILED",
+	StatusUserUpdateUserFailed:          "USER_UPDATE_USER_FAILED",
+	StatusUserDeleteUserFailed:          "USER_DELETE_USER_FAILED",
+	StatusUserQuerySessionFailed:        "USER_QUERY_SESSION_FAILED",
+	StatusUserDeleteSessionFailed:       "USER_DELETE_SESSION_FAILED",
+	StatusUserRoleNotExist:              "USER_ROLE_NOT_EXIST",
+	StatusUserCreateRoleFailed:          "USER_CREATE_ROLE_FAILED",
+	StatusUserQueryRoleFailed:           "USER_QUERY_ROLE_FAILED",
+	StatusUserUpdateRoleFailed:          "USER_UPDATE_ROLE_FAILED",
+	StatusUserDeleteRoleFailed:          "USER_DELETE_ROLE_FAILED",
+	StatusUserCreateTokenFailed:         "USER_CREATE_TOKEN_FAILED",
+	StatusUserQueryTokenFailed:          "USER_QUERY_TOKEN_FAILED",
+	StatusUserDeleteTokenFailed:         "USER_DELETE_TOKEN_FAILED",
+	StatusUserLoginLimited:              "USER_LOGIN_LIMITED",
+	StatusUserSetupFailed:               "USER_SETUP_FAILED",
+	StatusUserQueryLoginFailureFailed:   "USER_QUERY_LOGIN_FAILURE_FAILED",
+	StatusUserTOTPRequired:              "USER_TOTP_REQUIRED",
+	StatusUserTOTPInvalid:               "USER_TOTP_INVALID",
+	StatusUserTOTPNotEnrolled:           "USER_TOTP_NOT_ENROLLED",
+	StatusUserTOTPAlreadyEnabled:        "USER_TOTP_ALREADY_ENABLED",
+	StatusUserUpdateTOTPFailed:          "USER_UPDATE_TOTP_FAILED",
+	StatusUserWrongPassword:             "USER_WRONG_PASSWORD",
+	StatusUserCSRFTokenInvalid:          "USER_CSRF_TOKEN_INVALID",
+	StatusProcessEnableFailed:           "PROCESS_ENABLE_FAILED",
+	StatusProcessDisableFailed:          "PROCESS_DISABLE_FAILED",
+	StatusProcessUpdateJudgeFailed:      "PROCESS_UPDATE_JUDGE_FAILED",
+	StatusProcessUpdatePolicyFailed:     "PROCESS_UPDATE_POLICY_FAILED",
+	StatusProcessQueryEventFailed:       "PROCESS_QUERY_EVENT_FAILED",
+	StatusProcessTrustUpdateFailed:      "PROCESS_TRUST_UPDATE_FAILED",
+	StatusProcessGetTrustStatusFailed:   "PROCESS_GET_TRUST_STATUS_FAILED",
+	StatusFileEnableFailed:              "FILE_ENABLE_FAILED",
+	StatusFileDisableFailed:             "FILE_DISABLE_FAILED",
+	StatusFileAddPolicyConflict:         "FILE_ADD_POLICY_CONFLICT",
+	StatusFileAddPolicyFileNotExist:     "FILE_ADD_POLICY_FILE_NOT_EXIST",
+	StatusFileAddPolicyFailed:           "FILE_ADD_POLICY_FAILED",
+	StatusFileDeletePolicyFailed:        "FILE_DELETE_POLICY_FAILED",
+	StatusFileQueryPolicyListFailed:     "FILE_QUERY_POLICY_LIST_FAILED",
+	StatusFileQueryPolicyByIdFailed:     "FILE_QUERY_POLICY_BY_ID_FAILED",
+	StatusFileQueryEventListFailed:      "FILE_QUERY_EVENT_LIST_FAILED",
+	StatusFileDeleteEventFailed:         "FILE_DELETE_EVENT_FAILED",
+	StatusFileUpdatePolicyConflict:      "FILE_UPDATE_POLICY_CONFLICT",
+	StatusFileUpdatePolicyFileNotExist:  "FILE_UPDATE_POLICY_FILE_NOT_EXIST",
+	StatusFileUpdatePolicyFailed:        "FILE_UPDATE_POLICY_FAILED",
+	StatusFileUpdateEventStatusFailed:   "FILE_UPDATE_EVENT_STATUS_FAILED",
+	StatusFileQueryEventFailed:          "FILE_QUERY_EVENT_FAILED",
+	StatusFileAddRuleFailed:             "FILE_ADD_RULE_FAILED",
+	StatusFileAddRuleTooManyFiles:       "FILE_ADD_RULE_TOO_MANY_FILES",
+	StatusFileDeleteRuleFailed:          "FILE_DELETE_RULE_FAILED",
+	StatusFileQueryRuleListFailed:       "FILE_QUERY_RULE_LIST_FAILED",
+	StatusFilePolicyOwnedByRule:         "FILE_POLICY_OWNED_BY_RULE",
+	StatusNetEnableFailed:               "NET_ENABLE_FAILED",
+	StatusNetDisableFailed:              "NET_DISABLE_FAILED",
+	StatusNetAddPolicyFailed:            "NET_ADD_POLICY_FAILED",
+	StatusNetAddPolicyDatabaseFailed:    "NET_ADD_POLICY_DATABASE_FAILED",
+	StatusNetDeletePolicyFailed:         "NET_DELETE_POLICY_FAILED",
+	StatusNetDeletePolicyDatabaseFailed: "NET_DELETE_POLICY_DATABASE_FAILED",
+	StatusNetQueryPolicyListFailed:      "NET_QUERY_POLICY_LIST_FAILED",
+	StatusNetPolicyNotExist:             "NET_POLICY_NOT_EXIST",
+	StatusNetQueryEventFailed:           "NET_QUERY_EVENT_FAILED",
+	StatusNetDeleteEventFailed:          "NET_DELETE_EVENT_FAILED",
+	StatusNetUpdateEventStatusFailed:    "NET_UPDATE_EVENT_STATUS_FAILED",
+	StatusNetUpdatePolicyFailed:         "NET_UPDATE_POLICY_FAILED",
+	StatusNetUpdatePolicyDatabaseFailed: "NET_UPDATE_POLICY_DATABASE_FAILED",
+	StatusAuditQueryLogFailed:           "AUDIT_QUERY_LOG_FAILED",
+	StatusDriftQueryFailed:              "DRIFT_QUERY_FAILED",
+}
+
+// 没有列出的失败状态对应 500
+var httpStatuses = map[int]int{
+	StatusSuccess:                      http.StatusOK,
+	StatusInvalidArgument:              http.StatusBadRequest,
+	StatusUserNotLoggedIn:              http.StatusUnauthorized,
+	StatusUserPermissionDenied:         http.StatusForbidden,
+	StatusUserLoginFaild:               http.StatusUnauthorized,
+	StatusUserRoleNotExist:             http.StatusNotFound,
+	StatusUserLoginLimited:             http.StatusTooManyRequests,
+	StatusUserSetupFailed:              http.StatusForbidden,
+	StatusUserTOTPRequired:             http.StatusUnauthorized,
+	StatusUserTOTPInvalid:              http.StatusUnauthorized,
+	StatusUserTOTPNotEnrolled:          http.StatusForbidden,
+	StatusUserTOTPAlreadyEnabled:       http.StatusConflict,
+	StatusUserWrongPassword:            http.StatusForbidden,
+	StatusUserCSRFTokenInvalid:         http.StatusForbidden,
+	StatusFileAddPolicyConflict:        http.StatusConflict,
+	StatusFileAddPolicyFileNotExist:    http.StatusNotFound,
+	StatusFileUpdatePolicyConflict:     http.StatusConflict,
+	StatusFileUpdatePolicyFileNotExist: http.StatusNotFound,
+	StatusFileAddRuleTooManyFiles:      http.StatusBadRequest,
+	StatusFilePolicyOwnedByRule:        http.StatusConflict,
+	StatusNetPolicyNotExist:            http.StatusNotFound,
+}
+
+// 参数校验失败时用 json 标签中的字段名描述出错的字段
+func init() {
+	if validate, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+	}
+}
+
+// WithVersion 记录请求使用的接口版本,未记录版本的请求按照 v1 响应
+func WithVersion(request *http.Request, version int) *http.Request {
+	return request.WithContext(context.WithValue(request.Context(), versionKey{}, version))
+}
+
+func version(context *gin.Context) int {
+	if version, ok := context.Request.Context().Value(versionKey{}).(int); ok {
+		return version
+	}
+	return Version1
+}
+
+func Code(status int) string {
+	if code, ok := codes[status]; ok {
+		return code
+	}
+	return codes[StatusUnknownError]
+}
+
+func HTTPStatus(status int) int {
+	if httpStatus, ok := httpStatuses[status]; ok {
+		return httpStatus
+	}
+	return http.StatusInternalServerError
+}
+
+// Detail 为失败的响应补充详细信息,只在 v2 中返回
+func Detail(context *gin.Context, key string, value interface{}) {
+	details := context.GetStringMap(detailsKey)
+	if details == nil {
+		details = map[string]interface{}{}
+		context.Set(detailsKey, details)
+	}
+	details[key] = value
+}
+
+// InvalidArgument 响应参数错误,并在详细信息中说明哪些字段校验失败
+func InvalidArgument(context *gin.Context, err error) {
+	validationErrors := validator.ValidationErrors{}
+	typeError := &json.UnmarshalTypeError{}
+
+	switch {
+	case errors.As(err, &validationErrors):
+		fields := []map[string]string{}
+		for _, fieldError := range validationErrors {
+			fields = append(fields, map[string]string{
+				"field": fieldError.Namespace()[strings.Index(fieldError.Namespace(), ".")+1:],
+				"rule":  fieldError.Tag(),
+			})
+		}
+		Detail(context, "fields", fields)
+	case errors.As(err, &typeError):
+		Detail(context, "fields", []map[string]string{{"field": typeError.Field, "rule": typeError.Type.String()}})
+	case err != nil:
+		Detail(context, "error", err.Error())
+	}
+	Status(context, StatusInvalidArgument)
+}
@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package render
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHTTPStatus(t *testing.T) {
+	tests := []struct {
+		status     int
+		httpStatus int
+		code       string
+	}{
+		{StatusSuccess, http.StatusOK, "SUCCESS"},
+		{StatusUnknownError, http.StatusInternalServerError, "UNKNOWN_ERROR"},
+		{StatusInvalidArgument, http.StatusBadRequest, "INVALID_ARGUMENT"},
+		{StatusUserNotLoggedIn, http.StatusUnauthorized, "USER_NOT_LOGGED_IN"},
+		{StatusUserPermissionDenied, http.StatusForbidden, "USER_PERMISSION_DENIED"},
+		{StatusUserLoginLimited, http.StatusTooManyRequests, "USER_LOGIN_LIMITED"},
+		{StatusUserTOTPAlreadyEnabled, http.StatusConflict, "USER_TOTP_ALREADY_ENABLED"},
+		{StatusUserCSRFTokenInvalid, http.StatusForbidden, "USER_CSRF_TOKEN_INVALID"},
+		{StatusNetUpdatePolicyFailed, http.StatusInternalServerError, "NET_UPDATE_POLICY_FAILED"},
+		{StatusDriftQueryFailed, http.StatusInternalServerError, "DRIFT_QUERY_FAILED"},
+		{StatusFileAddPolicyConflict, http.StatusConflict, "FILE_ADD_POLICY_CONFLICT"},
+		{StatusNetPolicyNotExist, http.StatusNotFound, "NET_POLICY_NOT_EXIST"},
+		// 没有错误码的状态按未知错误处理
+		{-1, http.StatusInternalServerError, "UNKNOWN_ERROR"},
+	}
+	for _, test := range tests {
+		if got := HTTPStatus(test.status); got != test.httpStatus {
+			t.Errorf("HTTPStatus(%d) = %d, want %d", test.status, got, test.httpStatus)
+		}
+		if got := Code(test.status); got != test.code {
+			t.Errorf("Code(%d) = %s, want %s", test.status, got, test.code)
+		}
+	}
+}
+
+func TestCodesUnique(t *testing.T) {
+	seen := map[string]int{}
+	for status, code := range codes {
+		if other, ok := seen[code]; ok {
+			t.Errorf("code %s is used by both %d and %d", code, status, other)
+		}
+		seen[code] = status
+	}
+}
+
+type response struct {
+	Status  int                    `json:"status"`
+	Code    string                 `json:"code"`
+	Details map[string]interface{} `json:"details"`
+}
+
+func TestStatusVersion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name       string
+		version    int
+		status     int
+		detail     bool
+		httpStatus int
+		code       string
+	}{
+		{"v1 success", Version1, StatusSuccess, false, http.StatusOK, ""},
+		{"v1 failure", Version1, StatusUserNotLoggedIn, true, http.StatusOK, ""},
+		{"v2 success", Version2, StatusSuccess, false, http.StatusOK, "SUCCESS"},
+		{"v2 failure", Version2, StatusUserNotLoggedIn, true, http.StatusUnauthorized, "USER_NOT_LOGGED_IN"},
+		{"v2 unknown failure", Version2, StatusUnknownError, false, http.StatusInternalServerError, "UNKNOWN_ERROR"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			context, _ := gin.CreateTestContext(recorder)
+			context.Request = WithVersion(httptest.NewRequest(http.MethodPost, "/", nil), test.version)
+			if test.detail {
+				Detail(context, "reason", "expired")
+			}
+			Status(context, test.status)
+
+			if recorder.Code != test.httpStatus {
+				t.Errorf("http status = %d, want %d", recorder.Code, test.httpStatus)
+			}
+			got := response{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != test.status || got.Code != test.code {
+				t.Errorf("status = %d, code = %q, want %d, %q", got.Status, got.Code, test.status, test.code)
+			}
+			// 详细信息只在 v2 中返回
+			if hasDetail := got.Details["reason"] == "expired"; hasDetail != (test.detail && test.version >= Version2) {
+				t.Errorf("details = %v", got.Details)
+			}
+		})
+	}
+}
+
+func TestInvalidArgument(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name string
+		err  error
+		key  string
+	}{
+		{"type error", &json.UnmarshalTypeError{Field: "limit", Type: reflect.TypeOf(0)}, "fields"},
+		{"other error", errors.New("bad request"), "error"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			context, _ := gin.CreateTestContext(recorder)
+			context.Request = WithVersion(httptest.NewRequest(http.MethodPost, "/", nil), Version2)
+			InvalidArgument(context, test.err)
+
+			if recorder.Code != http.StatusBadRequest {
+				t.Errorf("http status = %d, want %d", recorder.Code, http.StatusBadRequest)
+			}
+			got := response{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Code != "INVALID_ARGUMENT" || got.Details[test.key] == nil {
+				t.Errorf("code = %q, details = %v", got.Code, got.Details)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lanthora/uranus/internal/i18n"
 )
 
 const (
@@ -21,6 +22,26 @@ const (
 	StatusUserQueryUserFailed
 	StatusUserUpdateUserFailed
 	StatusUserDeleteUserFailed
+	StatusUserQuerySessionFailed
+	StatusUserDeleteSessionFailed
+	StatusUserRoleNotExist
+	StatusUserCreateRoleFailed
+	StatusUserQueryRoleFailed
+	StatusUserUpdateRoleFailed
+	StatusUserDeleteRoleFailed
+	StatusUserCreateTokenFailed
+	StatusUserQueryTokenFailed
+	StatusUserDeleteTokenFailed
+	StatusUserLoginLimited
+	StatusUserSetupFailed
+	StatusUserQueryLoginFailureFailed
+	StatusUserTOTPRequired
+	StatusUserTOTPInvalid
+	StatusUserTOTPNotEnrolled
+	StatusUserTOTPAlreadyEnabled
+	StatusUserUpdateTOTPFailed
+	StatusUserWrongPassword
+	StatusUserCSRFTokenInvalid
 )
 
 const (
@@ -49,6 +70,11 @@ const (
 	StatusFileUpdatePolicyFailed
 	StatusFileUpdateEventStatusFailed
 	StatusFileQueryEventFailed
+	StatusFileAddRuleFailed
+	StatusFileAddRuleTooManyFiles
+	StatusFileDeleteRuleFailed
+	StatusFileQueryRuleListFailed
+	StatusFilePolicyOwnedByRule
 )
 
 const (
@@ -63,74 +89,244 @@ const (
 	StatusNetQueryEventFailed
 	StatusNetDeleteEventFailed
 	StatusNetUpdateEventStatusFailed
+	StatusNetUpdatePolicyFailed
+	StatusNetUpdatePolicyDatabaseFailed
 )
 
-var messages = map[int]string{
-	StatusSuccess:                       "成功",
-	StatusUnknownError:                  "未知错误",
-	StatusInvalidArgument:               "无效参数",
-	StatusUserNotLoggedIn:               "未登录",
-	StatusUserPermissionDenied:          "无权限",
-	StatusUserLoginFaild:                "登录失败",
-	StatusUserCreateUserFailed:          "创建用户失败",
-	StatusUserQueryUserFailed:           "查询用户失败",
-	StatusUserUpdateUserFailed:          "更新用户失败",
-	StatusUserDeleteUserFailed:          "删除用户失败",
-	StatusProcessEnableFailed:           "启动进程防护模块失败",
-	StatusProcessDisableFailed:          "关闭进程防护模块失败",
-	StatusProcessUpdateJudgeFailed:      "更新进程防护模式失败",
-	StatusProcessUpdatePolicyFailed:     "更新进程策略失败",
-	StatusProcessQueryEventFailed:       "查询进程事件失败",
-	StatusProcessTrustUpdateFailed:      "更新进程默认信任状态失败",
-	StatusProcessGetTrustStatusFailed:   "获取进程默认信任状态失败",
-	StatusFileEnableFailed:              "启动文件防护模块失败",
-	StatusFileDisableFailed:             "关闭文件防护模块失败",
-	StatusFileAddPolicyConflict:         "添加文件策略冲突",
-	StatusFileAddPolicyFileNotExist:     "添加文件策略文件不存在",
-	StatusFileAddPolicyFailed:           "添加文件策略失败",
-	StatusFileDeletePolicyFailed:        "删除文件策略失败",
-	StatusFileQueryPolicyListFailed:     "查询文件策略列表失败",
-	StatusFileQueryPolicyByIdFailed:     "查询文件策略失败",
-	StatusFileQueryEventListFailed:      "查询文件事件列表失败",
-	StatusFileDeleteEventFailed:         "删除文件事件失败",
-	StatusFileUpdatePolicyConflict:      "更新文件策略冲突",
-	StatusFileUpdatePolicyFileNotExist:  "更新文件策略文件不存在",
-	StatusFileUpdatePolicyFailed:        "更新文件策略失败",
-	StatusFileUpdateEventStatusFailed:   "更新文件事件状态失败",
-	StatusFileQueryEventFailed:          "查询文件事件失败",
-	StatusNetEnableFailed:               "启动网络防护模块失败",
-	StatusNetDisableFailed:              "关闭网络防护模块失败",
-	StatusNetAddPolicyFailed:            "添加网络策略失败",
-	StatusNetAddPolicyDatabaseFailed:    "添加网络策略数据库失败",
-	StatusNetDeletePolicyFailed:         "删除网络策略失败",
-	StatusNetDeletePolicyDatabaseFailed: "删除网络策略数据库失败",
-	StatusNetQueryPolicyListFailed:      "查询网络策略列表失败",
-	StatusNetPolicyNotExist:             "网络策略不存在",
-	StatusNetQueryEventFailed:           "查询网络事件失败",
-	StatusNetDeleteEventFailed:          "网络事件删除失败",
-	StatusNetUpdateEventStatusFailed:    "更新网络事件状态失败",
+const (
+	StatusAuditQueryLogFailed = iota + 500
+)
+
+const (
+	StatusDriftQueryFailed = iota + 600
+)
+
+// StatusKey 是响应状态码在 gin.Context 中的键,供中间件在请求处理后读取
+const StatusKey = "render.status"
+
+// messages 按语言保存状态码对应的提示信息
+var messages = map[string]map[int]string{
+	i18n.ZhCN: {
+		StatusSuccess:                       "成功",
+		StatusUnknownError:                  "未知错误",
+		StatusInvalidArgument:               "无效参数",
+		StatusUserNotLoggedIn:               "未登录",
+		StatusUserPermissionDenied:          "无权限",
+		StatusUserLoginFaild:                "登录失败",
+		StatusUserCreateUserFailed:          "创建用户失败",
+		StatusUserQueryUserFailed:           "查询用户失败",
+		StatusUserUpdateUserFailed:          "更新用户失败",
+		StatusUserDeleteUserFailed:          "删除用户失败",
+		StatusUserQuerySessionFailed:        "查询用户会话失败",
+		StatusUserDeleteSessionFailed:       "删除用户会话失败",
+		StatusUserRoleNotExist:              "角色不存在",
+		StatusUserCreateRoleFailed:          "创建角色失败",
+		StatusUserQueryRoleFailed:           "查询角色失败",
+		StatusUserUpdateRoleFailed:          "更新角色失败",
+		StatusUserDeleteRoleFailed:          "删除角色失败",
+		StatusUserCreateTokenFailed:         "创建令牌失败",
+		StatusUserQueryTokenFailed:          "查询令牌失败",
+		StatusUserDeleteTokenFailed:         "删除令牌失败",
+		StatusUserLoginLimited:              "登录失败次数过多,请稍后再试",
+		StatusUserSetupFailed:               "初始化管理员失败",
+		StatusUserQueryLoginFailureFailed:   "查询登录失败记录失败",
+		StatusUserTOTPRequired:              "需要动态口令",
+		StatusUserTOTPInvalid:               "动态口令错误",
+		StatusUserTOTPNotEnrolled:           "请先绑定动态口令",
+		StatusUserTOTPAlreadyEnabled:        "动态口令已启用",
+		StatusUserUpdateTOTPFailed:          "更新动态口令失败",
+		StatusUserWrongPassword:             "密码错误",
+		StatusUserCSRFTokenInvalid:          "CSRF 校验失败",
+		StatusProcessEnableFailed:           "启动进程防护模块失败",
+		StatusProcessDisableFailed:          "关闭进程防护模块失败",
+		StatusProcessUpdateJudgeFailed:      "更新进程防护模式失败",
+		StatusProcessUpdatePolicyFailed:     "更新进程策略失败",
+		StatusProcessQueryEventFailed:       "查询进程事件失败",
+		StatusProcessTrustUpdateFailed:      "更新进程默认信任状态失败",
+		StatusProcessGetTrustStatusFailed:   "获取进程默认信任状态失败",
+		StatusFileEnableFailed:              "启动文件防护模块失败",
+		StatusFileDisableFailed:             "关闭文件防护模块失败",
+		StatusFileAddPolicyConflict:         "添加文件策略冲突",
+		StatusFileAddPolicyFileNotExist:     "添加文件策略文件不存在",
+		StatusFileAddPolicyFailed:           "添加文件策略失败",
+		StatusFileDeletePolicyFailed:        "删除文件策略失败",
+		StatusFileQueryPolicyListFailed:     "查询文件策略列表失败",
+		StatusFileQueryPolicyByIdFailed:     "查询文件策略失败",
+		StatusFileQueryEventListFailed:      "查询文件事件列表失败",
+		StatusFileDeleteEventFailed:         "删除文件事件失败",
+		StatusFileUpdatePolicyConflict:      "更新文件策略冲突",
+		StatusFileUpdatePolicyFileNotExist:  "更新文件策略文件不存在",
+		StatusFileUpdatePolicyFailed:        "更新文件策略失败",
+		StatusFileUpdateEventStatusFailed:   "更新文件事件状态失败",
+		StatusFileQueryEventFailed:          "查询文件事件失败",
+		StatusFileAddRuleFailed:             "添加文件规则失败",
+		StatusFileAddRuleTooManyFiles:       "文件规则匹配的文件过多",
+		StatusFileDeleteRuleFailed:          "删除文件规则失败",
+		StatusFileQueryRuleListFailed:       "查询文件规则列表失败",
+		StatusFilePolicyOwnedByRule:         "文件策略属于规则,只能通过规则管理",
+		StatusNetEnableFailed:               "启动网络防护模块失败",
+		StatusNetDisableFailed:              "关闭网络防护模块失败",
+		StatusNetAddPolicyFailed:            "添加网络策略失败",
+		StatusNetAddPolicyDatabaseFailed:    "添加网络策略数据库失败",
+		StatusNetDeletePolicyFailed:         "删除网络策略失败",
+		StatusNetDeletePolicyDatabaseFailed: "删除网络策略数据库失败",
+		StatusNetQueryPolicyListFailed:      "查询网络策略列表失败",
+		StatusNetPolicyNotExist:             "网络策略不存在",
+		StatusNetQueryEventFailed:           "查询网络事件失败",
+		StatusNetDeleteEventFailed:          "网络事件删除失败",
+		StatusNetUpdateEventStatusFailed:    "更新网络事件状态失败",
+		StatusNetUpdatePolicyFailed:         "更新网络策略失败",
+		StatusNetUpdatePolicyDatabaseFailed: "更新网络策略数据库失败",
+		StatusAuditQueryLogFailed:           "查询审计日志失败",
+		StatusDriftQueryFailed:              "查询策略核对结果失败",
+	},
+	i18n.EnUS: {
+		StatusSuccess:                       "Success",
+		StatusUnknownError:                  "Unknown error",
+		StatusInvalidArgument:               "Invalid argument",
+		StatusUserNotLoggedIn:               "Not logged in",
+		StatusUserPermissionDenied:          "Permission denied",
+		StatusUserLoginFaild:                "Login failed",
+		StatusUserCreateUserFailed:          "Failed to create user",
+		StatusUserQueryUserFailed:           "Failed to query users",
+		StatusUserUpdateUserFailed:          "Failed to update user",
+		StatusUserDeleteUserFailed:          "Failed to delete user",
+		StatusUserQuerySessionFailed:        "Failed to query user sessions",
+		StatusUserDeleteSessionFailed:       "Failed to delete user session",
+		StatusUserRoleNotExist:              "Role does not exist",
+		StatusUserCreateRoleFailed:          "Failed to create role",
+		StatusUserQueryRoleFailed:           "Failed to query roles",
+		StatusUserUpdateRoleFailed:          "Failed to update role",
+		StatusUserDeleteRoleFailed:          "Failed to delete role",
+		StatusUserCreateTokenFailed:         "Failed to create token",
+		StatusUserQueryTokenFailed:          "Failed to query tokens",
+		StatusUserDeleteTokenFailed:         "Failed to delete token",
+		StatusUserLoginLimited:              "Too many failed login attempts, please try again later",
+		StatusUserSetupFailed:               "Failed to set up administrator",
+		StatusUserQueryLoginFailureFailed:   "Failed to query login failures",
+		StatusUserTOTPRequired:              "One-time password required",
+		StatusUserTOTPInvalid:               "Invalid one-time password",
+		StatusUserTOTPNotEnrolled:           "Please enroll a one-time password first",
+		StatusUserTOTPAlreadyEnabled:        "One-time password already enabled",
+		StatusUserUpdateTOTPFailed:          "Failed to update one-time password",
+		StatusUserWrongPassword:             "Wrong password",
+		StatusUserCSRFTokenInvalid:          "CSRF check failed",
+		StatusProcessEnableFailed:           "Failed to enable process protection",
+		StatusProcessDisableFailed:          "Failed to disable process protection",
+		StatusProcessUpdateJudgeFailed:      "Failed to update process protection mode",
+		StatusProcessUpdatePolicyFailed:     "Failed to update process policy",
+		StatusProcessQueryEventFailed:       "Failed to query process events",
+		StatusProcessTrustUpdateFailed:      "Failed to update default process trust status",
+		StatusProcessGetTrustStatusFailed:   "Failed to get default process trust status",
+		StatusFileEnableFailed:              "Failed to enable file protection",
+		StatusFileDisableFailed:             "Failed to disable file protection",
+		StatusFileAddPolicyConflict:         "File policy conflict",
+		StatusFileAddPolicyFileNotExist:     "File for the policy does not exist",
+		StatusFileAddPolicyFailed:           "Failed to add file policy",
+		StatusFileDeletePolicyFailed:        "Failed to delete file policy",
+		StatusFileQueryPolicyListFailed:     "Failed to query file policies",
+		StatusFileQueryPolicyByIdFailed:     "Failed to query file policy",
+		StatusFileQueryEventListFailed:      "Failed to query file events",
+		StatusFileDeleteEventFailed:         "Failed to delete file event",
+		StatusFileUpdatePolicyConflict:      "File policy update conflict",
+		StatusFileUpdatePolicyFileNotExist:  "File for the policy update does not exist",
+		StatusFileUpdatePolicyFailed:        "Failed to update file policy",
+		StatusFileUpdateEventStatusFailed:   "Failed to update file event status",
+		StatusFileQueryEventFailed:          "Failed to query file event",
+		StatusFileAddRuleFailed:             "Failed to add file rule",
+		StatusFileAddRuleTooManyFiles:       "File rule matches too many files",
+		StatusFileDeleteRuleFailed:          "Failed to delete file rule",
+		StatusFileQueryRuleListFailed:       "Failed to query file rules",
+		StatusFilePolicyOwnedByRule:         "File policy belongs to a rule and is managed with it",
+		StatusNetEnableFailed:               "Failed to enable network protection",
+		StatusNetDisableFailed:              "Failed to disable network protection",
+		StatusNetAddPolicyFailed:            "Failed to add network policy",
+		StatusNetAddPolicyDatabaseFailed:    "Failed to save network policy",
+		StatusNetDeletePolicyFailed:         "Failed to delete network policy",
+		StatusNetDeletePolicyDatabaseFailed: "Failed to delete saved network policy",
+		StatusNetQueryPolicyListFailed:      "Failed to query network policies",
+		StatusNetPolicyNotExist:             "Network policy does not exist",
+		StatusNetQueryEventFailed:           "Failed to query network events",
+		StatusNetDeleteEventFailed:          "Failed to delete network event",
+		StatusNetUpdateEventStatusFailed:    "Failed to update network event status",
+		StatusNetUpdatePolicyFailed:         "Failed to update network policy",
+		StatusNetUpdatePolicyDatabaseFailed: "Failed to update saved network policy",
+		StatusAuditQueryLogFailed:           "Failed to query audit log",
+		StatusDriftQueryFailed:              "Failed to query policy drift",
+	},
+}
+
+// LanguageKey 是用户设置的语言在 gin.Context 中的键,没有设置时根据 Accept-Language 选择
+const LanguageKey = "render.language"
+
+func language(context *gin.Context) string {
+	if language := context.GetString(LanguageKey); language != "" {
+		return language
+	}
+	return i18n.Match(context.GetHeader("Accept-Language"))
+}
+
+func message(context *gin.Context, status int) string {
+	if message, ok := messages[language(context)][status]; ok {
+		return message
+	}
+	return messages[i18n.Default][status]
 }
 
 func Success(context *gin.Context, data interface{}) {
+	context.Set(StatusKey, StatusSuccess)
+	if version(context) >= Version2 {
+		response := struct {
+			Status  int         `json:"status"`
+			Code    string      `json:"code"`
+			Message string      `json:"message"`
+			Data    interface{} `json:"data"`
+		}{
+			Status:  StatusSuccess,
+			Code:    Code(StatusSuccess),
+			Message: message(context, StatusSuccess),
+			Data:    data,
+		}
+		context.JSON(http.StatusOK, response)
+		return
+	}
+
 	response := struct {
 		Status  int         `json:"status"`
 		Message string      `json:"message"`
 		Data    interface{} `json:"data"`
 	}{
 		Status:  StatusSuccess,
-		Message: messages[StatusSuccess],
+		Message: message(context, StatusSuccess),
 		Data:    data,
 	}
 	context.JSON(http.StatusOK, response)
 }
 
 func Status(context *gin.Context, status int) {
+	context.Set(StatusKey, status)
+	if version(context) >= Version2 {
+		response := struct {
+			Status  int                    `json:"status"`
+			Code    string                 `json:"code"`
+			Message string                 `json:"message"`
+			Details map[string]interface{} `json:"details,omitempty"`
+		}{
+			Status:  status,
+			Code:    Code(status),
+			Message: message(context, status),
+			Details: context.GetStringMap(detailsKey),
+		}
+		context.JSON(HTTPStatus(status), response)
+		return
+	}
+
 	response := struct {
 		Status  int    `json:"status"`
 		Message string `json:"message"`
 	}{
 		Status:  status,
-		Message: messages[status],
+		Message: message(context, status),
 	}
 	context.JSON(http.StatusOK, response)
 }
@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package status
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	sqlQueryDrift = `select module,status,policies,missing,stale,healed,error,timestamp from policy_drift order by module`
+)
+
+func (w *Worker) queryDrifts() (drifts []Drift, err error) {
+	stmt, err := w.db.Prepare(sqlQueryDrift)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query()
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer rows.Close()
+	drifts = []Drift{}
+	for rows.Next() {
+		drift := Drift{}
+		err = rows.Scan(&drift.Module, &drift.Status, &drift.Policies, &drift.Missing, &drift.Stale, &drift.Healed, &drift.Error, &drift.Timestamp)
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+		drifts = append(drifts, drift)
+	}
+	err = rows.Err()
+	if err != nil {
+		logrus.Error(err)
+	}
+	return
+}
@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package status
+
+import (
+	"database/sql"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lanthora/uranus/internal/web/render"
+	"github.com/lanthora/uranus/internal/web/user"
+)
+
+type Worker struct {
+	db *sql.DB
+}
+
+// Drift 是一个模块最近一次核对数据库与 hackernel 中策略的结果,由 worker 定期更新
+type Drift struct {
+	Module    string `json:"module"`
+	Status    string `json:"status"`
+	Policies  int    `json:"policies"`
+	Missing   int    `json:"missing"`
+	Stale     int    `json:"stale"`
+	Healed    int    `json:"healed"`
+	Error     string `json:"error"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+func Init(router *gin.Engine, db *sql.DB) (err error) {
+	w := &Worker{
+		db: db,
+	}
+
+	statusGroup := router.Group("/status")
+	statusGroup.Use(user.AuthMiddleware())
+	statusGroup.POST("/showDrift", w.showDrift)
+	return
+}
+
+// showDrift 返回各模块最近一次的策略核对结果, status 为 synced, drifted, unverified, healed, replayed 或 failed
+func (w *Worker) showDrift(context *gin.Context) {
+	drifts, err := w.queryDrifts()
+	if err != nil {
+		render.Status(context, render.StatusDriftQueryFailed)
+		return
+	}
+	render.Success(context, drifts)
+}
@@ -0,0 +1,36 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package user
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// CSRFCookie 保存 CSRF Token,前端需要读取它并放到 CSRFHeader 请求头中
+	CSRFCookie = "csrf"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// csrfToken 由会话推导,不需要额外存储,会话失效后对应的 CSRF Token 也失效
+func csrfToken(session string) string {
+	sum := sha256.Sum256([]byte("csrf:" + session))
+	return hex.EncodeToString(sum[:])
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// checkCSRF 校验使用 Cookie 认证的修改状态的请求,API Token 不会被浏览器自动携带,不需要校验
+func checkCSRF(context *gin.Context, session string) bool {
+	if isSafeMethod(context.Request.Method) {
+		return true
+	}
+	token := context.GetHeader(CSRFHeader)
+	return subtle.ConstantTimeCompare([]byte(token), []byte(csrfToken(session))) == 1
+}
@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package user
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCheckCSRF(t *testing.T) {
+	session := "session"
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		ok     bool
+	}{
+		{"get without token", http.MethodGet, "", true},
+		{"head without token", http.MethodHead, "", true},
+		{"options without token", http.MethodOptions, "", true},
+		{"post without token", http.MethodPost, "", false},
+		{"post with token", http.MethodPost, csrfToken(session), true},
+		{"post with token of another session", http.MethodPost, csrfToken("other"), false},
+		{"post with session as token", http.MethodPost, session, false},
+		{"delete with token", http.MethodDelete, csrfToken(session), true},
+		{"put without token", http.MethodPut, "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			context, _ := gin.CreateTestContext(httptest.NewRecorder())
+			context.Request = httptest.NewRequest(test.method, "/auth/changePassword", nil)
+			if test.token != "" {
+				context.Request.Header.Set(CSRFHeader, test.token)
+			}
+			if ok := checkCSRF(context, session); ok != test.ok {
+				t.Errorf("ok = %v, want %v", ok, test.ok)
+			}
+		})
+	}
+}
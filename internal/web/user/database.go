@@ -2,21 +2,32 @@
 package user
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"database/sql"
+	"errors"
 
-	"github.com/google/uuid"
+	"github.com/lanthora/uranus/internal/common"
+	"github.com/sirupsen/logrus"
 )
 
 const (
-	sqlCreateUserTable         = `create table if not exists user(id integer primary key autoincrement, username text not null unique, salt text not null, password text not null, alias text, permissions text)`
-	sqlInsertUser              = `insert into user(username, salt, password, alias, permissions) values(?,?,?,?,?)`
-	sqlQueryUserCount          = `select count(*) from user`
-	sqlQueryAllUser            = `select id, username, alias, permissions from user`
-	sqlQueryUserByUsername     = `select id, alias, permissions from user where username=?`
-	sqlQueryPasswordByUsername = `select salt, password from user where username=?`
-	sqlUpdateUser              = `update user set username=?, salt=?, password=?, alias=?, permissions=? where id=?`
-	sqlDeleteUser              = `delete from user where id=?`
+	sqlCreateUserTable          = `create table if not exists user(id integer primary key autoincrement, username text not null unique, salt text not null, password text not null, alias text, permissions text, role text)`
+	sqlInsertUser               = `insert into user(username, salt, password, alias, permissions, role) values(?,?,?,?,'',?)`
+	sqlQueryUserCount           = `select count(*) from user`
+	sqlQueryUserCountByID       = `select count(*) from user where id=?`
+	sqlQueryAllUser             = `select user.id, user.username, user.alias, ifnull(user.role,''), ifnull(role.permissions,''), ifnull(user_totp.enabled,0), ifnull(user_totp.required,0), ifnull(user.language,'') from user left join role on user.role=role.name left join user_totp on user.id=user_totp.user_id`
+	sqlQueryUserByUsername      = `select user.id, user.alias, ifnull(user.role,''), ifnull(role.permissions,''), ifnull(user_totp.enabled,0), ifnull(user_totp.required,0), ifnull(user.language,'') from user left join role on user.role=role.name left join user_totp on user.id=user_totp.user_id where user.username=?`
+	sqlQueryPasswordByUsername  = `select salt, password from user where username=?`
+	sqlUpdateUsernameByID       = `update user set username=? where id=?`
+	sqlUpdateAliasByID          = `update user set alias=? where id=?`
+	sqlUpdatePasswordByID       = `update user set salt=?, password=? where id=?`
+	sqlUpdateLanguageByID       = `update user set language=? where id=?`
+	sqlUpdatePasswordByUsername = `update user set salt=?, password=? where username=?`
+	sqlDeleteUser               = `delete from user where id=?`
+)
+
+var (
+	ErrorUserNotExist     = errors.New("user does not exist")
+	ErrorUpdateUserFailed = errors.New("update user failed")
 )
 
 func (w *Worker) initUserTable() (err error) {
@@ -24,6 +35,23 @@ func (w *Worker) initUserTable() (err error) {
 	if err != nil {
 		return
 	}
+
+	// 旧版本的用户表没有角色列,权限保存在 permissions 列的 glob 中
+	if err = common.AddColumnIfNotExists(w.db, "user", "role", "text"); err != nil {
+		return
+	}
+
+	if err = common.AddColumnIfNotExists(w.db, "user", "language", "text"); err != nil {
+		return
+	}
+
+	if err = w.initRoleTable(); err != nil {
+		return
+	}
+
+	if err = w.migrateGlobPermissions(); err != nil {
+		return
+	}
 	return
 }
 
@@ -41,10 +69,28 @@ func (w *Worker) noUser() bool {
 	return count == 0
 }
 
-func (w *Worker) createUser(username, password, alias, permissions string) (err error) {
-	salt := uuid.NewString()
-	sum := sha256.Sum256([]byte(salt + password))
-	hash := hex.EncodeToString(sum[:])
+func (w *Worker) userExists(id int64) (exists bool, err error) {
+	stmt, err := w.db.Prepare(sqlQueryUserCountByID)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+
+	count := int(0)
+	if err = stmt.QueryRow(id).Scan(&count); err != nil {
+		logrus.Error(err)
+		return
+	}
+	exists = count != 0
+	return
+}
+
+func (w *Worker) createUser(username, password, alias, role string) (err error) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return
+	}
 
 	stmt, err := w.db.Prepare(sqlInsertUser)
 	if err != nil {
@@ -52,7 +98,7 @@ func (w *Worker) createUser(username, password, alias, permissions string) (err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(username, salt, hash, alias, permissions)
+	_, err = stmt.Exec(username, "", hash, alias, role)
 	if err != nil {
 		return
 	}
@@ -69,13 +115,64 @@ func (w *Worker) checkUserPassword(username, password string) (ok bool, err erro
 	salt := ""
 	hash := ""
 	if err = stmt.QueryRow(username).Scan(&salt, &hash); err != nil {
+		// 用户不存在时同样计算一次哈希,避免通过响应时间判断用户名是否存在
+		if err == sql.ErrNoRows {
+			verifyPassword("", dummyHash(), password)
+		}
+		return
+	}
+
+	ok, rehash, err := verifyPassword(salt, hash, password)
+	if err != nil || !rehash {
 		return
 	}
-	sum := sha256.Sum256([]byte(salt + password))
-	ok = (hash == hex.EncodeToString(sum[:]))
+
+	// 旧格式的哈希在登录成功后升级,升级失败不影响本次登录
+	if err := w.updatePasswordByUsername(username, password); err != nil {
+		logrus.Error(err)
+	}
 	return
 }
 
+func (w *Worker) updatePasswordByUsername(username, password string) (err error) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return
+	}
+
+	stmt, err := w.db.Prepare(sqlUpdatePasswordByUsername)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec("", hash, username)
+	return
+}
+
+// ResetPassword 不经过 uranus-web 直接重置用户密码,并删除该用户的所有会话
+func ResetPassword(db *sql.DB, userID int64, password string) (err error) {
+	w := &Worker{db: db}
+	exists, err := w.userExists(userID)
+	if err != nil {
+		return
+	}
+	if !exists {
+		return ErrorUserNotExist
+	}
+	if ok := w.updateUserInfoByID(userID, nil, &password, nil, nil); !ok {
+		return ErrorUpdateUserFailed
+	}
+	_, err = db.Exec(sqlDeleteSessionsByUserID, userID)
+	return
+}
+
+// QueryAllUser 不经过 uranus-web 直接查询所有用户
+func QueryAllUser(db *sql.DB) (users []User, err error) {
+	w := &Worker{db: db}
+	return w.queryAllUser()
+}
+
 func (w *Worker) queryUserByUsername(username string) (user User, err error) {
 	user.Username = username
 	stmt, err := w.db.Prepare(sqlQueryUserByUsername)
@@ -84,9 +181,11 @@ func (w *Worker) queryUserByUsername(username string) (user User, err error) {
 	}
 	defer stmt.Close()
 
-	if err = stmt.QueryRow(user.Username).Scan(&user.UserID, &user.AliasName, &user.Permissions); err != nil {
+	permissions := ""
+	if err = stmt.QueryRow(user.Username).Scan(&user.UserID, &user.AliasName, &user.Role, &permissions, &user.TOTPEnabled, &user.TOTPRequired, &user.Language); err != nil {
 		return
 	}
+	user.Permissions = splitPermissions(permissions)
 	return
 }
 
@@ -103,10 +202,12 @@ func (w *Worker) queryAllUser() (users []User, err error) {
 	defer rows.Close()
 	for rows.Next() {
 		user := User{}
-		err = rows.Scan(&user.UserID, &user.Username, &user.AliasName, &user.Permissions)
+		permissions := ""
+		err = rows.Scan(&user.UserID, &user.Username, &user.AliasName, &user.Role, &permissions, &user.TOTPEnabled, &user.TOTPRequired, &user.Language)
 		if err != nil {
 			return
 		}
+		user.Permissions = splitPermissions(permissions)
 		users = append(users, user)
 	}
 	err = rows.Err()
@@ -116,18 +217,57 @@ func (w *Worker) queryAllUser() (users []User, err error) {
 	return
 }
 
-func (w *Worker) updateUserInfoByID(id int64, username, password, alias, permissions string) bool {
-	stmt, err := w.db.Prepare(sqlUpdateUser)
+// updateUserInfoByID 只更新不为 nil 的字段,所有字段在同一个事务中更新
+func (w *Worker) updateUserInfoByID(id int64, username, password, alias, role *string) bool {
+	tx, err := w.db.Begin()
 	if err != nil {
 		return false
 	}
-	defer stmt.Close()
+	defer tx.Rollback()
 
-	salt := uuid.NewString()
-	sum := sha256.Sum256([]byte(salt + password))
-	hash := hex.EncodeToString(sum[:])
+	update := func(query string, args ...interface{}) bool {
+		result, err := tx.Exec(query, args...)
+		if err != nil {
+			logrus.Error(err)
+			return false
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			logrus.Error(err)
+			return false
+		}
+		return affected == 1
+	}
+
+	if username != nil && !update(sqlUpdateUsernameByID, *username, id) {
+		return false
+	}
+	if alias != nil && !update(sqlUpdateAliasByID, *alias, id) {
+		return false
+	}
+	if role != nil && !update(sqlUpdateUserRoleByID, *role, id) {
+		return false
+	}
+	if password != nil {
+		hash, err := hashPassword(*password)
+		if err != nil {
+			return false
+		}
+		if !update(sqlUpdatePasswordByID, "", hash, id) {
+			return false
+		}
+	}
+	return tx.Commit() == nil
+}
+
+func (w *Worker) updateLanguageByID(id int64, language string) bool {
+	stmt, err := w.db.Prepare(sqlUpdateLanguageByID)
+	if err != nil {
+		return false
+	}
+	defer stmt.Close()
 
-	result, err := stmt.Exec(username, salt, hash, alias, permissions, id)
+	result, err := stmt.Exec(language, id)
 	if err != nil {
 		return false
 	}
@@ -0,0 +1,245 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package user
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	sqlCreateLoginFailureTable      = `create table if not exists login_failure(id integer primary key autoincrement, username text not null, ip text not null, timestamp integer not null)`
+	sqlInsertLoginFailure           = `insert into login_failure(username,ip,timestamp) values(?,?,?)`
+	sqlQueryLoginFailureLimitOffset = `select id,username,ip,timestamp from login_failure where id>? limit ?`
+)
+
+const (
+	defaultLoginMaxFailures = 5
+	defaultLoginLockout     = 15 * time.Minute
+	// 第一次失败后的等待时间,之后每次失败翻倍
+	loginBaseDelay = time.Second
+	// 记录数超过该值时清理已经过期的记录
+	loginRecordCleanupThreshold = 1024
+)
+
+type LoginConfig struct {
+	MaxFailures int
+	Lockout     time.Duration
+}
+
+type LoginFailure struct {
+	ID        int64  `json:"id"`
+	Username  string `json:"username"`
+	IP        string `json:"ip"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+type loginRecord struct {
+	failures int
+	last     time.Time
+}
+
+// loginLimiter 分别按 IP 和用户名统计连续登录失败次数.
+// 每次失败后需要等待的时间指数增长,失败次数达到上限后锁定一段时间.
+type loginLimiter struct {
+	mutex   sync.Mutex
+	config  LoginConfig
+	records map[string]*loginRecord
+}
+
+func newLoginLimiter(config LoginConfig) *loginLimiter {
+	if config.MaxFailures <= 0 {
+		config.MaxFailures = defaultLoginMaxFailures
+	}
+	if config.Lockout <= 0 {
+		config.Lockout = defaultLoginLockout
+	}
+	return &loginLimiter{
+		config:  config,
+		records: map[string]*loginRecord{},
+	}
+}
+
+func (l *loginLimiter) delay(record *loginRecord) time.Duration {
+	if record.failures >= l.config.MaxFailures {
+		return l.config.Lockout
+	}
+	delay := loginBaseDelay << (record.failures - 1)
+	if delay > l.config.Lockout {
+		delay = l.config.Lockout
+	}
+	return delay
+}
+
+// Allow 判断是否允许本次登录尝试.允许时先把本次尝试记为失败,登录成功后由 Succeed 清除,
+// 检查和记录在同一个临界区内完成,并发的请求不能绕过等待时间和锁定
+func (l *loginLimiter) Allow(keys ...string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	for _, key := range keys {
+		record, ok := l.records[key]
+		if !ok {
+			continue
+		}
+		if now.Sub(record.last) < l.delay(record) {
+			return false
+		}
+	}
+
+	for _, key := range keys {
+		record, ok := l.records[key]
+		if !ok || now.Sub(record.last) > l.config.Lockout {
+			record = &loginRecord{}
+			l.records[key] = record
+		}
+		record.failures++
+		record.last = now
+	}
+
+	if len(l.records) > loginRecordCleanupThreshold {
+		for key, record := range l.records {
+			if now.Sub(record.last) > l.config.Lockout {
+				delete(l.records, key)
+			}
+		}
+	}
+	return true
+}
+
+// Release 撤销 Allow 记录的本次尝试,用于没有校验出错误凭据就结束的请求
+func (l *loginLimiter) Release(keys ...string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, key := range keys {
+		record, ok := l.records[key]
+		if !ok {
+			continue
+		}
+		if record.failures--; record.failures <= 0 {
+			delete(l.records, key)
+		}
+	}
+}
+
+func (l *loginLimiter) Succeed(keys ...string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, key := range keys {
+		delete(l.records, key)
+	}
+}
+
+func loginLimiterKeys(username, ip string) []string {
+	return []string{"username:" + username, "ip:" + ip}
+}
+
+// setupToken 是没有任何用户时创建管理员需要的一次性令牌
+type setupToken struct {
+	mutex sync.Mutex
+	token string
+}
+
+func (s *setupToken) Generate() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	buffer := make([]byte, 16)
+	if _, err := rand.Read(buffer); err != nil {
+		logrus.Error(err)
+		return
+	}
+	s.token = hex.EncodeToString(buffer)
+	logrus.Warnf("no user exists, create the administrator with setup token: %s", s.token)
+}
+
+// Consume 校验令牌,校验成功后令牌失效
+func (s *setupToken) Consume(token string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.token == "" || subtle.ConstantTimeCompare([]byte(s.token), []byte(token)) != 1 {
+		return false
+	}
+	s.token = ""
+	return true
+}
+
+// Restore 在创建管理员失败后恢复已经消耗的令牌,期间重新生成过令牌时不恢复
+func (s *setupToken) Restore(token string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.token == "" {
+		s.token = token
+	}
+}
+
+// Empty 判断当前是否没有可用的令牌
+func (s *setupToken) Empty() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.token == ""
+}
+
+func (w *Worker) initLoginFailureTable() (err error) {
+	_, err = w.db.Exec(sqlCreateLoginFailureTable)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	return
+}
+
+func (w *Worker) insertLoginFailure(username, ip string) (err error) {
+	stmt, err := w.db.Prepare(sqlInsertLoginFailure)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(username, ip, time.Now().Unix())
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	return
+}
+
+func (w *Worker) queryLoginFailureLimitOffset(limit, offset int) (failures []LoginFailure, err error) {
+	stmt, err := w.db.Prepare(sqlQueryLoginFailureLimitOffset)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(offset, limit)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		failure := LoginFailure{}
+		err = rows.Scan(&failure.ID, &failure.Username, &failure.IP, &failure.Timestamp)
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+		failures = append(failures, failure)
+	}
+	err = rows.Err()
+	if err != nil {
+		logrus.Error(err)
+	}
+	return
+}
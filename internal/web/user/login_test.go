@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package user
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// elapse 把所有记录的最后尝试时间提前,模拟时间流逝
+func (l *loginLimiter) elapse(d time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, record := range l.records {
+		record.last = record.last.Add(-d)
+	}
+}
+
+type limiterStep struct {
+	action   string
+	username string
+	elapse   time.Duration
+	want     bool
+}
+
+func TestLoginLimiter(t *testing.T) {
+	tests := []struct {
+		name   string
+		config LoginConfig
+		steps  []limiterStep
+	}{
+		{
+			name:   "repeated attempt waits",
+			config: LoginConfig{},
+			steps: []limiterStep{
+				{action: "allow", username: "alice", want: true},
+				{action: "allow", username: "alice", want: false},
+			},
+		},
+		{
+			name:   "delay doubles",
+			config: LoginConfig{},
+			steps: []limiterStep{
+				{action: "allow", username: "alice", want: true},
+				{elapse: time.Second},
+				{action: "allow", username: "alice", want: true},
+				{elapse: time.Second},
+				{action: "allow", username: "alice", want: false},
+				{elapse: time.Second},
+				{action: "allow", username: "alice", want: true},
+				{elapse: 3 * time.Second},
+				{action: "allow", username: "alice", want: false},
+				{elapse: time.Second},
+				{action: "allow", username: "alice", want: true},
+			},
+		},
+		{
+			name:   "success clears",
+			config: LoginConfig{},
+			steps: []limiterStep{
+				{action: "allow", username: "alice", want: true},
+				{action: "succeed", username: "alice"},
+				{action: "allow", username: "alice", want: true},
+			},
+		},
+		{
+			name:   "release undoes the attempt",
+			config: LoginConfig{},
+			steps: []limiterStep{
+				{action: "allow", username: "alice", want: true},
+				{action: "release", username: "alice"},
+				{action: "allow", username: "alice", want: true},
+			},
+		},
+		{
+			name:   "ip is shared by usernames",
+			config: LoginConfig{},
+			steps: []limiterStep{
+				{action: "allow", username: "alice", want: true},
+				{action: "allow", username: "bob", want: false},
+			},
+		},
+		{
+			name:   "lockout",
+			config: LoginConfig{MaxFailures: 3, Lockout: time.Minute},
+			steps: []limiterStep{
+				{action: "allow", username: "alice", want: true},
+				{elapse: time.Second},
+				{action: "allow", username: "alice", want: true},
+				{elapse: 2 * time.Second},
+				{action: "allow", username: "alice", want: true},
+				{elapse: 30 * time.Second},
+				{action: "allow", username: "alice", want: false},
+				{elapse: 31 * time.Second},
+				{action: "allow", username: "alice", want: true},
+				// 锁定结束后重新计数
+				{elapse: time.Second},
+				{action: "allow", username: "alice", want: true},
+			},
+		},
+		{
+			name:   "delay capped by lockout",
+			config: LoginConfig{MaxFailures: 10, Lockout: 3 * time.Second},
+			steps: []limiterStep{
+				{action: "allow", username: "alice", want: true},
+				{elapse: time.Second},
+				{action: "allow", username: "alice", want: true},
+				{elapse: 2 * time.Second},
+				{action: "allow", username: "alice", want: true},
+				{elapse: 3 * time.Second},
+				{action: "allow", username: "alice", want: true},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := newLoginLimiter(test.config)
+			for i, step := range test.steps {
+				keys := loginLimiterKeys(step.username, "10.0.0.1")
+				switch step.action {
+				case "allow":
+					if got := l.Allow(keys...); got != step.want {
+						t.Fatalf("step %d: allow = %v, want %v", i, got, step.want)
+					}
+				case "succeed":
+					l.Succeed(keys...)
+				case "release":
+					l.Release(keys...)
+				default:
+					l.elapse(step.elapse)
+				}
+			}
+		})
+	}
+}
+
+func TestLoginLimiterConcurrent(t *testing.T) {
+	l := newLoginLimiter(LoginConfig{})
+	keys := loginLimiterKeys("alice", "10.0.0.1")
+
+	allowed := make(chan bool, 32)
+	wg := sync.WaitGroup{}
+	for i := 0; i < cap(allowed); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			allowed <- l.Allow(keys...)
+		}()
+	}
+	wg.Wait()
+	close(allowed)
+
+	count := 0
+	for ok := range allowed {
+		if ok {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("%d parallel attempts allowed, want 1", count)
+	}
+}
@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// argon2id 参数,内存占用需要考虑 uranus-web 的 GOMEMLIMIT
+const (
+	argon2Memory  = 19 * 1024
+	argon2Time    = 2
+	argon2Threads = 1
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+const argon2Prefix = "$argon2id$"
+
+var (
+	ErrorInvalidHash = errors.New("invalid password hash")
+)
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+var currentArgon2Params = argon2Params{
+	memory:  argon2Memory,
+	time:    argon2Time,
+	threads: argon2Threads,
+}
+
+// hashPassword 生成 PHC 格式的 argon2id 哈希,参数与盐都保存在哈希字符串中
+func hashPassword(password string) (hash string, err error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err = rand.Read(salt); err != nil {
+		return
+	}
+	p := currentArgon2Params
+	key := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, argon2KeyLen)
+	hash = fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2Prefix, argon2.Version, p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+	return
+}
+
+var (
+	dummyHashOnce  sync.Once
+	dummyHashValue string
+)
+
+// dummyHash 返回用当前参数计算的哈希,用户不存在时用它校验密码
+func dummyHash() string {
+	dummyHashOnce.Do(func() {
+		dummyHashValue, _ = hashPassword("")
+	})
+	return dummyHashValue
+}
+
+// verifyPassword 校验密码,同时兼容旧版本的 sha256(salt+password).
+// 旧格式或参数过时的哈希在校验成功后需要重新计算.
+func verifyPassword(salt, hash, password string) (ok, rehash bool, err error) {
+	if !strings.HasPrefix(hash, argon2Prefix) {
+		sum := sha256.Sum256([]byte(salt + password))
+		ok = subtle.ConstantTimeCompare([]byte(hash), []byte(hex.EncodeToString(sum[:]))) == 1
+		rehash = ok
+		return
+	}
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		err = ErrorInvalidHash
+		return
+	}
+
+	version := 0
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return
+	}
+	if version != argon2.Version {
+		err = ErrorInvalidHash
+		return
+	}
+
+	p := argon2Params{}
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return
+	}
+
+	decodedSalt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return
+	}
+
+	other := argon2.IDKey([]byte(password), decodedSalt, p.time, p.memory, p.threads, uint32(len(key)))
+	ok = subtle.ConstantTimeCompare(key, other) == 1
+	rehash = ok && p != currentArgon2Params
+	return
+}
@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package user
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func legacyHash(salt, password string) string {
+	sum := sha256.Sum256([]byte(salt + password))
+	return hex.EncodeToString(sum[:])
+}
+
+// outdatedHash 使用比当前更弱的参数计算哈希,模拟旧版本保存的 argon2id 哈希
+func outdatedHash(t *testing.T, password string) string {
+	t.Helper()
+	current := currentArgon2Params
+	defer func() { currentArgon2Params = current }()
+	currentArgon2Params = argon2Params{memory: 8 * 1024, time: 1, threads: 1}
+	hash, err := hashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+// replacePart 替换 PHC 格式哈希中以 $ 分隔的一段
+func replacePart(hash string, index int, part string) string {
+	parts := strings.Split(hash, "$")
+	parts[index] = part
+	return strings.Join(parts, "$")
+}
+
+func TestVerifyPassword(t *testing.T) {
+	current, err := hashPassword("pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outdated := outdatedHash(t, "pw")
+
+	tests := []struct {
+		name     string
+		salt     string
+		hash     string
+		password string
+		ok       bool
+		rehash   bool
+		err      bool
+	}{
+		{"legacy", "salt", legacyHash("salt", "pw"), "pw", true, true, false},
+		{"legacy wrong password", "salt", legacyHash("salt", "pw"), "other", false, false, false},
+		{"legacy wrong salt", "other", legacyHash("salt", "pw"), "pw", false, false, false},
+		{"current", "", current, "pw", true, false, false},
+		{"current wrong password", "", current, "other", false, false, false},
+		{"outdated params", "", outdated, "pw", true, true, false},
+		{"outdated wrong password", "", outdated, "other", false, false, false},
+		{"missing fields", "", argon2Prefix + "v=19$m=1,t=1,p=1", "pw", false, false, true},
+		{"unknown version", "", replacePart(current, 2, "v=16"), "pw", false, false, true},
+		{"bad params", "", replacePart(current, 3, "m=1"), "pw", false, false, true},
+		{"bad salt", "", replacePart(current, 4, "!!!"), "pw", false, false, true},
+		{"bad key", "", replacePart(current, 5, "!!!"), "pw", false, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ok, rehash, err := verifyPassword(test.salt, test.hash, test.password)
+			if (err != nil) != test.err {
+				t.Fatalf("err = %v, want error %v", err, test.err)
+			}
+			if ok != test.ok || rehash != test.rehash {
+				t.Errorf("ok = %v, rehash = %v, want %v, %v", ok, rehash, test.ok, test.rehash)
+			}
+		})
+	}
+}
+
+func TestCheckUserPasswordUpgrade(t *testing.T) {
+	tests := []struct {
+		name     string
+		salt     string
+		hash     string
+		password string
+		ok       bool
+		upgraded bool
+	}{
+		{"legacy", "salt", legacyHash("salt", "pw"), "pw", true, true},
+		{"legacy wrong password", "salt", legacyHash("salt", "pw"), "other", false, false},
+		{"outdated params", "", outdatedHash(t, "pw"), "pw", true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := newTestWorker(t)
+			if _, err := w.db.Exec(sqlInsertUser, "alice", test.salt, test.hash, "alice", RoleViewer); err != nil {
+				t.Fatal(err)
+			}
+
+			ok, err := w.checkUserPassword("alice", test.password)
+			if err != nil || ok != test.ok {
+				t.Fatalf("ok = %v, err = %v, want %v", ok, err, test.ok)
+			}
+
+			salt, hash := "", ""
+			if err := w.db.QueryRow(sqlQueryPasswordByUsername, "alice").Scan(&salt, &hash); err != nil {
+				t.Fatal(err)
+			}
+			if upgraded := hash != test.hash; upgraded != test.upgraded {
+				t.Fatalf("upgraded = %v, want %v", upgraded, test.upgraded)
+			}
+			if !test.upgraded {
+				return
+			}
+			if salt != "" {
+				t.Errorf("salt %q is kept after upgrade", salt)
+			}
+			if ok, rehash, err := verifyPassword(salt, hash, test.password); !ok || rehash || err != nil {
+				t.Errorf("upgraded hash: ok = %v, rehash = %v, err = %v", ok, rehash, err)
+			}
+		})
+	}
+}
+
+func TestCheckUserPasswordUnknownUser(t *testing.T) {
+	w := newTestWorker(t)
+	ok, err := w.checkUserPassword("nobody", "pw")
+	if ok || err == nil {
+		t.Errorf("ok = %v, err = %v, want a not found error", ok, err)
+	}
+}
@@ -0,0 +1,360 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package user
+
+import (
+	"errors"
+	"path"
+	"strings"
+
+	"github.com/gobwas/glob"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	sqlCreateRoleTable     = `create table if not exists role(id integer primary key autoincrement, name text not null unique, permissions text not null, builtin integer not null)`
+	sqlInsertBuiltinRole   = `insert into role(name,permissions,builtin) values(?,?,1) on conflict(name) do update set permissions=excluded.permissions where builtin=1`
+	sqlInsertRole          = `insert into role(name,permissions,builtin) values(?,?,0)`
+	sqlQueryAllRole        = `select id,name,permissions,builtin from role`
+	sqlQueryRoleByName     = `select id,name,permissions,builtin from role where name=?`
+	sqlUpdateRoleByName    = `update role set permissions=? where name=? and builtin=0`
+	sqlDeleteRoleByName    = `delete from role where name=? and builtin=0 and name not in (select role from user where role is not null)`
+	sqlQueryUnmigratedUser = `select id,username,permissions from user where role is null`
+	sqlUpdateUserRoleByID  = `update user set role=? where id=?`
+)
+
+const (
+	ModuleProcess = "process"
+	ModuleFile    = "file"
+	ModuleNet     = "net"
+	ModuleAdmin   = "admin"
+	ModuleCtrl    = "ctrl"
+	ModuleStatus  = "status"
+)
+
+const (
+	ActionRead  = "read"
+	ActionWrite = "write"
+)
+
+const (
+	RoleAdmin    = "admin"
+	RoleOperator = "operator"
+	RoleViewer   = "viewer"
+)
+
+var (
+	ErrorInvalidPermission = errors.New("invalid permission")
+)
+
+var modules = []string{ModuleProcess, ModuleFile, ModuleNet, ModuleAdmin, ModuleCtrl, ModuleStatus}
+
+// 不修改状态的接口以这些前缀命名,只需要读权限
+var readonlyPrefixes = []string{"list", "show"}
+
+var builtinRoles = map[string][]string{
+	RoleAdmin: {
+		"process:read", "process:write",
+		"file:read", "file:write",
+		"net:read", "net:write",
+		"admin:read", "admin:write",
+		"ctrl:read", "ctrl:write",
+		"status:read",
+	},
+	RoleOperator: {
+		"process:read", "process:write",
+		"file:read", "file:write",
+		"net:read", "net:write",
+		"status:read",
+	},
+	RoleViewer: {
+		"process:read",
+		"file:read",
+		"net:read",
+		"status:read",
+	},
+}
+
+// 迁移旧版本的 glob 权限时,用每个模块有代表性的接口判断是否具有读写权限, status 模块只有只读接口
+var migrationSamples = map[string]map[string]string{
+	ModuleProcess: {ActionRead: "/process/listEvents", ActionWrite: "/process/updateWorkMode"},
+	ModuleFile:    {ActionRead: "/file/listPolicies", ActionWrite: "/file/addPolicy"},
+	ModuleNet:     {ActionRead: "/net/listPolicies", ActionWrite: "/net/addPolicy"},
+	ModuleAdmin:   {ActionRead: "/admin/listAllUsers", ActionWrite: "/admin/addUser"},
+	ModuleCtrl:    {ActionRead: "/ctrl/showLogLevel", ActionWrite: "/ctrl/shutdown"},
+	ModuleStatus:  {ActionRead: "/status/showDrift"},
+}
+
+type Role struct {
+	ID          int64    `json:"id"`
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+	Builtin     bool     `json:"builtin"`
+}
+
+func Permission(module, action string) string {
+	return module + ":" + action
+}
+
+// IsReadonlyEndpoint 判断接口是否只读取数据
+func IsReadonlyEndpoint(urlPath string) bool {
+	endpoint := path.Base(urlPath)
+	for _, prefix := range readonlyPrefixes {
+		if strings.HasPrefix(endpoint, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequiredPermission 返回访问接口需要的权限,通过会话登录的用户都可以访问 /auth 下的接口
+func RequiredPermission(urlPath string) (permission string, required bool) {
+	module := strings.SplitN(strings.TrimPrefix(urlPath, "/"), "/", 2)[0]
+	if module == "auth" {
+		return
+	}
+
+	action := ActionWrite
+	if IsReadonlyEndpoint(urlPath) {
+		action = ActionRead
+	}
+	permission = Permission(module, action)
+	required = true
+	return
+}
+
+func (u *User) HasPermission(permission string) bool {
+	for _, p := range u.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func validatePermissions(permissions []string) (err error) {
+	valid := map[string]bool{}
+	for _, module := range modules {
+		valid[Permission(module, ActionRead)] = true
+		valid[Permission(module, ActionWrite)] = true
+	}
+	for _, p := range permissions {
+		if !valid[p] {
+			return ErrorInvalidPermission
+		}
+	}
+	return
+}
+
+func joinPermissions(permissions []string) string {
+	return strings.Join(permissions, ",")
+}
+
+func splitPermissions(permissions string) []string {
+	if permissions == "" {
+		return []string{}
+	}
+	return strings.Split(permissions, ",")
+}
+
+func (w *Worker) initRoleTable() (err error) {
+	_, err = w.db.Exec(sqlCreateRoleTable)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	stmt, err := w.db.Prepare(sqlInsertBuiltinRole)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+
+	for name, permissions := range builtinRoles {
+		if _, err = stmt.Exec(name, joinPermissions(permissions)); err != nil {
+			logrus.Error(err)
+			return
+		}
+	}
+	return
+}
+
+// migrateGlobPermissions 把旧版本的 glob 权限转换成角色,与内置角色一致时直接使用内置角色
+func (w *Worker) migrateGlobPermissions() (err error) {
+	rows, err := w.db.Query(sqlQueryUnmigratedUser)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	type unmigrated struct {
+		id          int64
+		username    string
+		permissions string
+	}
+	users := []unmigrated{}
+	for rows.Next() {
+		u := unmigrated{}
+		if err = rows.Scan(&u.id, &u.username, &u.permissions); err != nil {
+			rows.Close()
+			logrus.Error(err)
+			return
+		}
+		users = append(users, u)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	for _, u := range users {
+		permissions := []string{}
+		if g, err := glob.Compile(u.permissions); err == nil {
+			for _, module := range modules {
+				for _, action := range []string{ActionRead, ActionWrite} {
+					if sample, ok := migrationSamples[module][action]; ok && g.Match(sample) {
+						permissions = append(permissions, Permission(module, action))
+					}
+				}
+			}
+		}
+
+		role := ""
+		for name, builtin := range builtinRoles {
+			if joinPermissions(builtin) == joinPermissions(permissions) {
+				role = name
+				break
+			}
+		}
+		if role == "" {
+			role = "migrated-" + u.username
+			if err = w.createRole(role, permissions); err != nil {
+				logrus.Error(err)
+				return
+			}
+		}
+
+		if _, err = w.db.Exec(sqlUpdateUserRoleByID, role, u.id); err != nil {
+			logrus.Error(err)
+			return
+		}
+		logrus.Infof("user %s migrated to role %s", u.username, role)
+	}
+	return
+}
+
+func (w *Worker) createRole(name string, permissions []string) (err error) {
+	if err = validatePermissions(permissions); err != nil {
+		return
+	}
+
+	stmt, err := w.db.Prepare(sqlInsertRole)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(name, joinPermissions(permissions))
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	return
+}
+
+func (w *Worker) queryRoleByName(name string) (role Role, err error) {
+	stmt, err := w.db.Prepare(sqlQueryRoleByName)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+
+	permissions := ""
+	err = stmt.QueryRow(name).Scan(&role.ID, &role.Name, &permissions, &role.Builtin)
+	if err != nil {
+		return
+	}
+	role.Permissions = splitPermissions(permissions)
+	return
+}
+
+func (w *Worker) queryAllRole() (roles []Role, err error) {
+	stmt, err := w.db.Prepare(sqlQueryAllRole)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query()
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		role := Role{}
+		permissions := ""
+		err = rows.Scan(&role.ID, &role.Name, &permissions, &role.Builtin)
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+		role.Permissions = splitPermissions(permissions)
+		roles = append(roles, role)
+	}
+	err = rows.Err()
+	if err != nil {
+		logrus.Error(err)
+	}
+	return
+}
+
+func (w *Worker) updateRoleByName(name string, permissions []string) bool {
+	if err := validatePermissions(permissions); err != nil {
+		return false
+	}
+
+	stmt, err := w.db.Prepare(sqlUpdateRoleByName)
+	if err != nil {
+		logrus.Error(err)
+		return false
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(joinPermissions(permissions), name)
+	if err != nil {
+		logrus.Error(err)
+		return false
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		logrus.Error(err)
+		return false
+	}
+	return affected == 1
+}
+
+// deleteRoleByName 内置角色和正在使用的角色不能删除
+func (w *Worker) deleteRoleByName(name string) bool {
+	stmt, err := w.db.Prepare(sqlDeleteRoleByName)
+	if err != nil {
+		logrus.Error(err)
+		return false
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(name)
+	if err != nil {
+		logrus.Error(err)
+		return false
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		logrus.Error(err)
+		return false
+	}
+	return affected == 1
+}
@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package user
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMigrateGlobPermissions(t *testing.T) {
+	tests := []struct {
+		name        string
+		glob        string
+		role        string
+		permissions []string
+	}{
+		{"everything", "*", RoleAdmin, builtinRoles[RoleAdmin]},
+		{"viewer", "{/process/list*,/file/list*,/net/list*,/status/show*}", RoleViewer, builtinRoles[RoleViewer]},
+		{"operator", "{/process/*,/file/*,/net/*,/status/*}", RoleOperator, builtinRoles[RoleOperator]},
+		{"single module", "/process/*", "migrated-alice", []string{"process:read", "process:write"}},
+		{"read only", "/*/list*", "migrated-alice", []string{"process:read", "file:read", "net:read", "admin:read"}},
+		{"nothing", "/none", "migrated-alice", []string{}},
+		{"invalid glob", "[", "migrated-alice", []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := newTestWorker(t)
+			_, err := w.db.Exec(`insert into user(username,salt,password,alias,permissions) values('alice','','','alice',?)`, test.glob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = w.migrateGlobPermissions(); err != nil {
+				t.Fatal(err)
+			}
+
+			user, err := w.queryUserByUsername("alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.Role != test.role {
+				t.Fatalf("role = %q, want %q", user.Role, test.role)
+			}
+			role, err := w.queryRoleByName(user.Role)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(role.Permissions, test.permissions) {
+				t.Errorf("permissions = %v, want %v", role.Permissions, test.permissions)
+			}
+
+			// 已经迁移的用户不再处理
+			if err = w.migrateGlobPermissions(); err != nil {
+				t.Fatal(err)
+			}
+			if again, _ := w.queryUserByUsername("alice"); again.Role != test.role {
+				t.Errorf("role changed to %q after a second migration", again.Role)
+			}
+		})
+	}
+}
+
+func TestRequiredPermission(t *testing.T) {
+	tests := []struct {
+		path       string
+		permission string
+		required   bool
+	}{
+		{"/auth/showCurrentUserInfo", "", false},
+		{"/auth/changePassword", "", false},
+		{"/process/listEvents", "process:read", true},
+		{"/process/updateWorkMode", "process:write", true},
+		{"/file/showModuleStatus", "file:read", true},
+		{"/net/addPolicy", "net:write", true},
+		{"/admin/listAllUsers", "admin:read", true},
+		{"/status/showDrift", "status:read", true},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			permission, required := RequiredPermission(test.path)
+			if permission != test.permission || required != test.required {
+				t.Errorf("got %q, %v, want %q, %v", permission, required, test.permission, test.required)
+			}
+		})
+	}
+}
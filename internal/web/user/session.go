@@ -0,0 +1,210 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	sqlCreateSessionTable         = `create table if not exists user_session(id integer primary key autoincrement, token text not null unique, user_id integer not null, ip text, created integer not null, accessed integer not null)`
+	sqlInsertSession              = `insert into user_session(token,user_id,ip,created,accessed) values(?,?,?,?,?)`
+	sqlQuerySessionByToken        = `select user_session.id,user_session.created,user_session.accessed,user.id,user.username,user.alias,ifnull(user.role,''),ifnull(role.permissions,''),ifnull(user_totp.enabled,0),ifnull(user_totp.required,0),ifnull(user.language,'') from user_session join user on user_session.user_id=user.id left join role on user.role=role.name left join user_totp on user.id=user_totp.user_id where user_session.token=?`
+	sqlQuerySessionsByUserID      = `select id,user_id,ip,created,accessed from user_session where user_id=? order by accessed desc, id desc`
+	sqlUpdateSessionAccessed      = `update user_session set accessed=? where id=?`
+	sqlDeleteSessionByToken       = `delete from user_session where token=?`
+	sqlDeleteSessionByID          = `delete from user_session where id=?`
+	sqlDeleteSessionsByUserID     = `delete from user_session where user_id=?`
+	sqlDeleteOtherSessions        = `delete from user_session where user_id=? and token!=?`
+	sqlDeleteExpiredSessions      = `delete from user_session where accessed<? or created<?`
+	sqlDeleteSessionsOverCapacity = `delete from user_session where user_id=? and id not in (select id from user_session where user_id=? order by accessed desc, id desc limit ?)`
+)
+
+// 最后访问时间的更新间隔,避免每个请求都写数据库
+const sessionTouchInterval = time.Minute
+
+const (
+	defaultSessionIdleTimeout     = 2 * time.Hour
+	defaultSessionAbsoluteTimeout = 24 * time.Hour
+	defaultSessionCapacity        = 10
+)
+
+type SessionConfig struct {
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+	Capacity        int
+}
+
+type Session struct {
+	ID       int64  `json:"id"`
+	UserID   int64  `json:"userID"`
+	IP       string `json:"ip"`
+	Created  int64  `json:"created"`
+	Accessed int64  `json:"accessed"`
+}
+
+type sessionStore struct {
+	db     *sql.DB
+	config SessionConfig
+}
+
+func newSessionStore(db *sql.DB, config SessionConfig) (s *sessionStore, err error) {
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = defaultSessionIdleTimeout
+	}
+	if config.AbsoluteTimeout <= 0 {
+		config.AbsoluteTimeout = defaultSessionAbsoluteTimeout
+	}
+	if config.Capacity <= 0 {
+		config.Capacity = defaultSessionCapacity
+	}
+
+	s = &sessionStore{
+		db:     db,
+		config: config,
+	}
+	_, err = db.Exec(sqlCreateSessionTable)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	return
+}
+
+// 数据库中只保存 token 的哈希,数据库泄露时无法直接冒用会话
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *sessionStore) deleteExpired() (err error) {
+	now := time.Now()
+	_, err = s.db.Exec(sqlDeleteExpiredSessions,
+		now.Add(-s.config.IdleTimeout).Unix(), now.Add(-s.config.AbsoluteTimeout).Unix())
+	if err != nil {
+		logrus.Error(err)
+	}
+	return
+}
+
+// Add 为用户创建会话,超出容量时淘汰该用户最久未访问的会话
+func (s *sessionStore) Add(userID int64, ip string) (token string, err error) {
+	s.deleteExpired()
+
+	buffer := make([]byte, 32)
+	if _, err = rand.Read(buffer); err != nil {
+		return
+	}
+	token = hex.EncodeToString(buffer)
+
+	now := time.Now().Unix()
+	_, err = s.db.Exec(sqlInsertSession, hashToken(token), userID, ip, now, now)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	_, err = s.db.Exec(sqlDeleteSessionsOverCapacity, userID, userID, s.config.Capacity)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	return
+}
+
+// Get 查询会话对应的用户,过期的会话会被删除
+func (s *sessionStore) Get(token string) (user User, ok bool) {
+	id, created, accessed := int64(0), int64(0), int64(0)
+	permissions := ""
+	err := s.db.QueryRow(sqlQuerySessionByToken, hashToken(token)).Scan(&id, &created, &accessed,
+		&user.UserID, &user.Username, &user.AliasName, &user.Role, &permissions, &user.TOTPEnabled, &user.TOTPRequired, &user.Language)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logrus.Error(err)
+		}
+		return
+	}
+
+	user.Permissions = splitPermissions(permissions)
+
+	now := time.Now()
+	if now.Sub(time.Unix(accessed, 0)) > s.config.IdleTimeout || now.Sub(time.Unix(created, 0)) > s.config.AbsoluteTimeout {
+		s.Remove(token)
+		return
+	}
+
+	if now.Sub(time.Unix(accessed, 0)) > sessionTouchInterval {
+		if _, err = s.db.Exec(sqlUpdateSessionAccessed, now.Unix(), id); err != nil {
+			logrus.Error(err)
+		}
+	}
+	ok = true
+	return
+}
+
+func (s *sessionStore) Remove(token string) {
+	if _, err := s.db.Exec(sqlDeleteSessionByToken, hashToken(token)); err != nil {
+		logrus.Error(err)
+	}
+}
+
+func (s *sessionStore) RemoveByID(id int64) bool {
+	result, err := s.db.Exec(sqlDeleteSessionByID, id)
+	if err != nil {
+		logrus.Error(err)
+		return false
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		logrus.Error(err)
+		return false
+	}
+	return affected == 1
+}
+
+func (s *sessionStore) RemoveByUserID(userID int64) (err error) {
+	_, err = s.db.Exec(sqlDeleteSessionsByUserID, userID)
+	if err != nil {
+		logrus.Error(err)
+	}
+	return
+}
+
+// RemoveOthers 删除用户除当前会话以外的所有会话
+func (s *sessionStore) RemoveOthers(userID int64, token string) (err error) {
+	_, err = s.db.Exec(sqlDeleteOtherSessions, userID, hashToken(token))
+	if err != nil {
+		logrus.Error(err)
+	}
+	return
+}
+
+func (s *sessionStore) ListByUserID(userID int64) (sessions []Session, err error) {
+	s.deleteExpired()
+
+	rows, err := s.db.Query(sqlQuerySessionsByUserID, userID)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		session := Session{}
+		err = rows.Scan(&session.ID, &session.UserID, &session.IP, &session.Created, &session.Accessed)
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+		sessions = append(sessions, session)
+	}
+	err = rows.Err()
+	if err != nil {
+		logrus.Error(err)
+	}
+	return
+}
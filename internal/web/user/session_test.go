@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package user
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestSessionStore(t *testing.T, config SessionConfig) (w *Worker, s *sessionStore, userID int64) {
+	t.Helper()
+	w = newTestWorker(t)
+	if err := w.createUser("alice", "pw", "alice", RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	user, err := w.queryUserByUsername("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = newSessionStore(w.db, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w, s, user.UserID
+}
+
+func TestSessionExpiry(t *testing.T) {
+	config := SessionConfig{IdleTimeout: time.Hour, AbsoluteTimeout: 4 * time.Hour}
+	tests := []struct {
+		name     string
+		created  time.Duration
+		accessed time.Duration
+		ok       bool
+	}{
+		{"fresh", 0, 0, true},
+		{"idle within timeout", 2 * time.Hour, 30 * time.Minute, true},
+		{"idle timeout", 2 * time.Hour, 2 * time.Hour, false},
+		{"active within absolute timeout", 3 * time.Hour, 0, true},
+		{"absolute timeout", 5 * time.Hour, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w, s, userID := newTestSessionStore(t, config)
+			token, err := s.Add(userID, "127.0.0.1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := time.Now()
+			_, err = w.db.Exec(`update user_session set created=?,accessed=? where token=?`,
+				now.Add(-test.created).Unix(), now.Add(-test.accessed).Unix(), hashToken(token))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			user, ok := s.Get(token)
+			if ok != test.ok {
+				t.Fatalf("ok = %v, want %v", ok, test.ok)
+			}
+			if ok && user.Username != "alice" {
+				t.Errorf("username = %q, want alice", user.Username)
+			}
+			// 过期的会话在查询时被删除
+			if _, ok := s.Get(token); ok != test.ok {
+				t.Errorf("second get: ok = %v, want %v", ok, test.ok)
+			}
+		})
+	}
+}
+
+func TestSessionTouch(t *testing.T) {
+	w, s, userID := newTestSessionStore(t, SessionConfig{IdleTimeout: time.Hour})
+	token, err := s.Add(userID, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessed := time.Now().Add(-50 * time.Minute).Unix()
+	if _, err = w.db.Exec(`update user_session set accessed=?`, accessed); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s.Get(token); !ok {
+		t.Fatal("session expired before the idle timeout")
+	}
+	sessions, err := s.ListByUserID(userID)
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("sessions = %v, err = %v", sessions, err)
+	}
+	if sessions[0].Accessed <= accessed {
+		t.Errorf("accessed = %d is not refreshed", sessions[0].Accessed)
+	}
+}
+
+func TestSessionEviction(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		sessions int
+		// 按创建顺序,被淘汰的会话为 false
+		alive []bool
+	}{
+		{"within capacity", 3, 2, []bool{true, true}},
+		{"at capacity", 2, 2, []bool{true, true}},
+		{"over capacity", 2, 4, []bool{false, false, true, true}},
+		{"single session", 1, 3, []bool{false, false, true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, s, userID := newTestSessionStore(t, SessionConfig{Capacity: test.capacity})
+			tokens := []string{}
+			for i := 0; i < test.sessions; i++ {
+				token, err := s.Add(userID, "127.0.0.1")
+				if err != nil {
+					t.Fatal(err)
+				}
+				tokens = append(tokens, token)
+			}
+			for i, token := range tokens {
+				if _, ok := s.Get(token); ok != test.alive[i] {
+					t.Errorf("session %d: alive = %v, want %v", i, ok, test.alive[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSessionRemoveOthers(t *testing.T) {
+	_, s, userID := newTestSessionStore(t, SessionConfig{})
+	current, err := s.Add(userID, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.Add(userID, "127.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.RemoveOthers(userID, current); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Get(current); !ok {
+		t.Error("current session is removed")
+	}
+	if _, ok := s.Get(other); ok {
+		t.Error("other session is kept")
+	}
+}
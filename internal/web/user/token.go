@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package user
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	sqlCreateTokenTable     = `create table if not exists api_token(id integer primary key autoincrement, name text not null, token text not null unique, user_id integer not null, permissions text not null, created integer not null, expires integer not null, last_used integer not null)`
+	sqlInsertToken          = `insert into api_token(name,token,user_id,permissions,created,expires,last_used) values(?,?,?,?,?,?,0)`
+	sqlQueryTokenByToken    = `select api_token.id,api_token.permissions,api_token.expires,api_token.last_used,user.id,user.username,user.alias,ifnull(user.role,''),ifnull(role.permissions,''),ifnull(user.language,'') from api_token join user on api_token.user_id=user.id left join role on user.role=role.name where api_token.token=?`
+	sqlQueryTokensByUserID  = `select id,name,user_id,permissions,created,expires,last_used from api_token where user_id=?`
+	sqlQueryAllTokens       = `select id,name,user_id,permissions,created,expires,last_used from api_token`
+	sqlUpdateTokenLastUsed  = `update api_token set last_used=? where id=?`
+	sqlDeleteTokenByID      = `delete from api_token where id=?`
+	sqlDeleteTokensByUserID = `delete from api_token where user_id=?`
+)
+
+const (
+	apiTokenPrefix = "uranus_"
+	// 最后使用时间的更新间隔,避免每个请求都写数据库
+	apiTokenTouchInterval     = time.Minute
+	authorizationBearerPrefix = "Bearer "
+)
+
+type Token struct {
+	ID          int64    `json:"id"`
+	Name        string   `json:"name"`
+	UserID      int64    `json:"userID"`
+	Permissions []string `json:"permissions"`
+	Created     int64    `json:"created"`
+	Expires     int64    `json:"expires"`
+	LastUsed    int64    `json:"lastUsed"`
+}
+
+// bearerToken 从 Authorization 请求头中取出 API Token
+func bearerToken(authorization string) (token string, ok bool) {
+	if !strings.HasPrefix(authorization, authorizationBearerPrefix) {
+		return
+	}
+	token = strings.TrimSpace(strings.TrimPrefix(authorization, authorizationBearerPrefix))
+	ok = token != ""
+	return
+}
+
+// intersectPermissions Token 的权限不能超过所属用户角色的权限
+func intersectPermissions(scope, role []string) (permissions []string) {
+	permissions = []string{}
+	for _, p := range scope {
+		for _, r := range role {
+			if p == r {
+				permissions = append(permissions, p)
+				break
+			}
+		}
+	}
+	return
+}
+
+func (w *Worker) initTokenTable() (err error) {
+	_, err = w.db.Exec(sqlCreateTokenTable)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	return
+}
+
+// createToken 生成新的 API Token,明文只在创建时返回一次
+func (w *Worker) createToken(userID int64, name string, permissions []string, expires int64) (token string, err error) {
+	if err = validatePermissions(permissions); err != nil {
+		return
+	}
+
+	buffer := make([]byte, 32)
+	if _, err = rand.Read(buffer); err != nil {
+		return
+	}
+	token = apiTokenPrefix + hex.EncodeToString(buffer)
+
+	stmt, err := w.db.Prepare(sqlInsertToken)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(name, hashToken(token), userID, joinPermissions(permissions), time.Now().Unix(), expires)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	return
+}
+
+// GetByAPIToken 查询 API Token 对应的用户,用户权限为 Token 权限与角色权限的交集
+func (s *sessionStore) GetByAPIToken(token string) (user User, ok bool) {
+	id, expires, lastUsed := int64(0), int64(0), int64(0)
+	scope, permissions := "", ""
+	err := s.db.QueryRow(sqlQueryTokenByToken, hashToken(token)).Scan(&id, &scope, &expires, &lastUsed,
+		&user.UserID, &user.Username, &user.AliasName, &user.Role, &permissions, &user.Language)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logrus.Error(err)
+		}
+		return
+	}
+
+	now := time.Now()
+	if expires != 0 && now.Unix() > expires {
+		return
+	}
+	user.Permissions = intersectPermissions(splitPermissions(scope), splitPermissions(permissions))
+
+	if now.Sub(time.Unix(lastUsed, 0)) > apiTokenTouchInterval {
+		if _, err = s.db.Exec(sqlUpdateTokenLastUsed, now.Unix(), id); err != nil {
+			logrus.Error(err)
+		}
+	}
+	ok = true
+	return
+}
+
+func (w *Worker) queryTokens(userID int64) (tokens []Token, err error) {
+	query, args := sqlQueryAllTokens, []interface{}{}
+	if userID != 0 {
+		query, args = sqlQueryTokensByUserID, []interface{}{userID}
+	}
+
+	stmt, err := w.db.Prepare(query)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		token := Token{}
+		permissions := ""
+		err = rows.Scan(&token.ID, &token.Name, &token.UserID, &permissions, &token.Created, &token.Expires, &token.LastUsed)
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+		token.Permissions = splitPermissions(permissions)
+		tokens = append(tokens, token)
+	}
+	err = rows.Err()
+	if err != nil {
+		logrus.Error(err)
+	}
+	return
+}
+
+func (w *Worker) deleteTokenByID(id int64) bool {
+	stmt, err := w.db.Prepare(sqlDeleteTokenByID)
+	if err != nil {
+		logrus.Error(err)
+		return false
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(id)
+	if err != nil {
+		logrus.Error(err)
+		return false
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		logrus.Error(err)
+		return false
+	}
+	return affected == 1
+}
+
+func (w *Worker) deleteTokensByUserID(userID int64) (err error) {
+	stmt, err := w.db.Prepare(sqlDeleteTokensByUserID)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(userID)
+	if err != nil {
+		logrus.Error(err)
+	}
+	return
+}
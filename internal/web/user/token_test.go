@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lanthora/uranus/internal/web/render"
+)
+
+func TestIntersectPermissions(t *testing.T) {
+	tests := []struct {
+		name  string
+		scope []string
+		role  []string
+		want  []string
+	}{
+		{"subset", []string{"process:read"}, builtinRoles[RoleViewer], []string{"process:read"}},
+		{"beyond role", []string{"process:read", "process:write"}, builtinRoles[RoleViewer], []string{"process:read"}},
+		{"disjoint", []string{"admin:write"}, builtinRoles[RoleOperator], []string{}},
+		{"empty scope", []string{}, builtinRoles[RoleAdmin], []string{}},
+		{"empty role", []string{"file:read"}, []string{}, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := intersectPermissions(test.scope, test.role); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		authorization string
+		token         string
+		ok            bool
+	}{
+		{"Bearer uranus_abc", "uranus_abc", true},
+		{"Bearer  uranus_abc ", "uranus_abc", true},
+		{"Bearer ", "", false},
+		{"Basic dXNlcjpwdw==", "", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.authorization, func(t *testing.T) {
+			token, ok := bearerToken(test.authorization)
+			if token != test.token || ok != test.ok {
+				t.Errorf("got %q, %v, want %q, %v", token, ok, test.token, test.ok)
+			}
+		})
+	}
+}
+
+func TestGetByAPIToken(t *testing.T) {
+	tests := []struct {
+		name        string
+		scope       []string
+		expires     time.Duration
+		ok          bool
+		permissions []string
+	}{
+		{"never expires", []string{"process:read", "process:write"}, 0, true, []string{"process:read"}},
+		{"not expired", []string{"file:read"}, time.Hour, true, []string{"file:read"}},
+		{"expired", []string{"file:read"}, -time.Hour, false, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w, s, userID := newTestSessionStore(t, SessionConfig{})
+			expires := int64(0)
+			if test.expires != 0 {
+				expires = time.Now().Add(test.expires).Unix()
+			}
+			token, err := w.createToken(userID, "notify", test.scope, expires)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			user, ok := s.GetByAPIToken(token)
+			if ok != test.ok {
+				t.Fatalf("ok = %v, want %v", ok, test.ok)
+			}
+			if ok && !reflect.DeepEqual(user.Permissions, test.permissions) {
+				t.Errorf("permissions = %v, want %v", user.Permissions, test.permissions)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareToken(t *testing.T) {
+	w, s, userID := newTestSessionStore(t, SessionConfig{})
+	token, err := w.createToken(userID, "notify", []string{"process:read"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := loggedUser
+	loggedUser = s
+	defer func() { loggedUser = previous }()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(AuthMiddleware())
+	paths := []string{"/auth/showCurrentUserInfo", "/auth/enrollTOTP", "/auth/updateLanguage", "/process/listEvents", "/process/updateWorkMode"}
+	for _, path := range paths {
+		router.POST(path, func(context *gin.Context) { render.Status(context, render.StatusSuccess) })
+	}
+
+	// API Token 只能访问只读的 /auth/showCurrentUserInfo 和权限范围内的接口
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/auth/showCurrentUserInfo", render.StatusSuccess},
+		{"/auth/enrollTOTP", render.StatusUserPermissionDenied},
+		{"/auth/updateLanguage", render.StatusUserPermissionDenied},
+		{"/process/listEvents", render.StatusSuccess},
+		{"/process/updateWorkMode", render.StatusUserPermissionDenied},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, test.path, nil)
+			request.Header.Set("Authorization", "Bearer "+token)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+
+			response := struct {
+				Status int `json:"status"`
+			}{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Status != test.status {
+				t.Errorf("status = %d, want %d", response.Status, test.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package user
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	sqlCreateTOTPTable          = `create table if not exists user_totp(user_id integer primary key, secret text not null, enabled integer not null, required integer not null, last_step integer not null)`
+	sqlCreateRecoveryCodeTable  = `create table if not exists user_recovery_code(id integer primary key autoincrement, user_id integer not null, code text not null)`
+	sqlQueryTOTPByUserID        = `select secret,enabled,required,last_step from user_totp where user_id=?`
+	sqlUpsertTOTPSecret         = `insert into user_totp(user_id,secret,enabled,required,last_step) values(?,?,0,0,0) on conflict(user_id) do update set secret=excluded.secret,enabled=0,last_step=0`
+	sqlUpsertTOTPRequired       = `insert into user_totp(user_id,secret,enabled,required,last_step) values(?,'',0,?,0) on conflict(user_id) do update set required=excluded.required`
+	sqlEnableTOTP               = `update user_totp set enabled=1,last_step=? where user_id=? and secret!=''`
+	sqlUpdateTOTPLastStep       = `update user_totp set last_step=? where user_id=? and last_step<?`
+	sqlResetTOTP                = `update user_totp set secret='',enabled=0,last_step=0 where user_id=?`
+	sqlDeleteTOTPByUserID       = `delete from user_totp where user_id=?`
+	sqlInsertRecoveryCode       = `insert into user_recovery_code(user_id,code) values(?,?)`
+	sqlDeleteRecoveryCode       = `delete from user_recovery_code where user_id=? and code=?`
+	sqlDeleteRecoveryCodeByUser = `delete from user_recovery_code where user_id=?`
+)
+
+// RFC 6238 默认参数,与常见的验证器应用兼容
+const (
+	totpIssuer    = "uranus"
+	totpPeriod    = 30
+	totpDigits    = 6
+	totpModulus   = 1000000
+	totpSecretLen = 20
+	// 允许前后各一个周期的时钟偏差
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+	recoveryCodeLen   = 5
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type TOTP struct {
+	Secret   string
+	Enabled  bool
+	Required bool
+	LastStep int64
+}
+
+func generateTOTPSecret() (secret string, err error) {
+	buffer := make([]byte, totpSecretLen)
+	if _, err = rand.Read(buffer); err != nil {
+		return
+	}
+	secret = totpEncoding.EncodeToString(buffer)
+	return
+}
+
+// totpURI 生成验证器应用使用的 otpauth 链接,前端可以据此生成二维码
+func totpURI(username, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(totpIssuer + ":" + username)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+func totpCode(secret string, step int64) (code string, err error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return
+	}
+
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	code = fmt.Sprintf("%0*d", totpDigits, value%totpModulus)
+	return
+}
+
+// verifyTOTP 校验动态口令,已经使用过的周期不能再次使用,防止口令被重放
+func verifyTOTP(secret, code string, lastStep int64) (step int64, ok bool) {
+	current := time.Now().Unix() / totpPeriod
+	for step = current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			ok = true
+			return
+		}
+	}
+	return
+}
+
+func (w *Worker) initTOTPTable() (err error) {
+	_, err = w.db.Exec(sqlCreateTOTPTable)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	_, err = w.db.Exec(sqlCreateRecoveryCodeTable)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	return
+}
+
+// queryTOTPByUserID 没有配置过动态口令的用户返回零值
+func (w *Worker) queryTOTPByUserID(userID int64) (totp TOTP, err error) {
+	stmt, err := w.db.Prepare(sqlQueryTOTPByUserID)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRow(userID).Scan(&totp.Secret, &totp.Enabled, &totp.Required, &totp.LastStep)
+	if err == sql.ErrNoRows {
+		err = nil
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	return
+}
+
+func (w *Worker) updateTOTPSecretByUserID(userID int64, secret string) (err error) {
+	_, err = w.db.Exec(sqlUpsertTOTPSecret, userID, secret)
+	if err != nil {
+		logrus.Error(err)
+	}
+	return
+}
+
+func (w *Worker) updateTOTPRequiredByUserID(userID int64, required bool) (err error) {
+	_, err = w.db.Exec(sqlUpsertTOTPRequired, userID, required)
+	if err != nil {
+		logrus.Error(err)
+	}
+	return
+}
+
+func (w *Worker) enableTOTPByUserID(userID, step int64) bool {
+	result, err := w.db.Exec(sqlEnableTOTP, step, userID)
+	if err != nil {
+		logrus.Error(err)
+		return false
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		logrus.Error(err)
+		return false
+	}
+	return affected == 1
+}
+
+// resetTOTPByUserID 关闭动态口令并删除恢复码,是否强制启用的设置保持不变
+func (w *Worker) resetTOTPByUserID(userID int64) (err error) {
+	if _, err = w.db.Exec(sqlResetTOTP, userID); err != nil {
+		logrus.Error(err)
+		return
+	}
+	if _, err = w.db.Exec(sqlDeleteRecoveryCodeByUser, userID); err != nil {
+		logrus.Error(err)
+		return
+	}
+	return
+}
+
+func (w *Worker) deleteTOTPByUserID(userID int64) (err error) {
+	if _, err = w.db.Exec(sqlDeleteTOTPByUserID, userID); err != nil {
+		logrus.Error(err)
+		return
+	}
+	if _, err = w.db.Exec(sqlDeleteRecoveryCodeByUser, userID); err != nil {
+		logrus.Error(err)
+		return
+	}
+	return
+}
+
+// createRecoveryCodes 生成新的恢复码并替换旧的恢复码,明文只在生成时返回一次
+func (w *Worker) createRecoveryCodes(userID int64) (codes []string, err error) {
+	tx, err := w.db.Begin()
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(sqlDeleteRecoveryCodeByUser, userID); err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	stmt, err := tx.Prepare(sqlInsertRecoveryCode)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer stmt.Close()
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		buffer := make([]byte, recoveryCodeLen)
+		if _, err = rand.Read(buffer); err != nil {
+			return
+		}
+		code := hex.EncodeToString(buffer)
+		if _, err = stmt.Exec(userID, hashToken(code)); err != nil {
+			logrus.Error(err)
+			return
+		}
+		codes = append(codes, code)
+	}
+
+	if err = tx.Commit(); err != nil {
+		logrus.Error(err)
+		return
+	}
+	return
+}
+
+func (w *Worker) consumeRecoveryCode(userID int64, code string) bool {
+	result, err := w.db.Exec(sqlDeleteRecoveryCode, userID, hashToken(strings.ToLower(code)))
+	if err != nil {
+		logrus.Error(err)
+		return false
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		logrus.Error(err)
+		return false
+	}
+	return affected == 1
+}
+
+// verifySecondFactor 校验动态口令或恢复码,未启用动态口令的用户直接通过
+func (w *Worker) verifySecondFactor(userID int64, code string) (ok bool, err error) {
+	totp, err := w.queryTOTPByUserID(userID)
+	if err != nil {
+		return
+	}
+	if !totp.Enabled {
+		ok = true
+		return
+	}
+	if code == "" {
+		return
+	}
+
+	if step, valid := verifyTOTP(totp.Secret, code, totp.LastStep); valid {
+		result, err := w.db.Exec(sqlUpdateTOTPLastStep, step, userID, step)
+		if err != nil {
+			logrus.Error(err)
+			return false, err
+		}
+		// 并发请求使用同一个口令时只有一个能成功
+		affected, err := result.RowsAffected()
+		if err != nil {
+			logrus.Error(err)
+			return false, err
+		}
+		ok = affected == 1
+		return ok, nil
+	}
+
+	ok = w.consumeRecoveryCode(userID, code)
+	return
+}
@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package user
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// currentStep 返回当前周期,临近周期切换时等待到下一个周期,避免测试期间周期改变
+func currentStep() int64 {
+	if time.Now().Unix()%totpPeriod == totpPeriod-1 {
+		time.Sleep(time.Second)
+	}
+	return time.Now().Unix() / totpPeriod
+}
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 附录 B 中 SHA1 的测试向量,取后 6 位
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, test := range tests {
+		code, err := totpCode(secret, test.time/totpPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != test.code {
+			t.Errorf("time %d: got %s, want %s", test.time, code, test.code)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := currentStep()
+
+	tests := []struct {
+		name     string
+		step     int64
+		lastStep int64
+		ok       bool
+	}{
+		{"current", current, 0, true},
+		{"previous", current - 1, 0, true},
+		{"next", current + 1, 0, true},
+		{"too old", current - 2, 0, false},
+		{"too new", current + 2, 0, false},
+		{"replay current", current, current, false},
+		{"replay previous", current - 1, current - 1, false},
+		{"after later use", current - 1, current, false},
+		{"next after use", current + 1, current, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, err := totpCode(secret, test.step)
+			if err != nil {
+				t.Fatal(err)
+			}
+			step, ok := verifyTOTP(secret, code, test.lastStep)
+			if ok != test.ok {
+				t.Fatalf("ok = %v, want %v", ok, test.ok)
+			}
+			if ok && step != test.step {
+				t.Errorf("step = %d, want %d", step, test.step)
+			}
+		})
+	}
+}
+
+func TestVerifySecondFactor(t *testing.T) {
+	w, _, userID := newTestSessionStore(t, SessionConfig{})
+	if ok, err := w.verifySecondFactor(userID, ""); !ok || err != nil {
+		t.Fatalf("without TOTP: ok = %v, err = %v", ok, err)
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.updateTOTPSecretByUserID(userID, secret); err != nil {
+		t.Fatal(err)
+	}
+	if !w.enableTOTPByUserID(userID, 0) {
+		t.Fatal("enable TOTP failed")
+	}
+	codes, err := w.createRecoveryCodes(userID)
+	if err != nil || len(codes) != recoveryCodeCount {
+		t.Fatalf("recovery codes = %v, err = %v", codes, err)
+	}
+	code, err := totpCode(secret, currentStep())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+
+	// 同一个口令和恢复码都只能使用一次
+	tests := []struct {
+		name string
+		code string
+		ok   bool
+	}{
+		{"empty", "", false},
+		{"wrong", wrong, false},
+		{"totp", code, true},
+		{"totp replay", code, false},
+		{"recovery code", codes[0], true},
+		{"recovery code reuse", codes[0], false},
+		{"recovery code upper case", strings.ToUpper(codes[1]), true},
+		{"recovery code upper case reuse", codes[1], false},
+		{"unknown recovery code", "0123456789", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ok, err := w.verifySecondFactor(userID, test.code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != test.ok {
+				t.Errorf("ok = %v, want %v", ok, test.ok)
+			}
+		})
+	}
+
+	// 重新生成后旧的恢复码失效
+	if _, err = w.createRecoveryCodes(userID); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := w.verifySecondFactor(userID, codes[2]); ok {
+		t.Error("old recovery code still works after regeneration")
+	}
+}
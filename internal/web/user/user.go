@@ -4,39 +4,42 @@ package user
 import (
 	"database/sql"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/gobwas/glob"
-	"github.com/google/uuid"
-	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/lanthora/uranus/internal/i18n"
 	"github.com/lanthora/uranus/internal/web/render"
 )
 
-var loggedUser *lru.Cache[string, User]
+var loggedUser *sessionStore
+
+// CurrentUserKey 是通过认证的用户在 gin.Context 中的键
+const CurrentUserKey = "user.current"
 
 type Worker struct {
 	db *sql.DB
 
-	onlineUserMax int
+	limiter *loginLimiter
+	setup   setupToken
 }
 
-func Init(router *gin.Engine, db *sql.DB) (err error) {
-	onlineUserMax := 10
-	loggedUser, err = lru.New[string, User](onlineUserMax)
-	if err != nil {
-		return
-	}
-
+func Init(router *gin.Engine, db *sql.DB, sessionConfig SessionConfig, loginConfig LoginConfig) (err error) {
 	w := &Worker{
-		onlineUserMax: onlineUserMax,
-		db:            db,
+		db:      db,
+		limiter: newLoginLimiter(loginConfig),
 	}
 
 	authGroup := router.Group("/auth")
 	authGroup.Use(AuthMiddleware())
 	authGroup.POST("/login", w.login)
+	authGroup.POST("/setup", w.setupAdmin)
 	authGroup.POST("/showCurrentUserInfo", w.showCurrentUserInfo)
 	authGroup.POST("/logout", w.logout)
+	authGroup.POST("/changePassword", w.changePassword)
+	authGroup.POST("/updateLanguage", w.updateLanguage)
+	authGroup.POST("/enrollTOTP", w.enrollTOTP)
+	authGroup.POST("/enableTOTP", w.enableTOTP)
+	authGroup.POST("/disableTOTP", w.disableTOTP)
 
 	adminGroup := router.Group("/admin")
 	adminGroup.Use(AuthMiddleware())
@@ -44,50 +47,105 @@ func Init(router *gin.Engine, db *sql.DB) (err error) {
 	adminGroup.POST("/deleteUser", w.deleteUser)
 	adminGroup.POST("/updateUserInfo", w.updateUserInfo)
 	adminGroup.POST("/listAllUsers", w.listAllUsers)
+	adminGroup.POST("/listUserSessions", w.listUserSessions)
+	adminGroup.POST("/deleteUserSession", w.deleteUserSession)
+	adminGroup.POST("/addRole", w.addRole)
+	adminGroup.POST("/deleteRole", w.deleteRole)
+	adminGroup.POST("/updateRole", w.updateRole)
+	adminGroup.POST("/listAllRoles", w.listAllRoles)
+	adminGroup.POST("/addToken", w.addToken)
+	adminGroup.POST("/deleteToken", w.deleteToken)
+	adminGroup.POST("/listTokens", w.listTokens)
+	adminGroup.POST("/listLoginFailures", w.listLoginFailures)
+	adminGroup.POST("/updateTOTPRequired", w.updateTOTPRequired)
+	adminGroup.POST("/resetTOTP", w.resetTOTP)
 
 	if err = w.initUserTable(); err != nil {
 		return
 	}
+
+	if err = w.initTOTPTable(); err != nil {
+		return
+	}
+
+	if err = w.initTokenTable(); err != nil {
+		return
+	}
+
+	if err = w.initLoginFailureTable(); err != nil {
+		return
+	}
+
+	if w.noUser() {
+		w.setup.Generate()
+	}
+
+	loggedUser, err = newSessionStore(db, sessionConfig)
+	if err != nil {
+		return
+	}
 	return
 
 }
 
 type User struct {
-	UserID      int64  `json:"userID"`
-	Username    string `json:"username"`
-	AliasName   string `json:"aliasName"`
-	Permissions string `json:"permissions"`
+	UserID       int64    `json:"userID"`
+	Username     string   `json:"username"`
+	AliasName    string   `json:"aliasName"`
+	Role         string   `json:"role"`
+	Permissions  []string `json:"permissions"`
+	TOTPEnabled  bool     `json:"totpEnabled"`
+	TOTPRequired bool     `json:"totpRequired"`
+	Language     string   `json:"language"`
 }
 
 func AuthMiddleware() gin.HandlerFunc {
 	return func(context *gin.Context) {
-		// 不校验登录接口
-		if context.Request.URL.Path == "/auth/login" {
+		// 不校验登录和初始化接口
+		if context.Request.URL.Path == "/auth/login" || context.Request.URL.Path == "/auth/setup" {
 			context.Next()
 			return
 		}
-		session, err := context.Cookie("session")
-		if err != nil {
+		user, ok, session := User{}, false, ""
+		token, isBearer := bearerToken(context.GetHeader("Authorization"))
+		if isBearer {
+			// API Token 只能查询当前用户信息,不能修改账号设置
+			if strings.HasPrefix(context.Request.URL.Path, "/auth/") && context.Request.URL.Path != "/auth/showCurrentUserInfo" {
+				render.Status(context, render.StatusUserPermissionDenied)
+				context.Abort()
+				return
+			}
+			user, ok = loggedUser.GetByAPIToken(token)
+		} else if cookie, err := context.Cookie("session"); err == nil {
+			session = cookie
+			user, ok = loggedUser.Get(session)
+		}
+		if !ok {
 			render.Status(context, render.StatusUserNotLoggedIn)
 			context.Abort()
 			return
 		}
 
-		user, ok := loggedUser.Get(session)
-		if !ok {
-			render.Status(context, render.StatusUserNotLoggedIn)
+		if session != "" && !checkCSRF(context, session) {
+			render.Status(context, render.StatusUserCSRFTokenInvalid)
 			context.Abort()
 			return
 		}
+		context.Set(CurrentUserKey, user)
+		if user.Language != "" {
+			context.Set(render.LanguageKey, user.Language)
+		}
 
-		g, err := glob.Compile(user.Permissions)
-		if err != nil {
+		permission, required := RequiredPermission(context.Request.URL.Path)
+		if required && !user.HasPermission(permission) {
 			render.Status(context, render.StatusUserPermissionDenied)
 			context.Abort()
 			return
 		}
-		if !g.Match(context.Request.URL.Path) {
-			render.Status(context, render.StatusUserPermissionDenied)
+
+		// 被要求启用动态口令的用户完成绑定前只能访问 /auth 下的接口
+		if required && user.TOTPRequired && !user.TOTPEnabled {
+			render.Status(context, render.StatusUserTOTPNotEnrolled)
 			context.Abort()
 			return
 		}
@@ -99,58 +157,113 @@ func (w *Worker) login(context *gin.Context) {
 	request := struct {
 		Username string `json:"username" binding:"required"`
 		Password string `json:"password" binding:"required"`
+		Code     string `json:"code"`
 	}{}
 	deleteSession(context)
 
 	if err := context.ShouldBindJSON(&request); err != nil {
-		render.Status(context, render.StatusInvalidArgument)
+		render.InvalidArgument(context, err)
 		return
 	}
 
-	if w.noUser() {
-		w.createUser(request.Username, request.Password, request.Username, `{*}`)
+	keys := loginLimiterKeys(request.Username, context.ClientIP())
+	if !w.limiter.Allow(keys...) {
+		render.Status(context, render.StatusUserLoginLimited)
+		return
 	}
 
 	ok, err := w.checkUserPassword(request.Username, request.Password)
-	if err == sql.ErrNoRows {
+	if err != nil && err != sql.ErrNoRows {
+		render.Status(context, render.StatusUnknownError)
+		return
+	}
+
+	if !ok {
+		w.insertLoginFailure(request.Username, context.ClientIP())
 		render.Status(context, render.StatusUserLoginFaild)
 		return
 	}
 
+	response, err := w.queryUserByUsername(request.Username)
+	if err != nil {
+		render.Status(context, render.StatusUnknownError)
+		return
+	}
+
+	if response.TOTPEnabled && request.Code == "" {
+		w.limiter.Release(keys...)
+		render.Status(context, render.StatusUserTOTPRequired)
+		return
+	}
+
+	ok, err = w.verifySecondFactor(response.UserID, request.Code)
 	if err != nil {
 		render.Status(context, render.StatusUnknownError)
 		return
 	}
 
 	if !ok {
-		render.Status(context, render.StatusUserLoginFaild)
+		w.insertLoginFailure(request.Username, context.ClientIP())
+		render.Status(context, render.StatusUserTOTPInvalid)
 		return
 	}
+	w.limiter.Succeed(keys...)
 
-	response, err := w.queryUserByUsername(request.Username)
+	session, err := loggedUser.Add(response.UserID, context.ClientIP())
 	if err != nil {
 		render.Status(context, render.StatusUnknownError)
 		return
 	}
-	session := uuid.NewString()
-	loggedUser.Add(session, response)
 	updateSession(context, session)
 	render.Status(context, render.StatusSuccess)
 }
 
-func (w *Worker) showCurrentUserInfo(context *gin.Context) {
-	session, err := context.Cookie("session")
-	if err != nil {
-		render.Status(context, render.StatusUserNotLoggedIn)
+// setupAdmin 使用启动日志中的一次性令牌创建第一个管理员
+func (w *Worker) setupAdmin(context *gin.Context) {
+	request := struct {
+		Token    string `json:"token" binding:"required"`
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}{}
+
+	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
 		return
 	}
 
-	current, ok := loggedUser.Get(session)
-	if !ok {
-		render.Status(context, render.StatusUserNotLoggedIn)
+	// 初始化时还没有用户名,只按 IP 限制
+	keys := []string{"ip:" + context.ClientIP()}
+	if !w.limiter.Allow(keys...) {
+		render.Status(context, render.StatusUserLoginLimited)
 		return
 	}
-	updateSession(context, session)
+
+	noUser := w.noUser()
+	// 用户在运行期间被全部删除时重新生成令牌
+	if noUser && w.setup.Empty() {
+		w.setup.Generate()
+	}
+
+	if !noUser || !w.setup.Consume(request.Token) {
+		render.Status(context, render.StatusUserSetupFailed)
+		return
+	}
+
+	if err := w.createUser(request.Username, request.Password, request.Username, RoleAdmin); err != nil {
+		w.setup.Restore(request.Token)
+		render.Status(context, render.StatusUserCreateUserFailed)
+		return
+	}
+	w.limiter.Succeed(keys...)
+	render.Status(context, render.StatusSuccess)
+}
+
+func (w *Worker) showCurrentUserInfo(context *gin.Context) {
+	current := context.MustGet(CurrentUserKey).(User)
+	// 使用 API Token 访问时没有会话
+	if session, err := context.Cookie("session"); err == nil {
+		updateSession(context, session)
+	}
 	render.Success(context, current)
 }
 
@@ -162,20 +275,98 @@ func (w *Worker) logout(context *gin.Context) {
 	render.Status(context, render.StatusSuccess)
 }
 
+// changePassword 修改当前用户的密码,修改成功后用户的其他会话失效
+func (w *Worker) changePassword(context *gin.Context) {
+	request := struct {
+		OldPassword string `json:"oldPassword" binding:"required"`
+		NewPassword string `json:"newPassword" binding:"required"`
+	}{}
+
+	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+
+	session, err := context.Cookie("session")
+	if err != nil {
+		render.Status(context, render.StatusUserNotLoggedIn)
+		return
+	}
+	current := context.MustGet(CurrentUserKey).(User)
+
+	keys := loginLimiterKeys(current.Username, context.ClientIP())
+	if !w.limiter.Allow(keys...) {
+		render.Status(context, render.StatusUserLoginLimited)
+		return
+	}
+
+	ok, err := w.checkUserPassword(current.Username, request.OldPassword)
+	if err != nil || !ok {
+		render.Status(context, render.StatusUserWrongPassword)
+		return
+	}
+	w.limiter.Succeed(keys...)
+
+	if ok := w.updateUserInfoByID(current.UserID, nil, &request.NewPassword, nil, nil); !ok {
+		render.Status(context, render.StatusUserUpdateUserFailed)
+		return
+	}
+	loggedUser.RemoveOthers(current.UserID, session)
+	render.Status(context, render.StatusSuccess)
+}
+
+// updateLanguage 设置当前用户的提示信息语言,设置为空时根据 Accept-Language 选择
+func (w *Worker) updateLanguage(context *gin.Context) {
+	request := struct {
+		Language string `json:"language"`
+	}{}
+
+	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+
+	language := ""
+	if request.Language != "" {
+		normalized, ok := i18n.Normalize(request.Language)
+		if !ok {
+			render.Status(context, render.StatusInvalidArgument)
+			return
+		}
+		language = normalized
+	}
+
+	current := context.MustGet(CurrentUserKey).(User)
+	if ok := w.updateLanguageByID(current.UserID, language); !ok {
+		render.Status(context, render.StatusUserUpdateUserFailed)
+		return
+	}
+
+	if language != "" {
+		context.Set(render.LanguageKey, language)
+	}
+	render.Status(context, render.StatusSuccess)
+}
+
 func (w *Worker) addUser(context *gin.Context) {
 	request := struct {
-		Username    string `json:"username" binding:"required"`
-		Password    string `json:"password" binding:"required"`
-		AliasName   string `json:"aliasName" binding:"required"`
-		Permissions string `json:"permissions" binding:"required"`
+		Username  string `json:"username" binding:"required"`
+		Password  string `json:"password" binding:"required"`
+		AliasName string `json:"aliasName" binding:"required"`
+		Role      string `json:"role" binding:"required"`
 	}{}
 
 	if err := context.ShouldBindJSON(&request); err != nil {
-		render.Status(context, render.StatusInvalidArgument)
+		render.InvalidArgument(context, err)
 		return
 	}
 
-	if err := w.createUser(request.Username, request.Password, request.AliasName, request.Permissions); err != nil {
+	if _, err := w.queryRoleByName(request.Role); err != nil {
+		render.Status(context, render.StatusUserRoleNotExist)
+		return
+	}
+
+	if err := w.createUser(request.Username, request.Password, request.AliasName, request.Role); err != nil {
 		render.Status(context, render.StatusUserCreateUserFailed)
 		return
 	}
@@ -197,43 +388,426 @@ func (w *Worker) deleteUser(context *gin.Context) {
 	}{}
 
 	if err := context.ShouldBindJSON(&request); err != nil {
-		render.Status(context, render.StatusInvalidArgument)
+		render.InvalidArgument(context, err)
 		return
 	}
 	if ok := w.deleteUserByID(request.UserID); !ok {
 		render.Status(context, render.StatusUserDeleteUserFailed)
 		return
 	}
+	loggedUser.RemoveByUserID(request.UserID)
+	w.deleteTokensByUserID(request.UserID)
+	w.deleteTOTPByUserID(request.UserID)
+	if w.noUser() {
+		w.setup.Generate()
+	}
 	render.Status(context, render.StatusSuccess)
 }
 
+// updateUserInfo 省略的字段保持不变
 func (w *Worker) updateUserInfo(context *gin.Context) {
 	request := struct {
-		UserID      int64  `json:"userID" binding:"number"`
-		Username    string `json:"username" binding:"required"`
-		Password    string `json:"password" binding:"required"`
-		AliasName   string `json:"aliasName" binding:"required"`
-		Permissions string `json:"permissions" binding:"required"`
+		UserID    int64   `json:"userID" binding:"number"`
+		Username  *string `json:"username" binding:"omitempty,min=1"`
+		Password  *string `json:"password" binding:"omitempty,min=1"`
+		AliasName *string `json:"aliasName" binding:"omitempty,min=1"`
+		Role      *string `json:"role"`
 	}{}
 
 	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+
+	if request.Username == nil && request.Password == nil && request.AliasName == nil && request.Role == nil {
 		render.Status(context, render.StatusInvalidArgument)
 		return
 	}
 
-	if ok := w.updateUserInfoByID(request.UserID, request.Username, request.Password, request.AliasName, request.Permissions); !ok {
+	if request.Role != nil {
+		if _, err := w.queryRoleByName(*request.Role); err != nil {
+			render.Status(context, render.StatusUserRoleNotExist)
+			return
+		}
+	}
+
+	if ok := w.updateUserInfoByID(request.UserID, request.Username, request.Password, request.AliasName, request.Role); !ok {
 		render.Status(context, render.StatusUserUpdateUserFailed)
 		return
 	}
+
+	// 管理员重置密码后用户需要重新登录
+	if request.Password != nil {
+		loggedUser.RemoveByUserID(request.UserID)
+	}
+	render.Status(context, render.StatusSuccess)
+}
+
+func (w *Worker) listUserSessions(context *gin.Context) {
+	request := struct {
+		UserID int64 `json:"userID" binding:"number"`
+	}{}
+
+	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+
+	sessions, err := loggedUser.ListByUserID(request.UserID)
+	if err != nil {
+		render.Status(context, render.StatusUserQuerySessionFailed)
+		return
+	}
+	render.Success(context, sessions)
+}
+
+func (w *Worker) deleteUserSession(context *gin.Context) {
+	request := struct {
+		ID int64 `json:"id" binding:"number"`
+	}{}
+
+	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+
+	if ok := loggedUser.RemoveByID(request.ID); !ok {
+		render.Status(context, render.StatusUserDeleteSessionFailed)
+		return
+	}
+	render.Status(context, render.StatusSuccess)
+}
+
+func (w *Worker) addRole(context *gin.Context) {
+	request := struct {
+		Name        string   `json:"name" binding:"required"`
+		Permissions []string `json:"permissions" binding:"required"`
+	}{}
+
+	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+
+	if err := validatePermissions(request.Permissions); err != nil {
+		render.Status(context, render.StatusInvalidArgument)
+		return
+	}
+
+	if err := w.createRole(request.Name, request.Permissions); err != nil {
+		render.Status(context, render.StatusUserCreateRoleFailed)
+		return
+	}
+	render.Status(context, render.StatusSuccess)
+}
+
+func (w *Worker) deleteRole(context *gin.Context) {
+	request := struct {
+		Name string `json:"name" binding:"required"`
+	}{}
+
+	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+
+	if ok := w.deleteRoleByName(request.Name); !ok {
+		render.Status(context, render.StatusUserDeleteRoleFailed)
+		return
+	}
+	render.Status(context, render.StatusSuccess)
+}
+
+func (w *Worker) updateRole(context *gin.Context) {
+	request := struct {
+		Name        string   `json:"name" binding:"required"`
+		Permissions []string `json:"permissions" binding:"required"`
+	}{}
+
+	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+
+	if err := validatePermissions(request.Permissions); err != nil {
+		render.Status(context, render.StatusInvalidArgument)
+		return
+	}
+
+	if ok := w.updateRoleByName(request.Name, request.Permissions); !ok {
+		render.Status(context, render.StatusUserUpdateRoleFailed)
+		return
+	}
+	render.Status(context, render.StatusSuccess)
+}
+
+func (w *Worker) listAllRoles(context *gin.Context) {
+	roles, err := w.queryAllRole()
+	if err != nil {
+		render.Status(context, render.StatusUserQueryRoleFailed)
+		return
+	}
+	render.Success(context, roles)
+}
+
+func (w *Worker) addToken(context *gin.Context) {
+	request := struct {
+		UserID      int64    `json:"userID" binding:"number"`
+		Name        string   `json:"name" binding:"required"`
+		Permissions []string `json:"permissions" binding:"required"`
+		Expires     int64    `json:"expires" binding:"number"`
+	}{}
+
+	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+
+	if err := validatePermissions(request.Permissions); err != nil {
+		render.Status(context, render.StatusInvalidArgument)
+		return
+	}
+
+	// 不存在的用户的 Token 永远无法通过认证
+	exists, err := w.userExists(request.UserID)
+	if err != nil {
+		render.Status(context, render.StatusUserQueryUserFailed)
+		return
+	}
+	if !exists {
+		render.Status(context, render.StatusInvalidArgument)
+		return
+	}
+
+	token, err := w.createToken(request.UserID, request.Name, request.Permissions, request.Expires)
+	if err != nil {
+		render.Status(context, render.StatusUserCreateTokenFailed)
+		return
+	}
+
+	response := struct {
+		Token string `json:"token"`
+	}{
+		Token: token,
+	}
+	render.Success(context, response)
+}
+
+func (w *Worker) deleteToken(context *gin.Context) {
+	request := struct {
+		ID int64 `json:"id" binding:"number"`
+	}{}
+
+	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+
+	if ok := w.deleteTokenByID(request.ID); !ok {
+		render.Status(context, render.StatusUserDeleteTokenFailed)
+		return
+	}
+	render.Status(context, render.StatusSuccess)
+}
+
+func (w *Worker) listTokens(context *gin.Context) {
+	request := struct {
+		UserID int64 `json:"userID" binding:"number"`
+	}{}
+
+	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+
+	tokens, err := w.queryTokens(request.UserID)
+	if err != nil {
+		render.Status(context, render.StatusUserQueryTokenFailed)
+		return
+	}
+	render.Success(context, tokens)
+}
+
+func (w *Worker) listLoginFailures(context *gin.Context) {
+	request := struct {
+		Limit  int `json:"limit" binding:"number"`
+		Offset int `json:"offset" binding:"number"`
+	}{}
+
+	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+
+	failures, err := w.queryLoginFailureLimitOffset(request.Limit, request.Offset)
+	if err != nil {
+		render.Status(context, render.StatusUserQueryLoginFailureFailed)
+		return
+	}
+	render.Success(context, failures)
+}
+
+// enrollTOTP 为当前用户生成新的动态口令密钥,校验通过 enableTOTP 后才会生效
+func (w *Worker) enrollTOTP(context *gin.Context) {
+	current := context.MustGet(CurrentUserKey).(User)
+	if current.TOTPEnabled {
+		render.Status(context, render.StatusUserTOTPAlreadyEnabled)
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		render.Status(context, render.StatusUnknownError)
+		return
+	}
+
+	if err = w.updateTOTPSecretByUserID(current.UserID, secret); err != nil {
+		render.Status(context, render.StatusUserUpdateTOTPFailed)
+		return
+	}
+
+	response := struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}{
+		Secret: secret,
+		URI:    totpURI(current.Username, secret),
+	}
+	render.Success(context, response)
+}
+
+// enableTOTP 校验验证器应用生成的口令,成功后启用动态口令并返回恢复码
+func (w *Worker) enableTOTP(context *gin.Context) {
+	request := struct {
+		Code string `json:"code" binding:"required"`
+	}{}
+
+	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+
+	current := context.MustGet(CurrentUserKey).(User)
+	totp, err := w.queryTOTPByUserID(current.UserID)
+	if err != nil {
+		render.Status(context, render.StatusUnknownError)
+		return
+	}
+
+	if totp.Enabled {
+		render.Status(context, render.StatusUserTOTPAlreadyEnabled)
+		return
+	}
+
+	step, ok := verifyTOTP(totp.Secret, request.Code, totp.LastStep)
+	if totp.Secret == "" || !ok {
+		render.Status(context, render.StatusUserTOTPInvalid)
+		return
+	}
+
+	if ok := w.enableTOTPByUserID(current.UserID, step); !ok {
+		render.Status(context, render.StatusUserUpdateTOTPFailed)
+		return
+	}
+
+	codes, err := w.createRecoveryCodes(current.UserID)
+	if err != nil {
+		render.Status(context, render.StatusUserUpdateTOTPFailed)
+		return
+	}
+
+	response := struct {
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}{
+		RecoveryCodes: codes,
+	}
+	render.Success(context, response)
+}
+
+// disableTOTP 需要同时提供密码和动态口令,被要求启用动态口令的用户不能关闭
+func (w *Worker) disableTOTP(context *gin.Context) {
+	request := struct {
+		Password string `json:"password" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}{}
+
+	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+
+	current := context.MustGet(CurrentUserKey).(User)
+	if current.TOTPRequired {
+		render.Status(context, render.StatusUserPermissionDenied)
+		return
+	}
+
+	ok, err := w.checkUserPassword(current.Username, request.Password)
+	if err != nil || !ok {
+		render.Status(context, render.StatusUserLoginFaild)
+		return
+	}
+
+	ok, err = w.verifySecondFactor(current.UserID, request.Code)
+	if err != nil || !ok {
+		render.Status(context, render.StatusUserTOTPInvalid)
+		return
+	}
+
+	if err = w.resetTOTPByUserID(current.UserID); err != nil {
+		render.Status(context, render.StatusUserUpdateTOTPFailed)
+		return
+	}
+	render.Status(context, render.StatusSuccess)
+}
+
+func (w *Worker) updateTOTPRequired(context *gin.Context) {
+	request := struct {
+		UserID   int64 `json:"userID" binding:"number"`
+		Required bool  `json:"required"`
+	}{}
+
+	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+
+	if err := w.updateTOTPRequiredByUserID(request.UserID, request.Required); err != nil {
+		render.Status(context, render.StatusUserUpdateTOTPFailed)
+		return
+	}
+	render.Status(context, render.StatusSuccess)
+}
+
+// resetTOTP 用于用户丢失验证器和恢复码的情况,用户需要重新绑定
+func (w *Worker) resetTOTP(context *gin.Context) {
+	request := struct {
+		UserID int64 `json:"userID" binding:"number"`
+	}{}
+
+	if err := context.ShouldBindJSON(&request); err != nil {
+		render.InvalidArgument(context, err)
+		return
+	}
+
+	if err := w.resetTOTPByUserID(request.UserID); err != nil {
+		render.Status(context, render.StatusUserUpdateTOTPFailed)
+		return
+	}
 	render.Status(context, render.StatusSuccess)
 }
 
+// 会话 Cookie 禁止脚本读取,CSRF Cookie 需要由前端读取.通过 TLS 访问时只允许 HTTPS 传输
 func updateSession(context *gin.Context, session string) {
+	secure := context.Request.TLS != nil
 	context.SetSameSite(http.SameSiteStrictMode)
-	context.SetCookie("session", session, 0, "/", "", false, false)
+	context.SetCookie("session", session, 0, "/", "", secure, true)
+	context.SetCookie(CSRFCookie, csrfToken(session), 0, "/", "", secure, false)
 }
 
 func deleteSession(context *gin.Context) {
+	secure := context.Request.TLS != nil
 	context.SetSameSite(http.SameSiteStrictMode)
-	context.SetCookie("session", "deleted", -1, "/", "", false, false)
+	context.SetCookie("session", "deleted", -1, "/", "", secure, true)
+	context.SetCookie(CSRFCookie, "deleted", -1, "/", "", secure, false)
 }
@@ -0,0 +1,28 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package user
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newTestWorker 使用临时目录中的数据库创建用户相关的表
+func newTestWorker(t *testing.T) *Worker {
+	t.Helper()
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "web.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	w := &Worker{db: db, limiter: newLoginLimiter(LoginConfig{})}
+	for _, init := range []func() error{w.initUserTable, w.initTOTPTable, w.initTokenTable} {
+		if err := init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w
+}
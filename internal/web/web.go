@@ -4,49 +4,158 @@ package web
 import (
 	"context"
 	"database/sql"
+	stdnet "net"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gin-contrib/pprof"
 	"github.com/gin-gonic/gin"
+	"github.com/lanthora/uranus/internal/web/audit"
 	"github.com/lanthora/uranus/internal/web/ctrl"
 	"github.com/lanthora/uranus/internal/web/file"
 	"github.com/lanthora/uranus/internal/web/net"
+	"github.com/lanthora/uranus/internal/web/openapi"
 	"github.com/lanthora/uranus/internal/web/process"
+	"github.com/lanthora/uranus/internal/web/render"
+	"github.com/lanthora/uranus/internal/web/status"
 	"github.com/lanthora/uranus/internal/web/user"
 	"github.com/sirupsen/logrus"
 )
 
+// 带版本号的接口前缀,OpenAPI 文档中的路径都相对于这个前缀.
+// v2 与 v1 的接口相同,失败时返回对应的 HTTP 状态码和错误详情
+var apiPrefixes = map[string]int{
+	"/api/v1": render.Version1,
+	"/api/v2": render.Version2,
+}
+
+type TLSConfig struct {
+	Enable bool
+	Cert   string
+	Key    string
+	// 未指定证书时自签名证书的保存目录
+	SelfSignedDir string
+	// HTTP 监听地址,所有请求重定向到 HTTPS,为空时不监听
+	Redirect string
+}
+
+type Config struct {
+	Listen  string
+	Session user.SessionConfig
+	Login   user.LoginConfig
+	TLS     TLSConfig
+}
+
 type WebWorker struct {
-	addr   string
-	server *http.Server
-	wg     sync.WaitGroup
-	db     *sql.DB
+	addr     string
+	server   *http.Server
+	redirect *http.Server
+	wg       sync.WaitGroup
+	db       *sql.DB
+	session  user.SessionConfig
+	login    user.LoginConfig
+	tls      TLSConfig
 }
 
-func NewWorker(addr string, db *sql.DB) *WebWorker {
+func NewWorker(config Config, db *sql.DB) *WebWorker {
 	w := WebWorker{
-		addr: addr,
-		db:   db,
+		addr:    config.Listen,
+		db:      db,
+		session: config.Session,
+		login:   config.Login,
+		tls:     config.TLS,
 	}
 	return &w
 }
 
 func (w *WebWorker) serve() {
 	defer w.wg.Done()
-	if err := w.server.ListenAndServe(); err != http.ErrServerClosed {
+	err := error(nil)
+	if w.tls.Enable {
+		err = w.server.ListenAndServeTLS(w.tls.Cert, w.tls.Key)
+	} else {
+		err = w.server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
 		logrus.Error(err)
 		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
 	}
 }
 
+func (w *WebWorker) serveRedirect() {
+	defer w.wg.Done()
+	if err := w.redirect.ListenAndServe(); err != http.ErrServerClosed {
+		logrus.Error(err)
+		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+	}
+}
+
+// redirectToHTTPS 保留请求的主机名和路径,端口替换为 HTTPS 监听端口
+func (w *WebWorker) redirectToHTTPS(writer http.ResponseWriter, request *http.Request) {
+	host, _, err := stdnet.SplitHostPort(request.Host)
+	if err != nil {
+		host = request.Host
+	}
+	if _, port, err := stdnet.SplitHostPort(w.addr); err == nil && port != "443" {
+		host = stdnet.JoinHostPort(host, port)
+	}
+	target := url.URL{Scheme: "https", Host: host, Path: request.URL.Path, RawQuery: request.URL.RawQuery}
+	http.Redirect(writer, request, target.String(), http.StatusMovedPermanently)
+}
+
+func (w *WebWorker) initTLS() (err error) {
+	if w.tls.Cert == "" && w.tls.Key == "" {
+		w.tls.Cert, w.tls.Key, err = loadOrCreateSelfSignedCert(w.tls.SelfSignedDir)
+		if err != nil {
+			return
+		}
+	}
+
+	fingerprint, err := certFingerprint(w.tls.Cert)
+	if err != nil {
+		return
+	}
+	logrus.Infof("tls certificate: %s, sha256 fingerprint: %s", w.tls.Cert, fingerprint)
+
+	if w.tls.Redirect != "" {
+		w.redirect = &http.Server{
+			Addr:    w.tls.Redirect,
+			Handler: http.HandlerFunc(w.redirectToHTTPS),
+		}
+	}
+	return
+}
+
+// versioned 把 /api/v1 和 /api/v2 下的请求转发到对应的接口,不带版本号的路径继续保留给 webui 使用
+func versioned(router *gin.Engine) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		for prefix, version := range apiPrefixes {
+			if strings.HasPrefix(request.URL.Path, prefix+"/") {
+				request = render.WithVersion(request, version)
+				request.URL.Path = strings.TrimPrefix(request.URL.Path, prefix)
+				request.URL.RawPath = ""
+				break
+			}
+		}
+		router.ServeHTTP(writer, request)
+	})
+}
+
 func (w *WebWorker) Init() (err error) {
 	gin.SetMode(gin.ReleaseMode)
 
 	router := gin.New()
-	if err = user.Init(router, w.db); err != nil {
+	router.Use(securityHeaders())
+
+	if err = audit.Init(router, w.db); err != nil {
+		return
+	}
+
+	if err = user.Init(router, w.db, w.session, w.login); err != nil {
 		return
 	}
 
@@ -66,14 +175,30 @@ func (w *WebWorker) Init() (err error) {
 		return
 	}
 
+	if err = status.Init(router, w.db); err != nil {
+		return
+	}
+
+	if err = openapi.Init(router); err != nil {
+		return
+	}
+
 	router.Use(ctrl.PProfMiddleware())
 	pprof.Register(router)
 
 	router.NoRoute(webui)
 
+	if err = openapi.Check(router.Routes()); err != nil {
+		return
+	}
+
 	w.server = &http.Server{
 		Addr:    w.addr,
-		Handler: router,
+		Handler: versioned(router),
+	}
+
+	if w.tls.Enable {
+		err = w.initTLS()
 	}
 	return
 }
@@ -81,6 +206,11 @@ func (w *WebWorker) Init() (err error) {
 func (w *WebWorker) Start() (err error) {
 	w.wg.Add(1)
 	go w.serve()
+
+	if w.redirect != nil {
+		w.wg.Add(1)
+		go w.serveRedirect()
+	}
 	return
 }
 
@@ -88,5 +218,8 @@ func (w *WebWorker) Stop() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	w.server.Shutdown(ctx)
+	if w.redirect != nil {
+		w.redirect.Shutdown(ctx)
+	}
 	w.wg.Wait()
 }
@@ -0,0 +1,299 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package worker
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/lanthora/uranus/internal/apply"
+	"github.com/lanthora/uranus/pkg/file"
+	"github.com/lanthora/uranus/pkg/logger"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	sqlCreateDriftTable      = `create table if not exists policy_drift(module text primary key, status text not null, policies integer not null, missing integer not null, stale integer not null, healed integer not null, error text not null, timestamp integer not null)`
+	sqlReplaceDrift          = `replace into policy_drift(module,status,policies,missing,stale,healed,error,timestamp) values(?,?,?,?,?,?,?,?)`
+	sqlQueryNormalFilePolicy = `select id,path,fsid,ino,perm,rule from file_policy where status=0`
+	sqlCountNormalFilePolicy = `select count(*) from file_policy where status=0`
+	sqlCountNetPolicy        = `select count(*) from net_policy`
+	sqlCountAllowedProcesses = `select count(*) from process_event where status=2`
+)
+
+const (
+	DriftModuleProcess = "process"
+	DriftModuleFile    = "file"
+	DriftModuleNet     = "net"
+)
+
+const (
+	// 数据库与 hackernel 中的策略一致
+	DriftStatusSynced = "synced"
+	// 存在 hackernel 中缺失或已失效的策略
+	DriftStatusDrifted = "drifted"
+	// hackernel 不支持查询策略,无法核对
+	DriftStatusUnverified = "unverified"
+	// 核对后已按数据库修复差异
+	DriftStatusHealed = "healed"
+	// 已按数据库重新下发策略,但 hackernel 不支持查询,无法确认是否全部生效
+	DriftStatusReplayed = "replayed"
+	// 核对或重放过程中与 hackernel 通信失败
+	DriftStatusFailed = "failed"
+)
+
+type DriftConfig struct {
+	// 核对周期,不大于 0 时不启动核对
+	Interval time.Duration
+	// 发现差异后按数据库中的策略修复 hackernel
+	Heal bool
+}
+
+// Drift 是一个模块最近一次的核对结果
+type Drift struct {
+	Module   string
+	Status   string
+	Policies int
+	Missing  int
+	Stale    int
+	Healed   int
+	Error    string
+}
+
+// DriftWorker 定期核对数据库中的策略与 hackernel 中实际生效的策略.
+// 文件策略逐条用 FlagNew 探测: 返回冲突说明策略存在,设置成功说明策略缺失.
+// 探测本身会补上缺失的策略,所以只在开启修复时探测,否则记为 unverified.
+// 进程和网络模块没有查询接口,只能在开启修复时不清空 hackernel 按数据库重新添加,
+// 结果记为 replayed 而不是 healed
+type DriftWorker struct {
+	db      *sql.DB
+	config  DriftConfig
+	process *ProcessWorker
+	file    *FileWorker
+	net     *NetWorker
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+	log  *logrus.Entry
+}
+
+func NewDriftWorker(db *sql.DB, config DriftConfig, process *ProcessWorker, file *FileWorker, net *NetWorker) *DriftWorker {
+	w := DriftWorker{
+		db:      db,
+		config:  config,
+		process: process,
+		file:    file,
+		net:     net,
+		stop:    make(chan struct{}),
+		log:     logger.WithComponent("worker", "drift"),
+	}
+	return &w
+}
+
+func (w *DriftWorker) Init() (err error) {
+	_, err = w.db.Exec(sqlCreateDriftTable)
+	if err != nil {
+		w.log.Error(err)
+		return
+	}
+	return
+}
+
+func (w *DriftWorker) Start() (err error) {
+	if w.config.Interval <= 0 {
+		w.log.Info("drift detection disabled")
+		return
+	}
+	w.wg.Add(1)
+	go w.run()
+	return
+}
+
+func (w *DriftWorker) Stop() {
+	close(w.stop)
+	w.wg.Wait()
+}
+
+func (w *DriftWorker) run() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.config.Interval)
+	defer ticker.Stop()
+	w.Check()
+	for {
+		select {
+		case <-w.stop:
+			w.log.Info("drift worker exit")
+			return
+		case <-ticker.C:
+			w.Check()
+		}
+	}
+}
+
+// Check 核对所有模块并保存结果
+func (w *DriftWorker) Check() {
+	drifts := []Drift{w.checkProcess(), w.checkFile(), w.checkNet()}
+	for _, drift := range drifts {
+		if drift.Status == DriftStatusDrifted || drift.Status == DriftStatusFailed || drift.Missing+drift.Stale > 0 {
+			w.log.Warnf("module=%s, status=%s, missing=%d, stale=%d, healed=%d, error=%s",
+				drift.Module, drift.Status, drift.Missing, drift.Stale, drift.Healed, drift.Error)
+		}
+		if err := w.saveDrift(drift); err != nil {
+			w.log.Error(err)
+		}
+	}
+}
+
+func (w *DriftWorker) saveDrift(drift Drift) (err error) {
+	stmt, err := w.db.Prepare(sqlReplaceDrift)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(drift.Module, drift.Status, drift.Policies, drift.Missing, drift.Stale, drift.Healed, drift.Error, time.Now().Unix())
+	return
+}
+
+func (w *DriftWorker) count(query string) (count int, err error) {
+	stmt, err := w.db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow().Scan(&count)
+	return
+}
+
+// replay 按数据库重新下发无法查询的模块的策略, replay 不能清空 hackernel 中已经生效的策略
+func (w *DriftWorker) replay(module, query string, replay func(drift *Drift) error) (drift Drift) {
+	drift = Drift{Module: module, Status: DriftStatusUnverified}
+	err := apply.Exclusive(func() (err error) {
+		if drift.Policies, err = w.count(query); err != nil || !w.config.Heal {
+			return
+		}
+		if err = replay(&drift); err != nil {
+			return
+		}
+		drift.Status = DriftStatusReplayed
+		return
+	})
+	if err != nil {
+		drift.Status = DriftStatusFailed
+		drift.Error = err.Error()
+	}
+	return
+}
+
+func (w *DriftWorker) checkProcess() Drift {
+	return w.replay(DriftModuleProcess, sqlCountAllowedProcesses, func(drift *Drift) error {
+		return w.process.replayTrustedCmd()
+	})
+}
+
+// checkNet 重新添加网络策略,添加成功的策略原本在 hackernel 中缺失.
+// 无法确认重放后的策略与数据库一致,不计入 healed
+func (w *DriftWorker) checkNet() Drift {
+	return w.replay(DriftModuleNet, sqlCountNetPolicy, func(drift *Drift) (err error) {
+		drift.Missing, err = w.net.replayNetPolicy()
+		return
+	})
+}
+
+func (w *DriftWorker) queryNormalFilePolicies() (policies []file.Policy, err error) {
+	stmt, err := w.db.Prepare(sqlQueryNormalFilePolicy)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query()
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		policy := file.Policy{}
+		err = rows.Scan(&policy.ID, &policy.Path, &policy.Fsid, &policy.Ino, &policy.Perm, &policy.Rule)
+		if err != nil {
+			return
+		}
+		policies = append(policies, policy)
+	}
+	err = rows.Err()
+	return
+}
+
+// probeFilePolicy 探测一条文件策略并按数据库修复
+func (w *DriftWorker) probeFilePolicy(policy file.Policy, drift *Drift) (err error) {
+	fsid, ino, status, err := file.SetPolicy(policy.Path, policy.Perm, file.FlagNew)
+	if err != nil {
+		return
+	}
+
+	switch status {
+	case file.StatusPolicyConflict:
+		// 策略存在但权限可能被其他客户端修改过,以数据库为准
+		_, _, status, err = file.SetPolicy(policy.Path, policy.Perm, file.FlagUpdate)
+		if err == nil && status != file.StatusPolicyNormal {
+			err = file.StatusError(status)
+		}
+	case file.StatusPolicyNormal:
+		drift.Missing++
+		drift.Healed++
+		if fsid != policy.Fsid || ino != policy.Ino {
+			err = w.file.updateFilePolcyFsidInoById(fsid, ino, policy.ID)
+		}
+	case file.StatusPolicyFileNotExist:
+		drift.Stale++
+		drift.Healed++
+		// 规则展开的策略随文件一起删除
+		if policy.Rule != 0 {
+			err = w.file.deleteFilePolicyById(policy.ID)
+		} else {
+			err = w.file.updateFilePolcyStatusById(status, policy.ID)
+		}
+	default:
+		err = file.StatusError(status)
+	}
+	return
+}
+
+func (w *DriftWorker) checkFile() (drift Drift) {
+	if !w.config.Heal {
+		drift = Drift{Module: DriftModuleFile, Status: DriftStatusUnverified}
+		count, err := w.count(sqlCountNormalFilePolicy)
+		if err != nil {
+			drift.Status = DriftStatusFailed
+			drift.Error = err.Error()
+		}
+		drift.Policies = count
+		return
+	}
+
+	drift = Drift{Module: DriftModuleFile, Status: DriftStatusSynced}
+	err := apply.Exclusive(func() (err error) {
+		policies, err := w.queryNormalFilePolicies()
+		if err != nil {
+			return
+		}
+		drift.Policies = len(policies)
+		for _, policy := range policies {
+			if err = w.probeFilePolicy(policy, &drift); err != nil {
+				return
+			}
+		}
+		return
+	})
+
+	switch {
+	case err != nil:
+		drift.Status = DriftStatusFailed
+		drift.Error = err.Error()
+	case drift.Missing == 0 && drift.Stale == 0:
+		drift.Status = DriftStatusSynced
+	case drift.Healed == drift.Missing+drift.Stale:
+		drift.Status = DriftStatusHealed
+	default:
+		drift.Status = DriftStatusDrifted
+	}
+	return
+}
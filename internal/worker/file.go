@@ -4,25 +4,35 @@ package worker
 import (
 	"database/sql"
 	"encoding/json"
+	"path/filepath"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
+	"github.com/lanthora/uranus/internal/common"
 	"github.com/lanthora/uranus/internal/config"
 	"github.com/lanthora/uranus/pkg/connector"
 	"github.com/lanthora/uranus/pkg/file"
+	"github.com/lanthora/uranus/pkg/logger"
 	"github.com/lanthora/uranus/pkg/watchdog"
 	"github.com/sirupsen/logrus"
 )
 
 const (
 	sqlCreateFilePolicyTable       = `create table if not exists file_policy(id integer primary key autoincrement, path text not null, fsid integer, ino integer, perm integer not null, timestamp integer not null, status integer not null)`
+	sqlCreateFileRuleTable         = `create table if not exists file_rule(id integer primary key autoincrement, pattern text not null, type integer not null, perm integer not null, timestamp integer not null)`
 	sqlCreateFileEventTable        = `create table if not exists file_event(id integer primary key autoincrement, path text not null, fsid integer, ino integer, perm integer not null, timestamp integer not null, policy integer not null, status integer not null)`
 	sqlQueryFilePolicy             = `select id,path,fsid,ino,perm from file_policy`
 	sqlUpdateFilePolicyFsidInoById = `update file_policy set fsid=?,ino=?,timestamp=? where id=?`
 	sqlUpdateFilePolicyStatusById  = `update file_policy set status=? where id=?`
 	sqlQueryFilePolicyIdByFsidIno  = `select id from file_policy where fsid=? and ino=? and status=0`
-	sqlInsertFileEvent             = `insert into file_event(path,fsid,ino,perm,timestamp,policy,status) values(?,?,?,?,?,?,?)`
+	sqlCreateFilePolicyPathIndex   = `create index if not exists file_policy_path on file_policy(path)`
+	sqlQueryFilePolicyPath         = `select id,path from file_policy`
+	sqlUpdateFilePolicyPathById    = `update file_policy set path=? where id=?`
+	sqlInsertFileEvent             = `insert into file_event(path,fsid,ino,perm,timestamp,policy,status,pid,uid,workdir,binary,argv,process) values(?,?,?,?,?,?,?,?,?,?,?,?,?)`
+	sqlQueryProcessEventIdByCmd    = `select id from process_event where workdir=? and binary=? and argv=? limit 1`
+	sqlQueryProcessEventIdByBinary = `select id from process_event where binary=? and argv=? order by id desc limit 1`
 )
 
 type FileWorker struct {
@@ -31,14 +41,23 @@ type FileWorker struct {
 	running bool
 	wg      sync.WaitGroup
 	conn    *connector.Connector
-	config  *config.Config
-	dog     *watchdog.Watchdog
+	watcher *fsnotify.Watcher
+	// watching 等待 watch 退出, Stop 需要在清空策略之前单独等待
+	watching sync.WaitGroup
+	config   *config.Config
+	dog      *watchdog.Watchdog
+	log      *logrus.Entry
+
+	// 已展开的规则及其需要监控的目录,只在 watch 中访问
+	rules map[int64][]string
 }
 
 func NewFileWorker(db *sql.DB) *FileWorker {
 	w := FileWorker{
-		db:   db,
-		conn: connector.New(),
+		db:    db,
+		conn:  connector.New(),
+		rules: map[int64][]string{},
+		log:   logger.WithComponent("worker", "file"),
 	}
 	return &w
 }
@@ -46,7 +65,7 @@ func NewFileWorker(db *sql.DB) *FileWorker {
 func (w *FileWorker) Init() (err error) {
 	err = w.initDB()
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 
@@ -56,7 +75,7 @@ func (w *FileWorker) Init() (err error) {
 	}
 
 	if err = w.initFilePolicy(); err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 
@@ -70,13 +89,13 @@ func (w *FileWorker) Init() (err error) {
 	case file.StatusEnable:
 		if ok := file.Enable(); !ok {
 			err = file.ErrorEnable
-			logrus.Error(err)
+			w.log.Error(err)
 			return
 		}
 	default:
 		if ok := file.Disable(); !ok {
 			err = file.ErrorDisable
-			logrus.Error(err)
+			w.log.Error(err)
 			return
 		}
 	}
@@ -87,73 +106,103 @@ func (w *FileWorker) Start() (err error) {
 	w.running = true
 	err = w.conn.Connect()
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 
 	err = w.conn.Send(`{"type":"user::msg::sub","section":"kernel::file::report"}`)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	err = w.conn.Send(`{"type":"user::msg::sub","section":"osinfo::report"}`)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 
 	w.wg.Add(1)
 	go w.run()
+
+	w.watcher, err = fsnotify.NewWatcher()
+	if err != nil {
+		w.log.Error(err)
+		return
+	}
+	w.watching.Add(1)
+	go w.watch()
 	return
 }
 
 func (w *FileWorker) Stop() {
 	err := w.conn.Send(`{"type":"user::msg::unsub","section":"kernel::proc::report"}`)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 	}
 	err = w.conn.Send(`{"type":"user::msg::unsub","section":"osinfo::report"}`)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 	}
 
+	// 先停止 watch, 避免清空策略后又按规则设置新的策略
+	if w.watcher != nil {
+		w.watcher.Close()
+	}
+	w.watching.Wait()
+
 	if ok := file.Disable(); !ok {
-		logrus.Error(file.ErrorDisable)
+		w.log.Error(file.ErrorDisable)
 	}
 
 	if ok := file.ClearPolicy(); !ok {
-		logrus.Error(file.ErrorClearPolicy)
+		w.log.Error(file.ErrorClearPolicy)
 	}
 
 	time.Sleep(time.Second)
 	w.running = false
 	err = w.conn.Shutdown(time.Now())
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 	}
 	w.wg.Wait()
 	w.conn.Close()
 }
 
 func (w *FileWorker) handleMsg(msg string) {
+	// pid, uid, workdir, binary 和 argv 是访问文件的进程,旧版本的 hackernel 不上报
 	event := struct {
-		Type string `json:"type"`
-		Path string `json:"name"`
-		Fsid uint64 `json:"fsid"`
-		Ino  uint64 `json:"ino"`
-		Perm int    `json:"perm"`
-	}{}
+		Type    string `json:"type"`
+		Path    string `json:"name"`
+		Fsid    uint64 `json:"fsid"`
+		Ino     uint64 `json:"ino"`
+		Perm    int    `json:"perm"`
+		Pid     int    `json:"pid"`
+		Uid     int    `json:"uid"`
+		Workdir string `json:"workdir"`
+		Binary  string `json:"binary"`
+		Argv    string `json:"argv"`
+	}{Uid: -1}
 
 	err := json.Unmarshal([]byte(msg), &event)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	switch event.Type {
 	case "kernel::file::report":
-		err = w.handleFileEvent(event.Path, int64(event.Fsid), int64(event.Ino), event.Perm)
+		err = w.handleFileEvent(file.Event{
+			Path:    event.Path,
+			Fsid:    int64(event.Fsid),
+			Ino:     int64(event.Ino),
+			Perm:    event.Perm,
+			Pid:     event.Pid,
+			Uid:     event.Uid,
+			Workdir: event.Workdir,
+			Binary:  event.Binary,
+			Argv:    event.Argv,
+		})
 		if err != nil {
-			logrus.Error(err)
+			w.log.Error(err)
 		}
 	default:
 	}
@@ -162,7 +211,7 @@ func (w *FileWorker) handleMsg(msg string) {
 func (w *FileWorker) run() {
 	defer w.wg.Done()
 	w.dog = watchdog.New(10*time.Second, func() {
-		logrus.Error("osinfo::report timeout")
+		w.log.Error("osinfo::report timeout")
 		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
 	})
 	defer w.dog.Stop()
@@ -170,12 +219,12 @@ func (w *FileWorker) run() {
 		msg, err := w.conn.Recv()
 
 		if !w.running {
-			logrus.Info("file worker exit")
+			w.log.Info("file worker exit")
 			break
 		}
 
 		if err != nil {
-			logrus.Error(err)
+			w.log.Error(err)
 			continue
 		}
 		w.dog.Kick()
@@ -186,29 +235,102 @@ func (w *FileWorker) run() {
 func (w *FileWorker) initDB() (err error) {
 	_, err = w.db.Exec(sqlCreateFilePolicyTable)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 
 	_, err = w.db.Exec(sqlCreateFileEventTable)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
+		return
+	}
+
+	_, err = w.db.Exec(sqlCreateFileRuleTable)
+	if err != nil {
+		w.log.Error(err)
+		return
+	}
+
+	// 规则展开的策略记录所属的规则,单独添加的策略为 0
+	err = common.AddColumnIfNotExists(w.db, "file_policy", "rule", "integer not null default 0")
+	if err != nil {
+		w.log.Error(err)
+		return
+	}
+
+	// 文件事件记录访问文件的进程
+	columns := []struct{ name, def string }{
+		{"pid", "integer not null default 0"},
+		{"uid", "integer not null default -1"},
+		{"workdir", "text not null default ''"},
+		{"binary", "text not null default ''"},
+		{"argv", "text not null default ''"},
+		{"process", "integer not null default 0"},
+	}
+	for _, column := range columns {
+		err = common.AddColumnIfNotExists(w.db, "file_event", column.name, column.def)
+		if err != nil {
+			w.log.Error(err)
+			return
+		}
+	}
+
+	// 文件被替换时按路径查询策略
+	_, err = w.db.Exec(sqlCreateFilePolicyPathIndex)
+	if err != nil {
+		w.log.Error(err)
+		return
+	}
+
+	err = w.cleanFilePolicyPath()
+	if err != nil {
+		w.log.Error(err)
+		return
+	}
+
+	return
+}
+
+// cleanFilePolicyPath 规范化旧版本保存的策略路径,与监控事件中的路径一致才能按路径查询
+func (w *FileWorker) cleanFilePolicyPath() (err error) {
+	rows, err := w.db.Query(sqlQueryFilePolicyPath)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	paths := map[int64]string{}
+	for rows.Next() {
+		id, path := int64(0), ""
+		if err = rows.Scan(&id, &path); err != nil {
+			return
+		}
+		if clean := filepath.Clean(path); clean != path {
+			paths[id] = clean
+		}
+	}
+	if err = rows.Err(); err != nil {
 		return
 	}
+	rows.Close()
 
+	for id, path := range paths {
+		if _, err = w.db.Exec(sqlUpdateFilePolicyPathById, path, id); err != nil {
+			return
+		}
+	}
 	return
 }
 
 func (w *FileWorker) setPolicyThenGetExceptionPolicies() (policies []file.Policy, err error) {
 	stmt, err := w.db.Prepare(sqlQueryFilePolicy)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	defer stmt.Close()
 	rows, err := stmt.Query()
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	defer rows.Close()
@@ -220,13 +342,13 @@ func (w *FileWorker) setPolicyThenGetExceptionPolicies() (policies []file.Policy
 
 		err = rows.Scan(&policy.ID, &policy.Path, &policy.Fsid, &policy.Ino, &policy.Perm)
 		if err != nil {
-			logrus.Error(err)
+			w.log.Error(err)
 			return
 		}
 
 		fsid, ino, status, err = file.SetPolicy(policy.Path, policy.Perm, file.FlagNew)
 		if err != nil {
-			logrus.Error(err)
+			w.log.Error(err)
 			return
 		}
 		if policy.Fsid != fsid || policy.Ino != ino || policy.Status != status {
@@ -238,7 +360,7 @@ func (w *FileWorker) setPolicyThenGetExceptionPolicies() (policies []file.Policy
 	}
 	err = rows.Err()
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	return
@@ -246,24 +368,24 @@ func (w *FileWorker) setPolicyThenGetExceptionPolicies() (policies []file.Policy
 
 func (w *FileWorker) initFilePolicy() (err error) {
 	if ok := file.ClearPolicy(); !ok {
-		logrus.Error(file.ErrorClearPolicy)
+		w.log.Error(file.ErrorClearPolicy)
 		return
 	}
 
 	policies, err := w.setPolicyThenGetExceptionPolicies()
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	for _, policy := range policies {
 		err = w.updateFilePolcyFsidInoById(policy.Fsid, policy.Ino, policy.ID)
 		if err != nil {
-			logrus.Error(err)
+			w.log.Error(err)
 			return
 		}
 		err = w.updateFilePolcyStatusById(policy.Status, policy.ID)
 		if err != nil {
-			logrus.Error(err)
+			w.log.Error(err)
 			return
 		}
 	}
@@ -273,22 +395,22 @@ func (w *FileWorker) initFilePolicy() (err error) {
 func (w *FileWorker) updateFilePolcyFsidInoById(fsid, ino, id int64) (err error) {
 	stmt, err := w.db.Prepare(sqlUpdateFilePolicyFsidInoById)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	defer stmt.Close()
 	result, err := stmt.Exec(int64(fsid), int64(ino), time.Now().Unix(), id)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	affected, err := result.RowsAffected()
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	if affected != 1 {
-		logrus.Errorf("id=%d, fsid=%d, ino=%d, affected=%d", id, fsid, ino, affected)
+		w.log.Errorf("id=%d, fsid=%d, ino=%d, affected=%d", id, fsid, ino, affected)
 	}
 	return
 }
@@ -296,51 +418,82 @@ func (w *FileWorker) updateFilePolcyFsidInoById(fsid, ino, id int64) (err error)
 func (w *FileWorker) updateFilePolcyStatusById(status int, id int64) (err error) {
 	stmt, err := w.db.Prepare(sqlUpdateFilePolicyStatusById)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	defer stmt.Close()
 	result, err := stmt.Exec(status, id)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	affected, err := result.RowsAffected()
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	if affected != 1 {
-		logrus.Errorf("id=%d, status=%d, affected=%d", id, status, affected)
+		w.log.Errorf("id=%d, status=%d, affected=%d", id, status, affected)
 	}
 	return
 }
 
-func (w *FileWorker) handleFileEvent(path string, fsid, ino int64, perm int) (err error) {
+// queryProcessEventId 按命令行查找进程事件,只是尽力匹配: 进程事件不记录 pid,
+// 找到的是执行过相同命令的进程,不一定是访问文件的那个进程.
+// hackernel 上报了 workdir 时按 workdir, binary 和 argv 匹配,否则匹配最近一次执行的相同命令.
+// 进程模块未开启或进程事件还未写入时找不到, 返回 0
+func (w *FileWorker) queryProcessEventId(workdir, binary, argv string) (id int64, err error) {
+	if binary == "" {
+		return
+	}
+	query, args := sqlQueryProcessEventIdByBinary, []interface{}{binary, argv}
+	if workdir != "" {
+		query, args = sqlQueryProcessEventIdByCmd, []interface{}{workdir, binary, argv}
+	}
+	stmt, err := w.db.Prepare(query)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(args...).Scan(&id)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return
+}
+
+func (w *FileWorker) handleFileEvent(event file.Event) (err error) {
 	stmt, err := w.db.Prepare(sqlQueryFilePolicyIdByFsidIno)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	defer stmt.Close()
 
 	policyId := int64(0)
-	err = stmt.QueryRow(fsid, ino).Scan(&policyId)
+	err = stmt.QueryRow(event.Fsid, event.Ino).Scan(&policyId)
+	if err != nil {
+		w.log.Error(err)
+		return
+	}
+
+	processId, err := w.queryProcessEventId(event.Workdir, event.Binary, event.Argv)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 
 	stmt, err = w.db.Prepare(sqlInsertFileEvent)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(path, fsid, ino, perm, time.Now().Unix(), policyId, file.StatusEventUnread)
+	_, err = stmt.Exec(event.Path, event.Fsid, event.Ino, event.Perm, time.Now().Unix(), policyId, file.StatusEventUnread,
+		event.Pid, event.Uid, event.Workdir, event.Binary, event.Argv, processId)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	return
@@ -0,0 +1,246 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lanthora/uranus/internal/apply"
+	"github.com/lanthora/uranus/pkg/file"
+)
+
+const (
+	sqlQueryFileRule            = `select id,pattern,type,perm from file_rule`
+	sqlQueryFilePolicyByRule    = `select id,path,status from file_policy where rule=?`
+	sqlQueryFilePolicyCountPath = `select count(*) from file_policy where path=?`
+	sqlInsertRuleFilePolicy     = `insert into file_policy(path,fsid,ino,perm,timestamp,status,rule) values(?,?,?,?,?,?,?)`
+	sqlDeleteFilePolicyById     = `delete from file_policy where id=?`
+)
+
+func (w *FileWorker) queryFileRules() (rules []file.Rule, err error) {
+	stmt, err := w.db.Prepare(sqlQueryFileRule)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query()
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rule := file.Rule{}
+		if err = rows.Scan(&rule.ID, &rule.Pattern, &rule.Type, &rule.Perm); err != nil {
+			return
+		}
+		rules = append(rules, rule)
+	}
+	err = rows.Err()
+	return
+}
+
+func (w *FileWorker) queryFilePoliciesByRule(rule int64) (policies []file.Policy, err error) {
+	stmt, err := w.db.Prepare(sqlQueryFilePolicyByRule)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(rule)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		policy := file.Policy{Rule: rule}
+		if err = rows.Scan(&policy.ID, &policy.Path, &policy.Status); err != nil {
+			return
+		}
+		policies = append(policies, policy)
+	}
+	err = rows.Err()
+	return
+}
+
+func (w *FileWorker) deleteFilePolicyById(id int64) (err error) {
+	stmt, err := w.db.Prepare(sqlDeleteFilePolicyById)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(id)
+	return
+}
+
+// addRulePolicy 为规则新匹配到的文件添加策略,已经有策略的文件不重复添加
+func (w *FileWorker) addRulePolicy(rule file.Rule, path string) (err error) {
+	stmt, err := w.db.Prepare(sqlQueryFilePolicyCountPath)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	count := 0
+	if err = stmt.QueryRow(path).Scan(&count); err != nil || count != 0 {
+		return
+	}
+
+	fsid, ino, status, err := file.SetPolicy(path, rule.Perm, file.FlagNew)
+	if err != nil {
+		return
+	}
+	if status != file.StatusPolicyNormal {
+		// 文件已被删除或已有其他客户端添加的策略
+		w.log.Debugf("skip file for rule: rule=%d, path=%s, status=%d", rule.ID, path, status)
+		return
+	}
+
+	stmt, err = w.db.Prepare(sqlInsertRuleFilePolicy)
+	if err != nil {
+		file.SetPolicy(path, 0, file.FlagAny)
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(path, fsid, ino, rule.Perm, time.Now().Unix(), status, rule.ID)
+	if err != nil {
+		file.SetPolicy(path, 0, file.FlagAny)
+		return
+	}
+	w.log.Infof("file added by rule: rule=%d, path=%s", rule.ID, path)
+	return
+}
+
+// syncRule 展开规则,为新文件添加策略并删除已经不存在的文件的策略
+func (w *FileWorker) syncRule(rule file.Rule) (err error) {
+	files, dirs, err := rule.Walk("")
+	partial := false
+	switch err {
+	case nil:
+	case file.ErrorFileNotExist:
+		err = nil
+	case file.ErrorRuleTooManyFiles:
+		// 超出数量的文件不添加,已有的策略也不能按不完整的结果删除
+		w.log.Warnf("rule=%d, pattern=%s: %v", rule.ID, rule.Pattern, err)
+		err = nil
+		partial = true
+	default:
+		return
+	}
+
+	matched := map[string]bool{}
+	for _, path := range files {
+		matched[path] = true
+		if err = w.addRulePolicy(rule, path); err != nil {
+			return
+		}
+	}
+
+	w.rules[rule.ID] = dirs
+	if partial {
+		return
+	}
+
+	policies, err := w.queryFilePoliciesByRule(rule.ID)
+	if err != nil {
+		return
+	}
+	for _, policy := range policies {
+		if matched[policy.Path] {
+			continue
+		}
+		if policy.Status == file.StatusPolicyNormal {
+			file.SetPolicy(policy.Path, 0, file.FlagAny)
+		}
+		if err = w.deleteFilePolicyById(policy.ID); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// refreshRules 展开新增的规则,忘记已删除的规则
+func (w *FileWorker) refreshRules() {
+	err := apply.Exclusive(func() (err error) {
+		rules, err := w.queryFileRules()
+		if err != nil {
+			return
+		}
+		current := map[int64]bool{}
+		for _, rule := range rules {
+			current[rule.ID] = true
+			if _, ok := w.rules[rule.ID]; ok {
+				continue
+			}
+			if err = w.syncRule(rule); err != nil {
+				return
+			}
+		}
+		for id := range w.rules {
+			if !current[id] {
+				delete(w.rules, id)
+			}
+		}
+		return
+	})
+	if err != nil {
+		w.log.Error(err)
+	}
+}
+
+// expandRules 处理新出现的路径: 新文件按规则添加策略,新目录需要监控并展开其中已有的文件
+func (w *FileWorker) expandRules(path string) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return
+	}
+
+	err = apply.Exclusive(func() (err error) {
+		rules, err := w.queryFileRules()
+		if err != nil {
+			return
+		}
+		for _, rule := range rules {
+			if _, ok := w.rules[rule.ID]; !ok {
+				continue
+			}
+			switch {
+			case info.IsDir() && rule.Watches(path):
+				files, dirs, walkErr := rule.Walk(path)
+				if walkErr != nil {
+					w.log.Error(walkErr)
+					continue
+				}
+				w.rules[rule.ID] = append(w.rules[rule.ID], dirs...)
+				for _, dir := range dirs {
+					w.watcher.Add(dir)
+				}
+				for _, name := range files {
+					if err = w.addRulePolicy(rule, name); err != nil {
+						return
+					}
+				}
+			case info.Mode().IsRegular() && rule.Match(path):
+				if err = w.addRulePolicy(rule, path); err != nil {
+					return
+				}
+			}
+		}
+		return
+	})
+	if err != nil {
+		w.log.Error(err)
+	}
+}
+
+// forgetRuleDirs 目录被删除或移走后不再监控,目录中文件的策略由 resolve 处理
+func (w *FileWorker) forgetRuleDirs(path string) {
+	for id, dirs := range w.rules {
+		kept := dirs[:0]
+		for _, dir := range dirs {
+			if dir != path && !strings.HasPrefix(dir, path+string(filepath.Separator)) {
+				kept = append(kept, dir)
+			}
+		}
+		w.rules[id] = kept
+	}
+}
@@ -0,0 +1,205 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/lanthora/uranus/internal/apply"
+	"github.com/lanthora/uranus/pkg/file"
+)
+
+const (
+	sqlQueryWatchedFilePolicyPath = `select distinct path from file_policy where status in (?,?)`
+	// 查询路径本身及其下的策略. '0' 是 '/' 的下一个字符,范围查询可以使用 file_policy_path 索引, like 不能
+	sqlQueryWatchedFilePolicy = `select id,path,fsid,ino,perm,status,rule from file_policy where (path=?1 or (path>?1||'/' and path<?1||'0')) and status in (?2,?3)`
+)
+
+// 策略由 web 模块直接写入数据库,定期按数据库刷新需要监控的目录
+const watchRefreshInterval = 10 * time.Second
+
+// watch 监控受保护文件所在的目录.
+// 编辑器保存或软件包升级通常以重命名的方式替换文件,文件的 inode 随之改变,
+// 此时需要对新的 inode 重新下发策略并更新数据库,否则文件事件无法关联到策略
+func (w *FileWorker) watch() {
+	defer w.watching.Done()
+
+	ticker := time.NewTicker(watchRefreshInterval)
+	defer ticker.Stop()
+
+	w.refreshRules()
+	w.refreshWatchedDirs()
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				w.log.Info("file watcher exit")
+				return
+			}
+			if event.Has(fsnotify.Create) || event.Has(fsnotify.Rename) || event.Has(fsnotify.Remove) {
+				w.resolveFilePolicy(event.Name)
+			}
+			if event.Has(fsnotify.Create) {
+				w.expandRules(event.Name)
+			}
+			if event.Has(fsnotify.Rename) || event.Has(fsnotify.Remove) {
+				w.forgetRuleDirs(event.Name)
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.log.Error(err)
+		case <-ticker.C:
+			w.refreshRules()
+			w.refreshWatchedDirs()
+		}
+	}
+}
+
+func (w *FileWorker) queryWatchedDirs() (dirs map[string]bool, err error) {
+	stmt, err := w.db.Prepare(sqlQueryWatchedFilePolicyPath)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(file.StatusPolicyNormal, file.StatusPolicyFileNotExist)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	dirs = map[string]bool{}
+	for rows.Next() {
+		path := ""
+		if err = rows.Scan(&path); err != nil {
+			return
+		}
+		dirs[filepath.Dir(filepath.Clean(path))] = true
+	}
+	err = rows.Err()
+
+	for _, ruleDirs := range w.rules {
+		for _, dir := range ruleDirs {
+			dirs[dir] = true
+		}
+	}
+	return
+}
+
+// refreshWatchedDirs 使监控的目录与策略所在的目录和规则展开时的目录一致
+func (w *FileWorker) refreshWatchedDirs() {
+	dirs, err := w.queryWatchedDirs()
+	if err != nil {
+		w.log.Error(err)
+		return
+	}
+
+	for _, dir := range w.watcher.WatchList() {
+		if !dirs[dir] {
+			w.watcher.Remove(dir)
+		}
+	}
+
+	watched := map[string]bool{}
+	for _, dir := range w.watcher.WatchList() {
+		watched[dir] = true
+	}
+	for dir := range dirs {
+		if watched[dir] {
+			continue
+		}
+		// 目录不存在时等下次刷新再尝试
+		if err := w.watcher.Add(dir); err != nil {
+			w.log.Debugf("watch %s: %v", dir, err)
+		}
+	}
+}
+
+func (w *FileWorker) queryWatchedFilePolicies(path string) (policies []file.Policy, err error) {
+	stmt, err := w.db.Prepare(sqlQueryWatchedFilePolicy)
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(path, file.StatusPolicyNormal, file.StatusPolicyFileNotExist)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		policy := file.Policy{}
+		err = rows.Scan(&policy.ID, &policy.Path, &policy.Fsid, &policy.Ino, &policy.Perm, &policy.Status, &policy.Rule)
+		if err != nil {
+			return
+		}
+		// 目录被移走时只有目录本身的事件,其中文件的策略也需要处理
+		policies = append(policies, policy)
+	}
+	err = rows.Err()
+	return
+}
+
+// resolveFilePolicy 在路径指向的 inode 改变后重新下发策略.
+// 先用 FlagUpdate 更新, hackernel 中没有新 inode 的策略时再用 FlagNew 添加
+func (w *FileWorker) resolveFilePolicy(path string) {
+	err := apply.Exclusive(func() (err error) {
+		policies, err := w.queryWatchedFilePolicies(path)
+		if err != nil {
+			return
+		}
+		for _, policy := range policies {
+			if err = w.resolve(policy); err != nil {
+				return
+			}
+		}
+		return
+	})
+	if err != nil {
+		w.log.Error(err)
+	}
+}
+
+func (w *FileWorker) resolve(policy file.Policy) (err error) {
+	info, err := os.Stat(policy.Path)
+	if err != nil {
+		err = nil
+		// 规则展开的策略随文件一起删除,文件重新出现时按规则再添加
+		if policy.Rule != 0 {
+			w.log.Infof("file removed from rule: rule=%d, path=%s", policy.Rule, policy.Path)
+			return w.deleteFilePolicyById(policy.ID)
+		}
+		if policy.Status != file.StatusPolicyFileNotExist {
+			w.log.Warnf("protected file removed: id=%d, path=%s", policy.ID, policy.Path)
+			err = w.updateFilePolcyStatusById(file.StatusPolicyFileNotExist, policy.ID)
+		}
+		return
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if ok && int64(stat.Ino) == policy.Ino && policy.Status == file.StatusPolicyNormal {
+		return
+	}
+
+	fsid, ino, status, err := file.SetPolicy(policy.Path, policy.Perm, file.FlagUpdate)
+	if err == nil && status == file.StatusPolicyFileNotExist {
+		fsid, ino, status, err = file.SetPolicy(policy.Path, policy.Perm, file.FlagNew)
+	}
+	if err != nil {
+		return
+	}
+
+	w.log.Infof("file policy re-resolved: id=%d, path=%s, ino=%d->%d, status=%d", policy.ID, policy.Path, policy.Ino, ino, status)
+	if status == file.StatusPolicyNormal {
+		err = w.updateFilePolcyFsidInoById(fsid, ino, policy.ID)
+		if err != nil {
+			return
+		}
+	}
+	if status != policy.Status {
+		err = w.updateFilePolcyStatusById(status, policy.ID)
+	}
+	return
+}
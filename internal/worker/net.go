@@ -10,6 +10,7 @@ import (
 
 	"github.com/lanthora/uranus/internal/config"
 	"github.com/lanthora/uranus/pkg/connector"
+	"github.com/lanthora/uranus/pkg/logger"
 	"github.com/lanthora/uranus/pkg/net"
 	"github.com/lanthora/uranus/pkg/watchdog"
 	"github.com/sirupsen/logrus"
@@ -30,12 +31,14 @@ type NetWorker struct {
 	conn    *connector.Connector
 	config  *config.Config
 	dog     *watchdog.Watchdog
+	log     *logrus.Entry
 }
 
 func NewNetWorker(db *sql.DB) *NetWorker {
 	w := NetWorker{
 		db:   db,
 		conn: connector.New(),
+		log:  logger.WithComponent("worker", "net"),
 	}
 	return &w
 }
@@ -48,12 +51,12 @@ func (w *NetWorker) Init() (err error) {
 
 	w.config, err = config.New(w.db)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 
 	if err = w.initNetPolicy(); err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 
@@ -67,13 +70,13 @@ func (w *NetWorker) Init() (err error) {
 	case net.StatusEnable:
 		if ok := net.Enable(); !ok {
 			err = net.ErrorEnable
-			logrus.Error(err)
+			w.log.Error(err)
 			return
 		}
 	default:
 		if ok := net.Disable(); !ok {
 			err = net.ErrorDisable
-			logrus.Error(err)
+			w.log.Error(err)
 			return
 		}
 	}
@@ -85,7 +88,7 @@ func (w *NetWorker) Start() (err error) {
 	w.running = true
 	err = w.conn.Connect()
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 
@@ -96,7 +99,7 @@ func (w *NetWorker) Start() (err error) {
 
 	err = w.conn.Send(`{"type":"user::msg::sub","section":"kernel::net::report"}`)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 
@@ -108,27 +111,27 @@ func (w *NetWorker) Start() (err error) {
 func (w *NetWorker) Stop() {
 	err := w.conn.Send(`{"type":"user::msg::unsub","section":"osinfo::report"}`)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 	}
 
 	err = w.conn.Send(`{"type":"user::msg::unsub","section":"kernel::net::report"}`)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 	}
 
 	if ok := net.Disable(); !ok {
-		logrus.Error(net.ErrorDisable)
+		w.log.Error(net.ErrorDisable)
 	}
 
 	if ok := net.ClearPolicy(); !ok {
-		logrus.Error(net.ErrorClearPolicy)
+		w.log.Error(net.ErrorClearPolicy)
 	}
 
 	time.Sleep(time.Second)
 	w.running = false
 	err = w.conn.Shutdown(time.Now())
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 	}
 	w.wg.Wait()
 	w.conn.Close()
@@ -137,34 +140,27 @@ func (w *NetWorker) Stop() {
 func (w *NetWorker) initDB() (err error) {
 	_, err = w.db.Exec(sqlCreateNetPolicyTable)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 
 	_, err = w.db.Exec(sqlCreateNetEventTable)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 
 	return
 }
 
-func (w *NetWorker) initNetPolicy() (err error) {
-	if ok := net.ClearPolicy(); !ok {
-		logrus.Error(net.ErrorClearPolicy)
-		return
-	}
-
+func (w *NetWorker) queryNetPolicies() (policies []net.Policy, err error) {
 	stmt, err := w.db.Prepare(sqlQueryNetPolicy)
 	if err != nil {
-		logrus.Error(err)
 		return
 	}
 	defer stmt.Close()
 	rows, err := stmt.Query()
 	if err != nil {
-		logrus.Error(err)
 		return
 	}
 	defer rows.Close()
@@ -178,33 +174,65 @@ func (w *NetWorker) initNetPolicy() (err error) {
 			&policy.Port.Dst.Begin, &policy.Port.Dst.End,
 			&policy.Flags, &policy.Response)
 		if err != nil {
-			logrus.Error(err)
 			return
 		}
+		policies = append(policies, policy)
+	}
+	err = rows.Err()
+	return
+}
+
+func (w *NetWorker) initNetPolicy() (err error) {
+	if ok := net.ClearPolicy(); !ok {
+		err = net.ErrorClearPolicy
+		w.log.Error(err)
+		return
+	}
+
+	policies, err := w.queryNetPolicies()
+	if err != nil {
+		w.log.Error(err)
+		return
+	}
+	for _, policy := range policies {
 		if ok := net.AddPolicy(policy); !ok {
-			logrus.Error(err)
+			err = net.ErrorAddPolicy
+			w.log.Errorf("id=%d: %v", policy.ID, err)
 			return
 		}
 	}
-	err = rows.Err()
+	return
+}
+
+// replayNetPolicy 不清空 hackernel 中的策略,按数据库逐条重新添加.
+// hackernel 按 id 区分网络策略,已经生效的策略会添加失败,返回添加成功即原本缺失的策略数量
+func (w *NetWorker) replayNetPolicy() (added int, err error) {
+	policies, err := w.queryNetPolicies()
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
+	for _, policy := range policies {
+		if ok := net.AddPolicy(policy); ok {
+			added++
+		}
+	}
 	return
 }
 
 func (w *NetWorker) handleNetEvent(protocol int, saddr, daddr string, sport, dport int, policy int) (err error) {
 	stmt, err := w.db.Prepare(sqlInsertNetEvent)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	defer stmt.Close()
 
+	// 统一地址格式,便于按协议族过滤和比较
+	saddr, daddr = net.CanonicalAddr(saddr), net.CanonicalAddr(daddr)
 	_, err = stmt.Exec(protocol, saddr, daddr, sport, dport, time.Now().Unix(), policy, net.StatusEventUnread)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	return
@@ -223,7 +251,7 @@ func (w *NetWorker) handleMsg(msg string) {
 
 	err := json.Unmarshal([]byte(msg), &event)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	switch event.Type {
@@ -233,7 +261,7 @@ func (w *NetWorker) handleMsg(msg string) {
 			event.SrcPort, event.DstPort,
 			event.Policy)
 		if err != nil {
-			logrus.Error(err)
+			w.log.Error(err)
 		}
 	default:
 	}
@@ -242,7 +270,7 @@ func (w *NetWorker) handleMsg(msg string) {
 func (w *NetWorker) run() {
 	defer w.wg.Done()
 	w.dog = watchdog.New(10*time.Second, func() {
-		logrus.Error("osinfo::report timeout")
+		w.log.Error("osinfo::report timeout")
 		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
 	})
 	defer w.dog.Stop()
@@ -250,12 +278,12 @@ func (w *NetWorker) run() {
 		msg, err := w.conn.Recv()
 
 		if !w.running {
-			logrus.Info("net worker exit")
+			w.log.Info("net worker exit")
 			break
 		}
 
 		if err != nil {
-			logrus.Error(err)
+			w.log.Error(err)
 			continue
 		}
 		w.dog.Kick()
@@ -10,6 +10,7 @@ import (
 
 	"github.com/lanthora/uranus/internal/config"
 	"github.com/lanthora/uranus/pkg/connector"
+	"github.com/lanthora/uranus/pkg/logger"
 	"github.com/lanthora/uranus/pkg/process"
 	"github.com/lanthora/uranus/pkg/watchdog"
 	"github.com/sirupsen/logrus"
@@ -20,6 +21,8 @@ const (
 	sqlUpdateProcessCount    = `update process_event set count=count+1,judge=?,status=? where workdir=? and binary=? and argv=?`
 	sqlInsertProcessEvent    = `insert into process_event(workdir,binary,argv,count,judge,status) values(?,?,?,1,?,?)`
 	sqlQueryAllowedProcesses = `select workdir,binary,argv from process_event where status=2`
+	// 按命令行更新进程事件和文件事件关联进程时使用
+	sqlCreateProcessCmdIndex = `create index if not exists process_event_cmd on process_event(binary,argv,workdir)`
 )
 
 type ProcessWorker struct {
@@ -30,12 +33,14 @@ type ProcessWorker struct {
 	conn    *connector.Connector
 	config  *config.Config
 	dog     *watchdog.Watchdog
+	log     *logrus.Entry
 }
 
 func NewProcessWorker(db *sql.DB) *ProcessWorker {
 	w := ProcessWorker{
 		db:   db,
 		conn: connector.New(),
+		log:  logger.WithComponent("worker", "process"),
 	}
 	return &w
 }
@@ -48,7 +53,7 @@ func (w *ProcessWorker) Init() (err error) {
 
 	w.config, err = config.New(w.db)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 
@@ -114,26 +119,26 @@ func (w *ProcessWorker) Start() (err error) {
 func (w *ProcessWorker) Stop() {
 	err := w.conn.Send(`{"type":"user::msg::unsub","section":"audit::proc::report"}`)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 	}
 	err = w.conn.Send(`{"type":"user::msg::unsub","section":"osinfo::report"}`)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 	}
 
 	if ok := process.Disable(); !ok {
-		logrus.Error(process.ErrorDisable)
+		w.log.Error(process.ErrorDisable)
 	}
 
 	if ok := process.ClearPolicy(); !ok {
-		logrus.Error(process.ErrorClearPolicy)
+		w.log.Error(process.ErrorClearPolicy)
 	}
 
 	time.Sleep(time.Second)
 	w.running = false
 	err = w.conn.Shutdown(time.Now())
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 	}
 	w.wg.Wait()
 	w.conn.Close()
@@ -142,46 +147,75 @@ func (w *ProcessWorker) Stop() {
 func (w *ProcessWorker) initDB() (err error) {
 	_, err = w.db.Exec(sqlCreateProcessTable)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
+		return
+	}
+
+	_, err = w.db.Exec(sqlCreateProcessCmdIndex)
+	if err != nil {
+		w.log.Error(err)
 		return
 	}
 
 	return
 }
 
-func (w *ProcessWorker) initTrustedCmd() (err error) {
-	if ok := process.ClearPolicy(); !ok {
-		logrus.Error(process.ErrorClearPolicy)
-		return
-	}
+type trustedCmd struct {
+	workdir string
+	binary  string
+	argv    string
+}
 
+func (w *ProcessWorker) queryTrustedCmds() (cmds []trustedCmd, err error) {
 	stmt, err := w.db.Prepare(sqlQueryAllowedProcesses)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	defer stmt.Close()
 	rows, err := stmt.Query()
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	defer rows.Close()
 	for rows.Next() {
-		workdir := ""
-		binary := ""
-		argv := ""
-		err = rows.Scan(&workdir, &binary, &argv)
+		cmd := trustedCmd{}
+		err = rows.Scan(&cmd.workdir, &cmd.binary, &cmd.argv)
 		if err != nil {
 			return
 		}
-		process.SetTrustedCmd(workdir, binary, argv)
+		cmds = append(cmds, cmd)
 	}
 	err = rows.Err()
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
+		return
+	}
+	return
+}
+
+func (w *ProcessWorker) initTrustedCmd() (err error) {
+	if ok := process.ClearPolicy(); !ok {
+		err = process.ErrorClearPolicy
+		w.log.Error(err)
+		return
+	}
+	return w.replayTrustedCmd()
+}
+
+// replayTrustedCmd 不清空 hackernel 中的可信命令,按数据库逐条重新添加
+func (w *ProcessWorker) replayTrustedCmd() (err error) {
+	cmds, err := w.queryTrustedCmds()
+	if err != nil {
 		return
 	}
+	for _, cmd := range cmds {
+		err = process.SetTrustedCmd(cmd.workdir, cmd.binary, cmd.argv)
+		if err != nil {
+			return
+		}
+	}
 	return
 }
 
@@ -201,18 +235,18 @@ func (w *ProcessWorker) updateCmd(workdir, binary, argv string, judge int) (err
 
 	stmt, err := w.db.Prepare(sqlUpdateProcessCount)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	defer stmt.Close()
 	result, err := stmt.Exec(judge, status, workdir, binary, argv)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	affected, err := result.RowsAffected()
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 
@@ -222,14 +256,14 @@ func (w *ProcessWorker) updateCmd(workdir, binary, argv string, judge int) (err
 
 	stmt, err = w.db.Prepare(sqlInsertProcessEvent)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	defer stmt.Close()
 
 	_, err = stmt.Exec(workdir, binary, argv, judge, status)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		return
 	}
 	return
@@ -246,7 +280,7 @@ func (w *ProcessWorker) handleMsg(msg string) {
 
 	err := json.Unmarshal([]byte(msg), &event)
 	if err != nil {
-		logrus.Error(err)
+		w.log.Error(err)
 		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
 		return
 	}
@@ -254,7 +288,7 @@ func (w *ProcessWorker) handleMsg(msg string) {
 	case "audit::proc::report":
 		err = w.updateCmd(event.Workdir, event.Binary, event.Argv, event.Judge)
 		if err != nil {
-			logrus.Error(err)
+			w.log.Error(err)
 		}
 	default:
 	}
@@ -263,7 +297,7 @@ func (w *ProcessWorker) handleMsg(msg string) {
 func (w *ProcessWorker) run() {
 	defer w.wg.Done()
 	w.dog = watchdog.New(10*time.Second, func() {
-		logrus.Error("osinfo::report timeout")
+		w.log.Error("osinfo::report timeout")
 		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
 	})
 	defer w.dog.Stop()
@@ -271,12 +305,12 @@ func (w *ProcessWorker) run() {
 		msg, err := w.conn.Recv()
 
 		if !w.running {
-			logrus.Info("process worker exit")
+			w.log.Info("process worker exit")
 			break
 		}
 
 		if err != nil {
-			logrus.Error(err)
+			w.log.Error(err)
 			continue
 		}
 		w.dog.Kick()
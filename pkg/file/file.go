@@ -37,27 +37,45 @@ var (
 	ErrorEnable      = errors.New("file protection enable failed")
 	ErrorDisable     = errors.New("file protection disable failed")
 	ErrorClearPolicy = errors.New("clear file policy failed")
+
+	ErrorPolicyUnknown  = errors.New("file policy unknown error")
+	ErrorPolicyConflict = errors.New("file policy conflict")
+	ErrorFileNotExist   = errors.New("file does not exist")
+	// 规则展开的文件策略只能随规则一起删除
+	ErrorPolicyOwnedByRule = errors.New("file policy belongs to a rule")
 )
 
 type Policy struct {
-	ID        int64  `json:"id"`
-	Path      string `json:"path"`
-	Fsid      int64  `json:"fsid"`
-	Ino       int64  `json:"ino"`
-	Perm      int    `json:"perm"`
-	Timestamp int64  `json:"timestamp"`
-	Status    int    `json:"status"`
+	ID        int64    `json:"id"`
+	Path      string   `json:"path"`
+	Fsid      int64    `json:"fsid"`
+	Ino       int64    `json:"ino"`
+	Perm      int      `json:"perm"`
+	PermNames []string `json:"permNames"`
+	Timestamp int64    `json:"timestamp"`
+	Status    int      `json:"status"`
+	// 展开出该策略的规则, 0 表示单独添加的策略
+	Rule int64 `json:"rule"`
 }
 
 type Event struct {
-	ID        int64  `json:"id"`
-	Path      string `json:"path"`
-	Fsid      int64  `json:"fsid"`
-	Ino       int64  `json:"ino"`
-	Perm      int    `json:"perm"`
-	Timestamp int64  `json:"timestamp"`
-	Policy    int64  `json:"policy"`
-	Status    int    `json:"status"`
+	ID        int64    `json:"id"`
+	Path      string   `json:"path"`
+	Fsid      int64    `json:"fsid"`
+	Ino       int64    `json:"ino"`
+	Perm      int      `json:"perm"`
+	PermNames []string `json:"permNames"`
+	Timestamp int64    `json:"timestamp"`
+	Policy    int64    `json:"policy"`
+	Status    int      `json:"status"`
+	// 访问文件的进程, hackernel 没有上报时 pid 为 0, uid 为 -1
+	Pid     int    `json:"pid"`
+	Uid     int    `json:"uid"`
+	Workdir string `json:"workdir"`
+	Binary  string `json:"binary"`
+	Argv    string `json:"argv"`
+	// 关联的进程事件, 0 表示没有找到
+	Process int64 `json:"process"`
 }
 
 func SetPolicy(path string, perm, flag int) (fsid, ino int64, status int, err error) {
@@ -104,6 +122,20 @@ func SetPolicy(path string, perm, flag int) (fsid, ino int64, status int, err er
 	return
 }
 
+// StatusError 把 SetPolicy 返回的策略状态转换成错误, StatusPolicyNormal 返回 nil
+func StatusError(status int) error {
+	switch status {
+	case StatusPolicyNormal:
+		return nil
+	case StatusPolicyConflict:
+		return ErrorPolicyConflict
+	case StatusPolicyFileNotExist:
+		return ErrorFileNotExist
+	default:
+		return ErrorPolicyUnknown
+	}
+}
+
 func Enable() bool {
 	tmp, err := exector.Exec(`{"type":"user::file::enable"}`, time.Second)
 	if err != nil {
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// 文件权限的各个位,与 hackernel 中的定义一致.
+// 低 4 位禁止对应的操作,高 4 位只记录对应的操作
+const (
+	PermReadProtect   = 1 << 0
+	PermWriteProtect  = 1 << 1
+	PermUnlinkProtect = 1 << 2
+	PermRenameProtect = 1 << 3
+	PermReadAudit     = 1 << 4
+	PermWriteAudit    = 1 << 5
+	PermUnlinkAudit   = 1 << 6
+	PermRenameAudit   = 1 << 7
+
+	PermAllProtect = PermReadProtect | PermWriteProtect | PermUnlinkProtect | PermRenameProtect
+	PermAllAudit   = PermReadAudit | PermWriteAudit | PermUnlinkAudit | PermRenameAudit
+	PermAll        = PermAllProtect | PermAllAudit
+)
+
+var (
+	ErrorInvalidPerm = errors.New("invalid file permission")
+)
+
+// permNames 按位从低到高排列,解码时按这个顺序输出
+var permNames = []struct {
+	name string
+	perm int
+}{
+	{"read", PermReadProtect},
+	{"write", PermWriteProtect},
+	{"unlink", PermUnlinkProtect},
+	{"rename", PermRenameProtect},
+	{"audit-read", PermReadAudit},
+	{"audit-write", PermWriteAudit},
+	{"audit-unlink", PermUnlinkAudit},
+	{"audit-rename", PermRenameAudit},
+}
+
+// PermNames 把权限解码成名称列表,不认识的位被忽略
+func PermNames(perm int) []string {
+	names := []string{}
+	for _, p := range permNames {
+		if perm&p.perm != 0 {
+			names = append(names, p.name)
+		}
+	}
+	return names
+}
+
+// ParsePerm 把名称列表编码成权限, all 和 audit-all 分别表示所有禁止和所有记录的位
+func ParsePerm(names []string) (perm int, err error) {
+	for _, name := range names {
+		switch name = strings.ToLower(strings.TrimSpace(name)); name {
+		case "all":
+			perm |= PermAllProtect
+			continue
+		case "audit-all":
+			perm |= PermAllAudit
+			continue
+		}
+		found := false
+		for _, p := range permNames {
+			if p.name == name {
+				perm |= p.perm
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, ErrorInvalidPerm
+		}
+	}
+	return
+}
+
+// Perm 是接口请求中的权限,可以是整数,也可以是 ["write","unlink"] 形式的名称列表
+type Perm int
+
+func (p *Perm) UnmarshalJSON(data []byte) (err error) {
+	value := 0
+	if err = json.Unmarshal(data, &value); err == nil {
+		if value < 0 || value&^PermAll != 0 {
+			return ErrorInvalidPerm
+		}
+		*p = Perm(value)
+		return
+	}
+
+	names := []string{}
+	if err = json.Unmarshal(data, &names); err != nil {
+		return ErrorInvalidPerm
+	}
+	value, err = ParsePerm(names)
+	*p = Perm(value)
+	return
+}
+
+// MarshalJSON 输出时根据 Perm 填充 PermNames, 数据库中只保存 Perm
+func (p Policy) MarshalJSON() ([]byte, error) {
+	type policy Policy
+	p.PermNames = PermNames(p.Perm)
+	return json.Marshal(policy(p))
+}
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	type event Event
+	e.PermNames = PermNames(e.Perm)
+	return json.Marshal(event(e))
+}
+
+func (r Rule) MarshalJSON() ([]byte, error) {
+	type rule Rule
+	r.PermNames = PermNames(r.Perm)
+	return json.Marshal(rule(r))
+}
@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package file
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+const (
+	// 目录下的文件,不包含子目录
+	RuleTypeDirectory = 1
+	// 目录及所有子目录下的文件
+	RuleTypeRecursive = 2
+	// 与通配符匹配的文件, * 不匹配目录分隔符, ** 可以匹配多级目录
+	RuleTypeGlob = 3
+)
+
+// RuleMaxFiles 是一条规则最多展开的文件数量,避免误把大量文件加入策略
+const RuleMaxFiles = 4096
+
+var (
+	ErrorInvalidRule      = errors.New("invalid file rule")
+	ErrorRuleTooManyFiles = errors.New("file rule matches too many files")
+)
+
+// Rule 是目录或通配符形式的文件策略,展开成多条属于该规则的文件策略
+type Rule struct {
+	ID        int64    `json:"id"`
+	Pattern   string   `json:"pattern"`
+	Type      int      `json:"type"`
+	Perm      int      `json:"perm"`
+	PermNames []string `json:"permNames"`
+	Timestamp int64    `json:"timestamp"`
+	// 当前展开的文件策略数量
+	Policies int `json:"policies"`
+}
+
+func (r *Rule) Validate() (err error) {
+	if !filepath.IsAbs(r.Pattern) {
+		return ErrorInvalidRule
+	}
+	switch r.Type {
+	case RuleTypeDirectory, RuleTypeRecursive:
+	case RuleTypeGlob:
+		if _, err = glob.Compile(r.Pattern, '/'); err != nil {
+			return ErrorInvalidRule
+		}
+	default:
+		return ErrorInvalidRule
+	}
+	return
+}
+
+// Root 返回需要遍历的起始目录,通配符规则取第一个包含通配符的路径分量之前的部分
+func (r *Rule) Root() string {
+	if r.Type != RuleTypeGlob {
+		return filepath.Clean(r.Pattern)
+	}
+	root := "/"
+	for _, part := range strings.Split(strings.Trim(r.Pattern, "/"), "/") {
+		if strings.ContainsAny(part, `*?[]{}\`) {
+			break
+		}
+		root = filepath.Join(root, part)
+	}
+	return root
+}
+
+// depth 返回文件相对 Root 的最大层数,小于 0 时不限制
+func (r *Rule) depth() int {
+	switch r.Type {
+	case RuleTypeDirectory:
+		return 1
+	case RuleTypeGlob:
+		if strings.Contains(r.Pattern, "**") {
+			return -1
+		}
+		return levels(r.Root(), filepath.Clean(r.Pattern))
+	default:
+		return -1
+	}
+}
+
+// levels 返回 path 相对 root 的层数, path 不在 root 下时返回 -1
+func levels(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return -1
+	}
+	if rel == "." {
+		return 0
+	}
+	return strings.Count(rel, "/") + 1
+}
+
+func (r *Rule) matcher() func(path string) bool {
+	if r.Type == RuleTypeGlob {
+		g, err := glob.Compile(r.Pattern, '/')
+		if err != nil {
+			return func(string) bool { return false }
+		}
+		return g.Match
+	}
+	return func(string) bool { return true }
+}
+
+// contains 判断路径是否在规则的目录层数范围内
+func (r *Rule) contains(path string) bool {
+	n, depth := levels(r.Root(), path), r.depth()
+	return n >= 1 && (depth < 0 || n <= depth)
+}
+
+// Match 判断文件路径是否属于规则
+func (r *Rule) Match(path string) bool {
+	path = filepath.Clean(path)
+	return r.contains(path) && r.matcher()(path)
+}
+
+// Watches 判断目录中新增的文件是否可能属于规则
+func (r *Rule) Watches(dir string) bool {
+	n, depth := levels(r.Root(), filepath.Clean(dir)), r.depth()
+	return n >= 0 && (depth < 0 || n < depth)
+}
+
+// Walk 从 start 开始遍历规则范围内的目录,返回属于规则的普通文件和需要监控的目录.
+// start 为空时从 Root 开始,不跟随符号链接,无法读取的子目录会被跳过
+func (r *Rule) Walk(start string) (files, dirs []string, err error) {
+	if start == "" {
+		start = r.Root()
+	}
+	if _, err = os.Stat(start); err != nil {
+		return nil, nil, ErrorFileNotExist
+	}
+
+	match := r.matcher()
+	err = filepath.WalkDir(start, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == start {
+				return err
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if !r.Watches(path) {
+				return filepath.SkipDir
+			}
+			dirs = append(dirs, path)
+			return nil
+		}
+		if d.Type().IsRegular() && r.contains(path) && match(path) {
+			if len(files) == RuleMaxFiles {
+				return ErrorRuleTooManyFiles
+			}
+			files = append(files, path)
+		}
+		return nil
+	})
+	return
+}
@@ -3,14 +3,40 @@ package logger
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"runtime"
+	"sort"
 	"strings"
 
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var (
+	ErrorInvalidFormat = errors.New("invalid log format")
+)
+
 type logFormatter struct{}
 
+type Config struct {
+	Level      string
+	Format     string
+	Output     string
+	MaxSize    int64
+	MaxBackups int
+	Fields     map[string]string
+}
+
+type fieldsHook struct {
+	fields logrus.Fields
+}
+
 var (
 	BuildDir string = "Undefined"
 )
@@ -21,6 +47,85 @@ func InitLogrusFormat() {
 	logrus.SetLevel(logrus.DebugLevel)
 }
 
+// Init 在 InitLogrusFormat 的基础上按配置调整日志级别,格式和输出位置
+func Init(config Config) (err error) {
+	InitLogrusFormat()
+
+	if config.Level != "" {
+		if err = SetLevel(config.Level); err != nil {
+			return
+		}
+	}
+
+	switch config.Format {
+	case "", FormatText:
+		logrus.SetFormatter(&logFormatter{})
+	case FormatJSON:
+		logrus.SetFormatter(&logrus.JSONFormatter{
+			TimestampFormat:  "2006-01-02 15:04:05",
+			CallerPrettyfier: callerPrettyfier,
+		})
+	default:
+		err = ErrorInvalidFormat
+		return
+	}
+
+	if config.Output != "" {
+		var output io.Writer
+		output, err = newRotateWriter(config.Output, config.MaxSize, config.MaxBackups)
+		if err != nil {
+			return
+		}
+		logrus.SetOutput(output)
+	}
+
+	logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	if len(config.Fields) != 0 {
+		hook := &fieldsHook{fields: logrus.Fields{}}
+		for key, value := range config.Fields {
+			hook.fields[key] = value
+		}
+		logrus.AddHook(hook)
+	}
+	return
+}
+
+func SetLevel(level string) (err error) {
+	l, err := logrus.ParseLevel(level)
+	if err != nil {
+		return
+	}
+	logrus.SetLevel(l)
+	return
+}
+
+func GetLevel() string {
+	return logrus.GetLevel().String()
+}
+
+// WithComponent 返回带有组件字段的日志入口,例如 worker=process
+func WithComponent(key, value string) *logrus.Entry {
+	return logrus.WithField(key, value)
+}
+
+func (h *fieldsHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *fieldsHook) Fire(entry *logrus.Entry) error {
+	for key, value := range h.fields {
+		if _, ok := entry.Data[key]; !ok {
+			entry.Data[key] = value
+		}
+	}
+	return nil
+}
+
+func callerPrettyfier(frame *runtime.Frame) (function string, file string) {
+	file = fmt.Sprintf("%s:%d", strings.TrimPrefix(frame.File, BuildDir), frame.Line)
+	return
+}
+
 func (f *logFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	b := &bytes.Buffer{}
 	if entry.Buffer != nil {
@@ -29,13 +134,30 @@ func (f *logFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 
 	timestamp := entry.Time.Format("2006-01-02 15:04:05")
 	msg := ""
-	file := strings.TrimPrefix(entry.Caller.File, BuildDir)
 	if entry.HasCaller() {
-		msg = fmt.Sprintf("[%s] [%s] [%s:%d] %s\n",
-			timestamp, entry.Level, file, entry.Caller.Line, entry.Message)
+		file := strings.TrimPrefix(entry.Caller.File, BuildDir)
+		msg = fmt.Sprintf("[%s] [%s] [%s:%d] %s%s\n",
+			timestamp, entry.Level, file, entry.Caller.Line, formatFields(entry.Data), entry.Message)
 	} else {
-		msg = fmt.Sprintf("[%s] [%s] %s\n", timestamp, entry.Level, entry.Message)
+		msg = fmt.Sprintf("[%s] [%s] %s%s\n", timestamp, entry.Level, formatFields(entry.Data), entry.Message)
 	}
 	b.WriteString(msg)
 	return b.Bytes(), nil
 }
+
+func formatFields(data logrus.Fields) string {
+	if len(data) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fields := make([]string, 0, len(keys))
+	for _, key := range keys {
+		fields = append(fields, fmt.Sprintf("%s=%v", key, data[key]))
+	}
+	return fmt.Sprintf("[%s] ", strings.Join(fields, " "))
+}
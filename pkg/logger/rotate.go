@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	defaultMaxSize    = 10 << 20
+	defaultMaxBackups = 3
+)
+
+// rotateWriter 按文件大小切割日志,保留 path.1 到 path.N 共 N 个历史文件
+type rotateWriter struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+func newRotateWriter(path string, maxSize int64, maxBackups int) (w *rotateWriter, err error) {
+	if maxSize <= 0 {
+		maxSize = defaultMaxSize
+	}
+	if maxBackups <= 0 {
+		maxBackups = defaultMaxBackups
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return
+	}
+
+	w = &rotateWriter{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err = w.open(); err != nil {
+		return
+	}
+	return
+}
+
+func (w *rotateWriter) open() (err error) {
+	file, size, err := openLogFile(w.path)
+	if err != nil {
+		return
+	}
+	w.file = file
+	w.size = size
+	return
+}
+
+func openLogFile(path string) (file *os.File, size int64, err error) {
+	file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return
+	}
+	size = info.Size()
+	return
+}
+
+// rotate 先把日志文件重命名为临时文件,成功后才移动历史文件,避免失败时反复移动导致历史文件丢失.
+// 打开新文件成功后才替换并关闭旧文件,失败时继续写入旧文件
+func (w *rotateWriter) rotate() (err error) {
+	rotating := w.path + ".rotating"
+	renamed := true
+	if err = os.Rename(w.path, rotating); err != nil {
+		// 日志文件被删除时直接创建新文件
+		if !os.IsNotExist(err) {
+			return
+		}
+		renamed = false
+	}
+	if renamed {
+		for i := w.maxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+		}
+		if err = os.Rename(rotating, w.path+".1"); err != nil {
+			return
+		}
+	}
+	file, size, err := openLogFile(w.path)
+	if err != nil {
+		return
+	}
+	w.file.Close()
+	w.file = file
+	w.size = size
+	return
+}
+
+func (w *rotateWriter) Write(p []byte) (n int, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.size+int64(len(p)) > w.maxSize && w.size > 0 {
+		if err := w.rotate(); err != nil {
+			// 写满 maxSize 后再重试,不在每次写入时重复切割和输出错误
+			w.size = 0
+			fmt.Fprintf(os.Stderr, "rotate log file %s: %v\n", w.path, err)
+		}
+	}
+
+	n, err = w.file.Write(p)
+	w.size += int64(n)
+	return
+}
@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func write(t *testing.T, w *rotateWriter, text string) {
+	t.Helper()
+	if _, err := w.Write([]byte(text)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "uranus.log")
+	w, err := newRotateWriter(path, 4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.file.Close()
+
+	for _, text := range []string{"aaaa", "bbbb", "cccc", "dddd"} {
+		write(t, w, text)
+	}
+
+	files := map[string]string{
+		path:        "dddd",
+		path + ".1": "cccc",
+		path + ".2": "bbbb",
+		path + ".3": "",
+	}
+	for name, want := range files {
+		if got := readFile(t, name); got != want {
+			t.Errorf("%s: got %q, want %q", filepath.Base(name), got, want)
+		}
+	}
+}
+
+func TestRotateFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		// block 让切割失败, 返回的函数恢复正常
+		block func(t *testing.T, dir, path string) func()
+	}{
+		{
+			name: "read-only directory",
+			block: func(t *testing.T, dir, path string) func() {
+				if os.Geteuid() == 0 {
+					t.Skip("root ignores directory permissions")
+				}
+				if err := os.Chmod(dir, 0500); err != nil {
+					t.Fatal(err)
+				}
+				return func() { os.Chmod(dir, 0700) }
+			},
+		},
+		{
+			name: "rename blocked",
+			block: func(t *testing.T, dir, path string) func() {
+				if err := os.MkdirAll(filepath.Join(path+".rotating", "busy"), 0700); err != nil {
+					t.Fatal(err)
+				}
+				return func() { os.RemoveAll(path + ".rotating") }
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "uranus.log")
+			for name, text := range map[string]string{path + ".1": "backup1", path + ".2": "backup2"} {
+				if err := os.WriteFile(name, []byte(text), 0640); err != nil {
+					t.Fatal(err)
+				}
+			}
+			w, err := newRotateWriter(path, 4, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.file.Close()
+			write(t, w, "aaaa")
+
+			restore := test.block(t, dir, path)
+			for _, text := range []string{"bbbb", "cc", "dd", "eeee", "ffff"} {
+				write(t, w, text)
+			}
+			if w.size > w.maxSize {
+				t.Errorf("size %d is still above %d after a failed rotation", w.size, w.maxSize)
+			}
+			// 切割失败时不能移动历史文件,日志继续写入原来的文件
+			if got := readFile(t, path+".1"); got != "backup1" {
+				t.Errorf("uranus.log.1: got %q, want %q", got, "backup1")
+			}
+			if got := readFile(t, path+".2"); got != "backup2" {
+				t.Errorf("uranus.log.2: got %q, want %q", got, "backup2")
+			}
+			if got := readFile(t, path); got != "aaaabbbbccddeeeeffff" {
+				t.Errorf("uranus.log: got %q, want %q", got, "aaaabbbbccddeeeeffff")
+			}
+
+			restore()
+			write(t, w, "gggg")
+			write(t, w, "hhhh")
+			if got := readFile(t, path+".1"); got != "gggg" {
+				t.Errorf("uranus.log.1: got %q, want %q", got, "gggg")
+			}
+			if got := readFile(t, path+".2"); got != "aaaabbbbccddeeeeffff" {
+				t.Errorf("uranus.log.2: got %q, want %q", got, "aaaabbbbccddeeeeffff")
+			}
+		})
+	}
+}
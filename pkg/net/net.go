@@ -23,6 +23,7 @@ var (
 	ErrorEnable         = errors.New("net protection enable failed")
 	ErrorDisable        = errors.New("net protection disable failed")
 	ErrorClearPolicy    = errors.New("clear net policy failed")
+	ErrorAddPolicy      = errors.New("add net policy failed")
 	ErrorPolicyNotExist = errors.New("net policy does not exist")
 )
 
@@ -64,6 +65,7 @@ type Event struct {
 	DstAddr   string `json:"daddr"`
 	SrcPort   int    `json:"sport"`
 	DstPort   int    `json:"dport"`
+	Family    int    `json:"family"`
 	Timestamp int64  `json:"timestamp"`
 	Policy    int64  `json:"policy"`
 	Status    int    `json:"status"`
@@ -127,6 +129,31 @@ func DeletePolicy(id int) bool {
 	return response.Code == 0
 }
 
+// ReplacePolicy 把 hackernel 中 old.ID 对应的策略替换为 policy,替换后 id 不变.
+// 先用 temporary 作为 id 添加新策略,再替换原来的策略,过程中始终有策略生效.
+// 任何一步失败时撤销已经执行的操作,恢复原来的策略
+func ReplacePolicy(old, policy Policy, temporary int64) bool {
+	policy.ID = old.ID
+	transition := policy
+	transition.ID = temporary
+
+	if !AddPolicy(transition) {
+		return false
+	}
+	if !DeletePolicy(int(old.ID)) {
+		DeletePolicy(int(temporary))
+		return false
+	}
+	if !AddPolicy(policy) {
+		AddPolicy(old)
+		DeletePolicy(int(temporary))
+		return false
+	}
+	// 过渡策略与新策略完全相同,删除失败不影响防护效果,下次启动时会被清理
+	DeletePolicy(int(temporary))
+	return true
+}
+
 func Enable() bool {
 	tmp, err := exector.Exec(`{"type":"user::net::enable"}`, time.Second)
 	if err != nil {
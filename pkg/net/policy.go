@@ -0,0 +1,220 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package net
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// 与 hackernel 中网络策略的定义保持一致
+const (
+	ResponseAccept = 0
+	ResponseDrop   = 1
+)
+
+const (
+	FlagInbound       = 1 << 0
+	FlagOutbound      = 1 << 1
+	FlagTCPHandshake  = 1 << 2
+	FlagTCPHeaderOnly = 1 << 3
+
+	flagMask = FlagInbound | FlagOutbound | FlagTCPHandshake | FlagTCPHeaderOnly
+)
+
+const (
+	FamilyIPv4 = 4
+	FamilyIPv6 = 6
+)
+
+const (
+	ProtocolAnyBegin = 0
+	ProtocolAnyEnd   = 255
+	PortAnyBegin     = 0
+	PortAnyEnd       = 65535
+)
+
+var (
+	ErrorInvalidAddr     = errors.New("invalid net policy address")
+	ErrorInvalidRange    = errors.New("net policy range begin is greater than end")
+	ErrorInvalidResponse = errors.New("invalid net policy response")
+	ErrorInvalidFlags    = errors.New("invalid net policy flags")
+	ErrorFamilyMismatch  = errors.New("net policy address family mismatch")
+)
+
+// protocols 是规则中可以使用的协议名称,其他协议使用 0 到 255 的协议号
+var protocols = map[string]uint8{
+	"icmp":    1,
+	"igmp":    2,
+	"tcp":     6,
+	"udp":     17,
+	"gre":     47,
+	"esp":     50,
+	"ah":      51,
+	"sctp":    132,
+	"icmpv6":  58,
+	"udplite": 136,
+}
+
+func protocolName(number uint8) (name string, ok bool) {
+	for name, n := range protocols {
+		if n == number {
+			return name, true
+		}
+	}
+	return
+}
+
+// parseAddr 解析 IPv4 或 IPv6 地址, IPv4-mapped 的 IPv6 地址按 IPv4 处理,不接受带 zone 的地址
+func parseAddr(value string) (addr netip.Addr, err error) {
+	addr, err = netip.ParseAddr(value)
+	if err != nil || addr.Zone() != "" {
+		err = fmt.Errorf("%w: %q", ErrorInvalidAddr, value)
+		return
+	}
+	addr = addr.Unmap()
+	return
+}
+
+func addrFamily(addr netip.Addr) int {
+	if addr.Is4() {
+		return FamilyIPv4
+	}
+	return FamilyIPv6
+}
+
+// AddrFamily 返回地址的协议族,地址无效时返回 0
+func AddrFamily(value string) int {
+	addr, err := parseAddr(value)
+	if err != nil {
+		return 0
+	}
+	return addrFamily(addr)
+}
+
+// CanonicalAddr 返回地址的规范形式,例如 IPv6 地址使用小写并压缩连续的 0,无效地址原样返回
+func CanonicalAddr(value string) string {
+	addr, err := parseAddr(value)
+	if err != nil {
+		return value
+	}
+	return addr.String()
+}
+
+// JoinHostPort 拼接地址和端口, IPv6 地址使用 [addr]:port 的形式
+func JoinHostPort(host string, port int) string {
+	if strings.Contains(host, ":") {
+		return fmt.Sprintf("[%s]:%d", host, port)
+	}
+	return fmt.Sprintf("%s:%d", host, port)
+}
+
+// anyRange 返回协议族的全部地址
+func anyRange(family int) (begin, end string) {
+	if family == FamilyIPv6 {
+		return addrAnyBeginIPv6, addrAnyEndIPv6
+	}
+	return addrAnyBegin, addrAnyEnd
+}
+
+// prefixRange 返回 CIDR 表示的地址段的第一个和最后一个地址
+func prefixRange(value string) (begin, end netip.Addr, err error) {
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil || prefix.Addr().Zone() != "" {
+		err = fmt.Errorf("%w: %q", ErrorInvalidAddr, value)
+		return
+	}
+	begin = prefix.Masked().Addr()
+	bytes := begin.AsSlice()
+	for i := prefix.Bits(); i < len(bytes)*8; i++ {
+		bytes[i/8] |= 1 << (7 - i%8)
+	}
+	end, _ = netip.AddrFromSlice(bytes)
+	return
+}
+
+// normalizeAddr 把 CIDR 或者单个地址转换成地址范围,并把地址转换成规范形式
+func normalizeAddr(begin, end *string) (err error) {
+	if *end == "" && strings.Contains(*begin, "/") {
+		var first, last netip.Addr
+		if first, last, err = prefixRange(*begin); err != nil {
+			return
+		}
+		*begin, *end = first.String(), last.String()
+	} else if *end == "" {
+		*end = *begin
+	}
+	*begin, *end = CanonicalAddr(*begin), CanonicalAddr(*end)
+	return
+}
+
+// validateAddr 检查地址范围并返回它的协议族,不同协议族的地址之间无法比较大小
+func validateAddr(begin, end string) (family int, err error) {
+	first, err := parseAddr(begin)
+	if err != nil {
+		return
+	}
+	last, err := parseAddr(end)
+	if err != nil {
+		return
+	}
+	if first.Is4() != last.Is4() {
+		err = fmt.Errorf("%w: %s-%s", ErrorFamilyMismatch, begin, end)
+		return
+	}
+	if first.Compare(last) > 0 {
+		err = fmt.Errorf("%w: %s-%s", ErrorInvalidRange, begin, end)
+		return
+	}
+	family = addrFamily(first)
+	return
+}
+
+// Family 返回策略的协议族,地址无效时返回 0
+func (p *Policy) Family() int {
+	return AddrFamily(p.Addr.Src.Begin)
+}
+
+// Normalize 把 begin 中的 CIDR 展开为地址范围, end 为空时表示单个地址
+func (p *Policy) Normalize() (err error) {
+	if err = normalizeAddr(&p.Addr.Src.Begin, &p.Addr.Src.End); err != nil {
+		return
+	}
+	err = normalizeAddr(&p.Addr.Dst.Begin, &p.Addr.Dst.End)
+	return
+}
+
+// Validate 检查策略能否被 hackernel 正确处理,需要在 Normalize 之后调用
+func (p *Policy) Validate() (err error) {
+	src, err := validateAddr(p.Addr.Src.Begin, p.Addr.Src.End)
+	if err != nil {
+		return
+	}
+	dst, err := validateAddr(p.Addr.Dst.Begin, p.Addr.Dst.End)
+	if err != nil {
+		return
+	}
+	if src != dst {
+		return fmt.Errorf("%w: %s -> %s", ErrorFamilyMismatch, p.Addr.Src.Begin, p.Addr.Dst.Begin)
+	}
+
+	if p.Protocol.Begin > p.Protocol.End {
+		return fmt.Errorf("%w: protocol %d-%d", ErrorInvalidRange, p.Protocol.Begin, p.Protocol.End)
+	}
+
+	if p.Port.Src.Begin > p.Port.Src.End {
+		return fmt.Errorf("%w: port %d-%d", ErrorInvalidRange, p.Port.Src.Begin, p.Port.Src.End)
+	}
+	if p.Port.Dst.Begin > p.Port.Dst.End {
+		return fmt.Errorf("%w: port %d-%d", ErrorInvalidRange, p.Port.Dst.Begin, p.Port.Dst.End)
+	}
+
+	if p.Response != ResponseAccept && p.Response != ResponseDrop {
+		return fmt.Errorf("%w: %d", ErrorInvalidResponse, p.Response)
+	}
+	if p.Flags&^flagMask != 0 {
+		return fmt.Errorf("%w: %d", ErrorInvalidFlags, p.Flags)
+	}
+	return
+}
@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package net
+
+import (
+	"errors"
+	"testing"
+)
+
+func newPolicy(src, dst string) (policy Policy) {
+	policy.Addr.Src.Begin, policy.Addr.Dst.Begin = src, dst
+	policy.Protocol.Begin, policy.Protocol.End = ProtocolAnyBegin, ProtocolAnyEnd
+	policy.Port.Src.Begin, policy.Port.Src.End = PortAnyBegin, PortAnyEnd
+	policy.Port.Dst.Begin, policy.Port.Dst.End = PortAnyBegin, PortAnyEnd
+	policy.Flags = FlagInbound | FlagOutbound
+	return
+}
+
+func TestPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(p *Policy)
+		err    error
+	}{
+		{"any protocol", func(p *Policy) {}, nil},
+		{"ipip", func(p *Policy) { p.Protocol.Begin, p.Protocol.End = 4, 4 }, nil},
+		{"ipv6 encapsulation", func(p *Policy) { p.Protocol.Begin, p.Protocol.End = 41, 41 }, nil},
+		{"ospf", func(p *Policy) { p.Protocol.Begin, p.Protocol.End = 89, 89 }, nil},
+		{"pim", func(p *Policy) { p.Protocol.Begin, p.Protocol.End = 103, 103 }, nil},
+		{"reserved", func(p *Policy) { p.Protocol.Begin, p.Protocol.End = 255, 255 }, nil},
+		{"protocol range", func(p *Policy) { p.Protocol.Begin, p.Protocol.End = 17, 6 }, ErrorInvalidRange},
+		{"port range", func(p *Policy) { p.Port.Dst.Begin, p.Port.Dst.End = 443, 80 }, ErrorInvalidRange},
+		{"address range", func(p *Policy) { p.Addr.Src.Begin, p.Addr.Src.End = "10.0.0.2", "10.0.0.1" }, ErrorInvalidRange},
+		{"address", func(p *Policy) { p.Addr.Dst.Begin, p.Addr.Dst.End = "host", "host" }, ErrorInvalidAddr},
+		{"family", func(p *Policy) { p.Addr.Dst.Begin, p.Addr.Dst.End = "::1", "::1" }, ErrorFamilyMismatch},
+		{"response", func(p *Policy) { p.Response = 2 }, ErrorInvalidResponse},
+		{"flags", func(p *Policy) { p.Flags = 1 << 4 }, ErrorInvalidFlags},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := newPolicy("10.0.0.1", "10.0.0.0/8")
+			test.modify(&policy)
+			if err := policy.Normalize(); err != nil {
+				t.Fatal(err)
+			}
+			err := policy.Validate()
+			if !errors.Is(err, test.err) {
+				t.Errorf("got %v, want %v", err, test.err)
+			}
+		})
+	}
+}
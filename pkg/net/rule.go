@@ -0,0 +1,364 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package net
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrorInvalidRule = errors.New("invalid net policy rule")
+)
+
+const (
+	addrAnyBegin     = "0.0.0.0"
+	addrAnyEnd       = "255.255.255.255"
+	addrAnyBeginIPv6 = "::"
+	addrAnyEndIPv6   = "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"
+)
+
+// families 是规则中可以使用的协议族名称
+var families = map[string]int{
+	"ip4":   FamilyIPv4,
+	"ipv4":  FamilyIPv4,
+	"inet":  FamilyIPv4,
+	"ip6":   FamilyIPv6,
+	"ipv6":  FamilyIPv6,
+	"inet6": FamilyIPv6,
+}
+
+// services 是规则中可以使用的服务名称
+var services = map[string]uint16{
+	"ftp":        21,
+	"ssh":        22,
+	"telnet":     23,
+	"smtp":       25,
+	"dns":        53,
+	"http":       80,
+	"pop3":       110,
+	"ntp":        123,
+	"imap":       143,
+	"snmp":       161,
+	"ldap":       389,
+	"https":      443,
+	"smtps":      465,
+	"submission": 587,
+	"imaps":      993,
+	"pop3s":      995,
+	"mysql":      3306,
+	"rdp":        3389,
+	"postgresql": 5432,
+	"redis":      6379,
+}
+
+func invalidRule(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrorInvalidRule, fmt.Sprintf(format, args...))
+}
+
+// parseRange 解析 n 或者 n-m 形式的数字范围
+func parseRange(value string, bitSize int) (begin, end uint64, err error) {
+	first, last, found := strings.Cut(value, "-")
+	if begin, err = strconv.ParseUint(first, 10, bitSize); err != nil {
+		return
+	}
+	end = begin
+	if found {
+		end, err = strconv.ParseUint(last, 10, bitSize)
+	}
+	return
+}
+
+func parseProtocol(policy *Policy, value string) (err error) {
+	if value == "any" {
+		policy.Protocol.Begin, policy.Protocol.End = ProtocolAnyBegin, ProtocolAnyEnd
+		return
+	}
+	if number, ok := protocols[value]; ok {
+		policy.Protocol.Begin, policy.Protocol.End = number, number
+		return
+	}
+	begin, end, err := parseRange(value, 8)
+	if err != nil {
+		return invalidRule("protocol %q", value)
+	}
+	policy.Protocol.Begin, policy.Protocol.End = uint8(begin), uint8(end)
+	return
+}
+
+func parsePort(value string) (begin, end uint16, err error) {
+	if value == "any" {
+		return PortAnyBegin, PortAnyEnd, nil
+	}
+	if port, ok := services[value]; ok {
+		return port, port, nil
+	}
+	first, last, err := parseRange(value, 16)
+	if err != nil {
+		err = invalidRule("port %q", value)
+		return
+	}
+	return uint16(first), uint16(last), nil
+}
+
+// parseHost 解析 any、单个地址、CIDR 和 a-b 形式的地址范围, any 返回空地址,由 ParseRule 按协议族填充
+func parseHost(value string) (begin, end string, err error) {
+	if value == "any" {
+		return
+	}
+	if first, last, found := strings.Cut(value, "-"); found {
+		return first, last, nil
+	}
+	begin = value
+	err = normalizeAddr(&begin, &end)
+	return
+}
+
+// parseEndpoint 解析 host[:port] 形式的地址和端口, IPv6 地址指定端口时需要写成 [host]:port
+func parseEndpoint(value string) (begin, end string, portBegin, portEnd uint16, err error) {
+	host, port := value, "any"
+	if strings.HasPrefix(value, "[") {
+		index := strings.Index(value, "]")
+		if index == -1 {
+			err = invalidRule("endpoint %q", value)
+			return
+		}
+		host = value[1:index]
+		if rest := value[index+1:]; rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				err = invalidRule("endpoint %q", value)
+				return
+			}
+			port = rest[1:]
+		}
+	} else if strings.Count(value, ":") == 1 {
+		host, port, _ = strings.Cut(value, ":")
+	}
+	if begin, end, err = parseHost(host); err != nil {
+		return
+	}
+	portBegin, portEnd, err = parsePort(port)
+	return
+}
+
+// ParseRule 解析文本形式的网络策略,格式为
+//
+//	<allow|deny> [in|out] [ip4|ip6] [protocol] <src>[:port] -> <dst>[:port] [prio n] [handshake] [header-only]
+//
+// 地址可以是 any、单个地址、CIDR 或者 a-b 形式的范围,端口可以是 any、端口号、范围或者服务名称,
+// 协议可以是 any、协议名称、协议号或者范围.没有指定方向时同时匹配入站和出站.
+// any 地址的协议族与另一端的地址相同,两端都是 any 时由 ip4 或 ip6 指定,默认为 IPv4.
+// 例如 deny out tcp 10.0.0.0/8 -> any:22 prio 10, allow in tcp [2001:db8::/32]:any -> any:ssh
+func ParseRule(text string) (policy Policy, err error) {
+	tokens := strings.Fields(strings.ToLower(text))
+	arrow := -1
+	for i, token := range tokens {
+		if token == "->" {
+			arrow = i
+			break
+		}
+	}
+	if arrow < 2 || arrow+1 >= len(tokens) {
+		err = invalidRule("expected <action> [direction] [family] [protocol] <src> -> <dst>")
+		return
+	}
+
+	switch tokens[0] {
+	case "allow", "accept":
+		policy.Response = ResponseAccept
+	case "deny", "drop":
+		policy.Response = ResponseDrop
+	default:
+		err = invalidRule("action %q", tokens[0])
+		return
+	}
+
+	head := tokens[1 : arrow-1]
+	policy.Flags = FlagInbound | FlagOutbound
+	if len(head) != 0 {
+		switch head[0] {
+		case "in":
+			policy.Flags, head = FlagInbound, head[1:]
+		case "out":
+			policy.Flags, head = FlagOutbound, head[1:]
+		case "inout":
+			head = head[1:]
+		}
+	}
+	family := 0
+	if len(head) != 0 {
+		if value, ok := families[head[0]]; ok {
+			family, head = value, head[1:]
+		}
+	}
+	policy.Protocol.Begin, policy.Protocol.End = ProtocolAnyBegin, ProtocolAnyEnd
+	switch len(head) {
+	case 0:
+	case 1:
+		if err = parseProtocol(&policy, head[0]); err != nil {
+			return
+		}
+	default:
+		err = invalidRule("unexpected %q", strings.Join(head, " "))
+		return
+	}
+
+	src, dst := &policy.Addr.Src, &policy.Addr.Dst
+	if src.Begin, src.End, policy.Port.Src.Begin, policy.Port.Src.End, err = parseEndpoint(tokens[arrow-1]); err != nil {
+		return
+	}
+	if dst.Begin, dst.End, policy.Port.Dst.Begin, policy.Port.Dst.End, err = parseEndpoint(tokens[arrow+1]); err != nil {
+		return
+	}
+	if err = fillAny(&policy, family); err != nil {
+		return
+	}
+
+	for options := tokens[arrow+2:]; len(options) != 0; options = options[1:] {
+		switch options[0] {
+		case "prio", "priority":
+			if len(options) < 2 {
+				err = invalidRule("missing priority")
+				return
+			}
+			priority, parseErr := strconv.ParseInt(options[1], 10, 8)
+			if parseErr != nil {
+				err = invalidRule("priority %q", options[1])
+				return
+			}
+			policy.Priority = int8(priority)
+			options = options[1:]
+		case "handshake":
+			policy.Flags |= FlagTCPHandshake
+		case "header-only":
+			policy.Flags |= FlagTCPHeaderOnly
+		default:
+			err = invalidRule("unexpected %q", options[0])
+			return
+		}
+	}
+
+	if err = policy.Normalize(); err != nil {
+		return
+	}
+	err = policy.Validate()
+	return
+}
+
+// fillAny 把 any 地址填充为协议族的全部地址, family 为 0 时使用另一端地址的协议族
+func fillAny(policy *Policy, family int) (err error) {
+	hosts := []*string{&policy.Addr.Src.Begin, &policy.Addr.Dst.Begin}
+	for _, host := range hosts {
+		if *host == "" {
+			continue
+		}
+		current := AddrFamily(*host)
+		if family == 0 {
+			family = current
+		}
+		if current != 0 && current != family {
+			return fmt.Errorf("%w: %s", ErrorFamilyMismatch, *host)
+		}
+	}
+	if family == 0 {
+		family = FamilyIPv4
+	}
+	src, dst := &policy.Addr.Src, &policy.Addr.Dst
+	if src.Begin == "" {
+		src.Begin, src.End = anyRange(family)
+	}
+	if dst.Begin == "" {
+		dst.Begin, dst.End = anyRange(family)
+	}
+	return
+}
+
+func formatRange(begin, end uint64) string {
+	if begin == end {
+		return strconv.FormatUint(begin, 10)
+	}
+	return fmt.Sprintf("%d-%d", begin, end)
+}
+
+func isAny(begin, end string) bool {
+	return (begin == addrAnyBegin && end == addrAnyEnd) || (begin == addrAnyBeginIPv6 && end == addrAnyEndIPv6)
+}
+
+// formatHost 尽量使用 any 或者 CIDR 表示地址范围
+func formatHost(begin, end string) string {
+	if isAny(begin, end) {
+		return "any"
+	}
+	if begin == end {
+		return begin
+	}
+	if first, err := netip.ParseAddr(begin); err == nil {
+		for bits := first.BitLen(); bits >= 0; bits-- {
+			prefix := netip.PrefixFrom(first, bits)
+			if prefix.Masked().Addr() != first {
+				break
+			}
+			if _, last, err := prefixRange(prefix.String()); err == nil && last.String() == end {
+				return prefix.String()
+			}
+		}
+	}
+	return begin + "-" + end
+}
+
+func formatEndpoint(begin, end string, portBegin, portEnd uint16) string {
+	host := formatHost(begin, end)
+	if portBegin == PortAnyBegin && portEnd == PortAnyEnd {
+		return host
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return host + ":" + formatRange(uint64(portBegin), uint64(portEnd))
+}
+
+// Rule 把策略转换成 ParseRule 可以解析的文本
+func (p *Policy) Rule() string {
+	tokens := []string{"allow"}
+	if p.Response == ResponseDrop {
+		tokens[0] = "deny"
+	}
+
+	switch p.Flags & (FlagInbound | FlagOutbound) {
+	case FlagInbound:
+		tokens = append(tokens, "in")
+	case FlagOutbound:
+		tokens = append(tokens, "out")
+	}
+
+	// 两端都是 any 时无法从地址推断协议族
+	if p.Family() == FamilyIPv6 && isAny(p.Addr.Src.Begin, p.Addr.Src.End) && isAny(p.Addr.Dst.Begin, p.Addr.Dst.End) {
+		tokens = append(tokens, "ip6")
+	}
+
+	if p.Protocol.Begin != ProtocolAnyBegin || p.Protocol.End != ProtocolAnyEnd {
+		name, ok := protocolName(p.Protocol.Begin)
+		if !ok || p.Protocol.Begin != p.Protocol.End {
+			name = formatRange(uint64(p.Protocol.Begin), uint64(p.Protocol.End))
+		}
+		tokens = append(tokens, name)
+	}
+
+	tokens = append(tokens,
+		formatEndpoint(p.Addr.Src.Begin, p.Addr.Src.End, p.Port.Src.Begin, p.Port.Src.End),
+		"->",
+		formatEndpoint(p.Addr.Dst.Begin, p.Addr.Dst.End, p.Port.Dst.Begin, p.Port.Dst.End))
+
+	if p.Priority != 0 {
+		tokens = append(tokens, "prio", strconv.Itoa(int(p.Priority)))
+	}
+	if p.Flags&FlagTCPHandshake != 0 {
+		tokens = append(tokens, "handshake")
+	}
+	if p.Flags&FlagTCPHeaderOnly != 0 {
+		tokens = append(tokens, "header-only")
+	}
+	return strings.Join(tokens, " ")
+}
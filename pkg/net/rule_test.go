@@ -0,0 +1,219 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+package net
+
+import (
+	"errors"
+	"testing"
+)
+
+// addrRange 和 portRange 按 begin, end 的顺序描述期望的范围
+type addrRange [2]string
+type portRange [2]uint16
+
+type expectedPolicy struct {
+	response uint32
+	flags    int32
+	priority int8
+	protocol [2]uint8
+	src, dst addrRange
+	sport    portRange
+	dport    portRange
+}
+
+var (
+	anyIPv4 = addrRange{addrAnyBegin, addrAnyEnd}
+	anyIPv6 = addrRange{addrAnyBeginIPv6, addrAnyEndIPv6}
+	anyPort = portRange{PortAnyBegin, PortAnyEnd}
+)
+
+func (e expectedPolicy) check(t *testing.T, policy Policy) {
+	t.Helper()
+	got := expectedPolicy{
+		response: policy.Response,
+		flags:    policy.Flags,
+		priority: policy.Priority,
+		protocol: [2]uint8{policy.Protocol.Begin, policy.Protocol.End},
+		src:      addrRange{policy.Addr.Src.Begin, policy.Addr.Src.End},
+		dst:      addrRange{policy.Addr.Dst.Begin, policy.Addr.Dst.End},
+		sport:    portRange{policy.Port.Src.Begin, policy.Port.Src.End},
+		dport:    portRange{policy.Port.Dst.Begin, policy.Port.Dst.End},
+	}
+	if got != e {
+		t.Errorf("got %+v, want %+v", got, e)
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		text string
+		want expectedPolicy
+	}{
+		{
+			text: "allow any -> any",
+			want: expectedPolicy{
+				response: ResponseAccept, flags: FlagInbound | FlagOutbound,
+				protocol: [2]uint8{ProtocolAnyBegin, ProtocolAnyEnd},
+				src:      anyIPv4, dst: anyIPv4, sport: anyPort, dport: anyPort,
+			},
+		},
+		{
+			text: "deny out tcp 10.0.0.0/8 -> any:22 prio 10",
+			want: expectedPolicy{
+				response: ResponseDrop, flags: FlagOutbound, priority: 10,
+				protocol: [2]uint8{6, 6},
+				src:      addrRange{"10.0.0.0", "10.255.255.255"}, dst: anyIPv4,
+				sport: anyPort, dport: portRange{22, 22},
+			},
+		},
+		{
+			text: "drop in udp 192.168.1.10-192.168.1.20:1000-2000 -> 10.0.0.1:dns handshake",
+			want: expectedPolicy{
+				response: ResponseDrop, flags: FlagInbound | FlagTCPHandshake,
+				protocol: [2]uint8{17, 17},
+				src:      addrRange{"192.168.1.10", "192.168.1.20"}, dst: addrRange{"10.0.0.1", "10.0.0.1"},
+				sport: portRange{1000, 2000}, dport: portRange{53, 53},
+			},
+		},
+		{
+			text: "deny 6-17 1.2.3.4 -> 5.6.7.0/24:https header-only",
+			want: expectedPolicy{
+				response: ResponseDrop, flags: FlagInbound | FlagOutbound | FlagTCPHeaderOnly,
+				protocol: [2]uint8{6, 17},
+				src:      addrRange{"1.2.3.4", "1.2.3.4"}, dst: addrRange{"5.6.7.0", "5.6.7.255"},
+				sport: anyPort, dport: portRange{443, 443},
+			},
+		},
+		{
+			text: "deny out 89 any -> any",
+			want: expectedPolicy{
+				response: ResponseDrop, flags: FlagOutbound,
+				protocol: [2]uint8{89, 89},
+				src:      anyIPv4, dst: anyIPv4, sport: anyPort, dport: anyPort,
+			},
+		},
+		{
+			text: "allow in tcp [2001:DB8::/32]:any -> any:ssh",
+			want: expectedPolicy{
+				response: ResponseAccept, flags: FlagInbound,
+				protocol: [2]uint8{6, 6},
+				src:      addrRange{"2001:db8::", "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"}, dst: anyIPv6,
+				sport: anyPort, dport: portRange{22, 22},
+			},
+		},
+		{
+			text: "allow ip6 any -> any",
+			want: expectedPolicy{
+				response: ResponseAccept, flags: FlagInbound | FlagOutbound,
+				protocol: [2]uint8{ProtocolAnyBegin, ProtocolAnyEnd},
+				src:      anyIPv6, dst: anyIPv6, sport: anyPort, dport: anyPort,
+			},
+		},
+		{
+			text: "deny [::1-::5]:22 -> [fe80::1]",
+			want: expectedPolicy{
+				response: ResponseDrop, flags: FlagInbound | FlagOutbound,
+				protocol: [2]uint8{ProtocolAnyBegin, ProtocolAnyEnd},
+				src:      addrRange{"::1", "::5"}, dst: addrRange{"fe80::1", "fe80::1"},
+				sport: portRange{22, 22}, dport: anyPort,
+			},
+		},
+		{
+			text: "deny [::ffff:10.0.0.1]:22 -> any",
+			want: expectedPolicy{
+				response: ResponseDrop, flags: FlagInbound | FlagOutbound,
+				protocol: [2]uint8{ProtocolAnyBegin, ProtocolAnyEnd},
+				src:      addrRange{"10.0.0.1", "10.0.0.1"}, dst: anyIPv4,
+				sport: portRange{22, 22}, dport: anyPort,
+			},
+		},
+		{
+			text: "deny icmpv6 2001:db8:0:0::1 -> 2001:db8::/64",
+			want: expectedPolicy{
+				response: ResponseDrop, flags: FlagInbound | FlagOutbound,
+				protocol: [2]uint8{58, 58},
+				src:      addrRange{"2001:db8::1", "2001:db8::1"}, dst: addrRange{"2001:db8::", "2001:db8::ffff:ffff:ffff:ffff"},
+				sport: anyPort, dport: anyPort,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			policy, err := ParseRule(test.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.want.check(t, policy)
+		})
+	}
+}
+
+func TestParseRuleError(t *testing.T) {
+	tests := []struct {
+		text string
+		err  error
+	}{
+		{"permit any -> any", ErrorInvalidRule},
+		{"deny out tcp any any", ErrorInvalidRule},
+		{"deny out tcp any -> any:99999", ErrorInvalidRule},
+		{"deny out tcp any -> any prio", ErrorInvalidRule},
+		{"deny out tcp any -> any prio 200", ErrorInvalidRule},
+		{"deny out tcp any -> any established", ErrorInvalidRule},
+		{"deny 256 any -> any", ErrorInvalidRule},
+		{"deny [::1:22 -> any", ErrorInvalidRule},
+		{"deny out tcp 10.0.0.0/33 -> any", ErrorInvalidAddr},
+		{"deny 10.0.0.256 -> any", ErrorInvalidAddr},
+		{"deny fe80::1%eth0 -> any", ErrorInvalidAddr},
+		{"deny out tcp 10.0.0.9-10.0.0.1 -> any", ErrorInvalidRange},
+		{"deny 17-6 any -> any", ErrorInvalidRange},
+		{"deny any:2000-1000 -> any", ErrorInvalidRange},
+		{"deny 10.0.0.1 -> ::1", ErrorFamilyMismatch},
+		{"deny ::1-10.0.0.1 -> any", ErrorFamilyMismatch},
+		{"allow ip6 10.0.0.1 -> any", ErrorFamilyMismatch},
+	}
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			_, err := ParseRule(test.text)
+			if !errors.Is(err, test.err) {
+				t.Errorf("got %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestRuleRoundTrip(t *testing.T) {
+	tests := []struct {
+		text string
+		rule string
+	}{
+		{"allow any -> any", "allow any -> any"},
+		{"deny out tcp 10.0.0.0/8 -> any:22 prio 10", "deny out tcp 10.0.0.0/8 -> any:22 prio 10"},
+		{"drop in udp 192.168.1.10-192.168.1.20:1000-2000 -> 10.0.0.1:dns handshake", "deny in udp 192.168.1.10-192.168.1.20:1000-2000 -> 10.0.0.1:53 handshake"},
+		{"deny 6-17 1.2.3.4 -> 5.6.7.0/24:https header-only", "deny 6-17 1.2.3.4 -> 5.6.7.0/24:443 header-only"},
+		{"deny out 89 any -> any", "deny out 89 any -> any"},
+		{"accept inout TCP any:1024-65535 -> 10.0.0.0/31:http", "allow tcp any:1024-65535 -> 10.0.0.0/31:80"},
+		{"allow in tcp [2001:DB8::/32]:any -> any:ssh", "allow in tcp 2001:db8::/32 -> any:22"},
+		{"allow ip6 any -> any", "allow ip6 any -> any"},
+		{"deny [::1-::5]:22 -> [fe80::1]", "deny [::1-::5]:22 -> fe80::1"},
+		{"deny [::ffff:10.0.0.1]:22 -> any", "deny 10.0.0.1:22 -> any"},
+		{"deny icmpv6 2001:db8:0:0::1 -> 2001:db8::/64", "deny icmpv6 2001:db8::1 -> 2001:db8::/64"},
+	}
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			policy, err := ParseRule(test.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rule := policy.Rule()
+			if rule != test.rule {
+				t.Errorf("got %q, want %q", rule, test.rule)
+			}
+			again, err := ParseRule(rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again != policy {
+				t.Errorf("got %+v, want %+v", again, policy)
+			}
+		})
+	}
+}